@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticator decides whether an incoming HTTP request (from a pair-ls LSP
+// connecting to a relay or signal server) is allowed to connect. On success it
+// returns the identity of the client, or "" if the client is anonymous.
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// AuthenticatorFunc adapts a plain function to the Authenticator interface
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// AllowAll is an Authenticator that accepts every request anonymously
+var AllowAll Authenticator = AuthenticatorFunc(func(r *http.Request) (string, error) {
+	return "", nil
+})
+
+type allAuthenticator []Authenticator
+
+// RequireAll creates an Authenticator that only succeeds if every one of the
+// provided Authenticators succeeds. The identity is the first non-empty
+// identity returned.
+func RequireAll(authenticators ...Authenticator) Authenticator {
+	return allAuthenticator(authenticators)
+}
+
+func (a allAuthenticator) Authenticate(r *http.Request) (string, error) {
+	identity := ""
+	for _, authenticator := range a {
+		id, err := authenticator.Authenticate(r)
+		if err != nil {
+			return "", err
+		}
+		if identity == "" {
+			identity = id
+		}
+	}
+	return identity, nil
+}
+
+type passwordAuthenticator struct {
+	username string
+	password string
+}
+
+// NewPasswordAuthenticator checks HTTP Basic auth credentials. If username is
+// empty, any username is accepted, and so is the password on its own without
+// a username (which is what older clients send).
+func NewPasswordAuthenticator(username string, password string) Authenticator {
+	return &passwordAuthenticator{
+		username: username,
+		password: password,
+	}
+}
+
+func (a *passwordAuthenticator) Authenticate(r *http.Request) (string, error) {
+	creds, err := decodeBasicAuth(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	username, password := splitCredentials(creds)
+	// Always run every comparison so the timing doesn't reveal which one failed
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(a.username))
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(a.password))
+	if a.username == "" {
+		// Older clients send a bare password, which may contain a ':'
+		bareOk := subtle.ConstantTimeCompare([]byte(creds), []byte(a.password))
+		userOk = 1
+		passOk |= bareOk
+	}
+	if userOk&passOk != 1 {
+		return "", errors.New("Username or password mismatch")
+	}
+	// Passwords are shared secrets, so they don't identify a specific client
+	return "", nil
+}
+
+// ParseBasicAuth parses the value of an HTTP Basic Authorization header. For
+// backwards compatibility, a decoded value with no ':' is treated as a bare
+// password.
+func ParseBasicAuth(header string) (username string, password string, err error) {
+	creds, err := decodeBasicAuth(header)
+	if err != nil {
+		return "", "", err
+	}
+	username, password = splitCredentials(creds)
+	return username, password, nil
+}
+
+func decodeBasicAuth(header string) (string, error) {
+	if header == "" {
+		return "", errors.New("Missing Authorization header")
+	}
+	const prefix = "basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", errors.New("Malformed Authorization header")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[len(prefix):]))
+	if err != nil {
+		return "", fmt.Errorf("Malformed Authorization header: %w", err)
+	}
+	return string(decoded), nil
+}
+
+func splitCredentials(creds string) (username string, password string) {
+	i := strings.IndexByte(creds, ':')
+	if i < 0 {
+		return "", creds
+	}
+	return creds[:i], creds[i+1:]
+}
+
+// FormatBasicAuth creates the value of an HTTP Basic Authorization header
+func FormatBasicAuth(username string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+type certAuthenticator struct {
+	subjects   []string
+	identities map[string]string
+}
+
+// NewClientCertAuthenticator requires that the request was made over TLS with
+// a verified client certificate. If subjects is non-empty, the leaf
+// certificate's Common Name or one of its Subject Alternative Names must match
+// one of them. The identity of the client is looked up from those same names
+// in the identities map, falling back to the first name on the certificate.
+func NewClientCertAuthenticator(subjects []string, identities map[string]string) Authenticator {
+	return &certAuthenticator{
+		subjects:   subjects,
+		identities: identities,
+	}
+}
+
+func (a *certAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil {
+		return "", errors.New("Client certificate required, but connection is not using TLS")
+	}
+	if len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", errors.New("No valid client certificate")
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	names := CertNames(leaf)
+	if len(a.subjects) > 0 && !anyMatch(names, a.subjects) {
+		return "", fmt.Errorf("Client certificate subject %q is not allowed", leaf.Subject.CommonName)
+	}
+	return CertIdentity(leaf, a.identities), nil
+}
+
+func anyMatch(names []string, allowed []string) bool {
+	for _, name := range names {
+		for _, subject := range allowed {
+			if name == subject {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CertIdentity maps a certificate to the identity of its owner. Any Common
+// Name or Subject Alternative Name found in identities is used, otherwise the
+// identity is the first of those names.
+func CertIdentity(cert *x509.Certificate, identities map[string]string) string {
+	names := CertNames(cert)
+	for _, name := range names {
+		if identity, ok := identities[name]; ok {
+			return identity
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// CertNames returns the Common Name and all DNS, email, and URI Subject
+// Alternative Names of a certificate
+func CertNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs))
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func basicAuth(creds string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+}
+
+func requestWithAuth(header string) *http.Request {
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	if header != "" {
+		r.Header.Set("Authorization", header)
+	}
+	return r
+}
+
+func TestParseBasicAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		username string
+		password string
+		err      bool
+	}{
+		{name: "username and password", header: basicAuth("alice:secret"), username: "alice", password: "secret"},
+		{name: "password with a colon", header: basicAuth("alice:a:b"), username: "alice", password: "a:b"},
+		{name: "empty username", header: basicAuth(":secret"), password: "secret"},
+		{name: "bare password", header: basicAuth("secret"), password: "secret"},
+		{name: "lowercase scheme", header: "basic " + base64.StdEncoding.EncodeToString([]byte("a:b")), username: "a", password: "b"},
+		{name: "formatted", header: FormatBasicAuth("bob", "pw"), username: "bob", password: "pw"},
+		{name: "missing", header: "", err: true},
+		{name: "other scheme", header: "Bearer abc", err: true},
+		{name: "bad base64", header: "Basic !!!", err: true},
+	}
+	for _, test := range tests {
+		username, password, err := ParseBasicAuth(test.header)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if username != test.username || password != test.password {
+			t.Errorf("%s: got %q, %q, want %q, %q", test.name, username, password, test.username, test.password)
+		}
+	}
+}
+
+func TestPasswordAuthenticator(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		header   string
+		ok       bool
+	}{
+		{"matching credentials", "alice", "secret", FormatBasicAuth("alice", "secret"), true},
+		{"wrong password", "alice", "secret", FormatBasicAuth("alice", "secreT"), false},
+		{"wrong username", "alice", "secret", FormatBasicAuth("bob", "secret"), false},
+		{"both wrong", "alice", "secret", FormatBasicAuth("bob", "nope"), false},
+		{"password prefix", "alice", "secret", FormatBasicAuth("alice", "secre"), false},
+		{"bare password when a username is required", "alice", "secret", basicAuth("secret"), false},
+		{"no header", "alice", "secret", "", false},
+		{"any username", "", "secret", FormatBasicAuth("whoever", "secret"), true},
+		{"empty username", "", "secret", FormatBasicAuth("", "secret"), true},
+		{"bare password", "", "secret", basicAuth("secret"), true},
+		{"bare password with a colon", "", "se:cret", basicAuth("se:cret"), true},
+		{"password with a colon", "", "se:cret", FormatBasicAuth("", "se:cret"), true},
+		{"wrong bare password", "", "secret", basicAuth("public"), false},
+		{"empty password", "", "secret", FormatBasicAuth("", ""), false},
+	}
+	for _, test := range tests {
+		authenticator := NewPasswordAuthenticator(test.username, test.password)
+		identity, err := authenticator.Authenticate(requestWithAuth(test.header))
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if identity != "" {
+			t.Errorf("%s: got identity %q", test.name, identity)
+		}
+	}
+}
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	r := requestWithAuth("")
+	r.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return r
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/editor")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		DNSNames:       []string{"alice.example.com"},
+		EmailAddresses: []string{"alice@example.com"},
+		URIs:           []*url.URL{spiffe},
+	}
+	plaintext := requestWithAuth("")
+
+	tests := []struct {
+		name       string
+		subjects   []string
+		identities map[string]string
+		r          *http.Request
+		identity   string
+		err        bool
+	}{
+		{name: "any certificate", r: requestWithCert(cert), identity: "alice"},
+		{name: "allowed common name", subjects: []string{"bob", "alice"}, r: requestWithCert(cert), identity: "alice"},
+		{name: "allowed DNS name", subjects: []string{"alice.example.com"}, r: requestWithCert(cert), identity: "alice"},
+		{name: "allowed URI", subjects: []string{"spiffe://example.com/editor"}, r: requestWithCert(cert), identity: "alice"},
+		{name: "subject not allowed", subjects: []string{"bob"}, r: requestWithCert(cert), err: true},
+		{
+			name:       "mapped identity",
+			identities: map[string]string{"alice@example.com": "team-a"},
+			r:          requestWithCert(cert),
+			identity:   "team-a",
+		},
+		{name: "no verified certificate", r: requestWithCert(nil), err: true},
+		{name: "not using TLS", r: plaintext, err: true},
+		{name: "certificate without names", r: requestWithCert(&x509.Certificate{}), identity: ""},
+	}
+	for _, test := range tests {
+		authenticator := NewClientCertAuthenticator(test.subjects, test.identities)
+		identity, err := authenticator.Authenticate(test.r)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if identity != test.identity {
+			t.Errorf("%s: got identity %q, want %q", test.name, identity, test.identity)
+		}
+	}
+}
+
+func TestRequireAll(t *testing.T) {
+	identity := func(id string) Authenticator {
+		return AuthenticatorFunc(func(r *http.Request) (string, error) {
+			return id, nil
+		})
+	}
+	fail := AuthenticatorFunc(func(r *http.Request) (string, error) {
+		return "", errors.New("denied")
+	})
+
+	tests := []struct {
+		name           string
+		authenticators []Authenticator
+		identity       string
+		err            bool
+	}{
+		{name: "none", identity: ""},
+		{name: "anonymous", authenticators: []Authenticator{AllowAll, AllowAll}, identity: ""},
+		{name: "first identity wins", authenticators: []Authenticator{AllowAll, identity("a"), identity("b")}, identity: "a"},
+		{name: "one fails", authenticators: []Authenticator{identity("a"), fail}, err: true},
+		{name: "first fails", authenticators: []Authenticator{fail, identity("a")}, err: true},
+	}
+	for _, test := range tests {
+		id, err := RequireAll(test.authenticators...).Authenticate(requestWithAuth(""))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if id != test.identity {
+			t.Errorf("%s: got identity %q, want %q", test.name, id, test.identity)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeyTypes lists the supported values for GenerateKey
+var KeyTypes = []string{"ecdsa-p256", "ecdsa-p384", "ed25519", "rsa-2048", "rsa-3072", "rsa-4096"}
+
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa-4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return nil, fmt.Errorf("Unknown key type %q (must be one of %s)", keyType, strings.Join(KeyTypes, ", "))
+}
+
+// NewSerialNumber creates a random 128-bit certificate serial number
+func NewSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+type CertUsage int
+
+const (
+	UsageCA CertUsage = iota
+	UsageServer
+	UsageClient
+	// Legacy self-signed cert that is used as CA, server, and client cert
+	UsageAll
+)
+
+type CertOptions struct {
+	CommonName     string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	Validity       time.Duration
+	Usage          CertUsage
+}
+
+// CreateCertificate creates a DER-encoded certificate for key. If parent is
+// nil, the certificate is self-signed.
+func CreateCertificate(opts CertOptions, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) ([]byte, error) {
+	serial, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"PairLS"},
+			CommonName:   opts.CommonName,
+		},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(opts.Validity),
+		BasicConstraintsValid: true,
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPAddresses,
+		EmailAddresses:        opts.EmailAddresses,
+	}
+	// Only RSA keys are used for key encipherment
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, isRSA := key.(*rsa.PrivateKey); isRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	switch opts.Usage {
+	case UsageCA:
+		template.IsCA = true
+		template.MaxPathLenZero = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	case UsageServer:
+		template.KeyUsage = keyUsage
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case UsageClient:
+		template.KeyUsage = keyUsage
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	case UsageAll:
+		template.IsCA = true
+		template.KeyUsage = keyUsage | x509.KeyUsageCertSign
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	} else if now.Add(opts.Validity).After(parent.NotAfter) {
+		return nil, fmt.Errorf("Certificate would outlive its CA (CA expires %s)", parent.NotAfter.Format(time.RFC3339))
+	}
+	return x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+}
+
+// CertificatePEM wraps a DER-encoded certificate in a PEM block
+func CertificatePEM(der []byte) *pem.Block {
+	return &pem.Block{Type: "CERTIFICATE", Bytes: der}
+}
+
+// PrivateKeyPEM encodes a private key as a PKCS #8 PEM block
+func PrivateKeyPEM(key crypto.Signer) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// WritePEMFile writes PEM blocks to a file, replacing any existing contents
+func WritePEMFile(filename string, perm os.FileMode, blocks ...*pem.Block) error {
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := pem.Encode(out, block); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// LoadCA loads a CA certificate and its private key from PEM files. The key
+// may also be contained in certFile, in which case keyFile can be empty.
+func LoadCA(certFile string, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	tlsCert, _, err := LoadCertFromPEMs(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("CA private key cannot be used for signing")
+	}
+	return cert, key, nil
+}
+
+// ReadCertificates parses all certificates in a PEM file, skipping other blocks
+func ReadCertificates(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, 0, 1)
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %s: %w", filename, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// Fingerprint is the SHA-256 hash of the DER-encoded certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	pieces := make([]string, len(sum))
+	for i, b := range sum {
+		pieces[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(pieces, ":")
+}
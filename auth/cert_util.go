@@ -9,11 +9,28 @@ import (
 	"io/ioutil"
 )
 
-func LoadTLSConfig(filenames ...string) (*tls.Config, error) {
-	tlsCert, pool, err := LoadCertFromPEMs(filenames...)
+// LoadTLSConfig creates a client TLS config that presents the certificate in
+// the PEM files. The server is verified against the system roots, any
+// certificates in the PEM files, and any extra CA files.
+func LoadTLSConfig(caFiles []string, filenames ...string) (*tls.Config, error) {
+	tlsCert, _, err := LoadCertFromPEMs(filenames...)
 	if err != nil {
 		return nil, err
 	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, filename := range append(caFiles, filenames...) {
+		if filename == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		pool.AppendCertsFromPEM(data)
+	}
 	return &tls.Config{
 		Certificates: []tls.Certificate{*tlsCert},
 		RootCAs:      pool,
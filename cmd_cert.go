@@ -1,17 +1,16 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"crypto"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 	"flag"
 	"fmt"
 	"log"
-	"math/big"
+	"net"
 	"os"
+	"pair-ls/auth"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rakyll/command"
@@ -27,61 +26,322 @@ func NewCertCmd(conf *PairConfig) command.Cmd {
 }
 
 func (cmd *certCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
-	fs.StringVar(&cmd.outfile, "out", "relay", "Output file")
-	fs.StringVar(&cmd.dns, "dns", "", "Domain name of the host (e.g. www.mycode.com)")
+	fs.StringVar(&cmd.outfile, "out", "relay", "Output file (when run with no subcommand)")
+	fs.StringVar(&cmd.dns, "dns", "", "Domain name of the host (e.g. www.mycode.com) (when run with no subcommand)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s cert [subcommand] [flags]
+
+Subcommands:
+  ca        Create a certificate authority
+  server    Issue a server certificate signed by a CA
+  client    Issue a client certificate signed by a CA
+  list      List certificates in PEM files or directories
+  inspect   Print details of the certificates in PEM files
+
+With no subcommand, creates a single self-signed certificate and key that can
+be used as CA, server, and client cert (not recommended).
+
+Run '%s cert <subcommand> -h' for subcommand help.
+
+Flags:
+`, os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
 	return fs
 }
 
 func (cmd *certCommand) Run(args []string) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if len(args) == 0 {
+		cmd.runSelfSigned()
+		return
+	}
+	subcommand, args := args[0], args[1:]
+	switch subcommand {
+	case "ca":
+		runCertCA(args)
+	case "server":
+		runCertLeaf(args, auth.UsageServer)
+	case "client":
+		runCertLeaf(args, auth.UsageClient)
+	case "list":
+		runCertList(args)
+	case "inspect":
+		runCertInspect(args)
+	default:
+		log.Fatalf("Unknown cert subcommand %q (must be one of ca, server, client, list, inspect)\n", subcommand)
+	}
+}
+
+func (cmd *certCommand) runSelfSigned() {
+	key, err := auth.GenerateKey("ecdsa-p256")
 	if err != nil {
 		log.Fatal(err)
 	}
-	pub := &priv.PublicKey
 
 	dns := []string{"localhost"}
 	if cmd.dns != "" {
 		dns = append(dns, cmd.dns)
 	}
-	ca := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Country:            []string{"US"},
-			Organization:       []string{"PairLS"},
-			OrganizationalUnit: []string{""},
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(5, 0, 0),
-		BasicConstraintsValid: true,
-		IsCA:                  true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		DNSNames:              dns,
-	}
-	derBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, pub, priv)
+	derBytes, err := auth.CreateCertificate(auth.CertOptions{
+		DNSNames: dns,
+		Validity: 5 * 365 * 24 * time.Hour,
+		Usage:    auth.UsageAll,
+	}, key, nil, nil)
 	if err != nil {
-		log.Println("create ca failed", err)
-		return
+		log.Fatalln("create ca failed", err)
 	}
 
+	// Legacy format: certificate and key in the same file
 	certFile := fmt.Sprintf("%s.pem", cmd.outfile)
-	certOut, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	keyBlock, err := auth.PrivateKeyPEM(key)
 	if err != nil {
-		log.Fatalf("Failed to open %s for writing: %v\n", certFile, err)
+		log.Fatalf("Unable to marshal private key: %v\n", err)
 	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
+	if err := auth.WritePEMFile(certFile, 0600, auth.CertificatePEM(derBytes), keyBlock); err != nil {
 		log.Fatalf("Failed to write data to %s: %v\n", certFile, err)
 	}
+	fmt.Printf("wrote %s\n", certFile)
+}
 
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+type keyFlags struct {
+	out      string
+	keyType  string
+	days     int
+	name     string
+	dns      string
+	ips      string
+	emails   string
+	caFile   string
+	caKey    string
+	validity time.Duration
+}
+
+func (f *keyFlags) register(fs *flag.FlagSet, defaultOut string, defaultDays int) {
+	fs.StringVar(&f.out, "out", defaultOut, "Output file prefix (writes <out>.pem and <out>.key.pem)")
+	fs.StringVar(&f.keyType, "key-type", "ecdsa-p256", fmt.Sprintf("Type of key to generate (%s)", strings.Join(auth.KeyTypes, ", ")))
+	fs.IntVar(&f.days, "days", defaultDays, "Number of days the certificate is valid")
+	fs.StringVar(&f.name, "name", "", "Common Name of the certificate")
+}
+
+func (f *keyFlags) registerSigning(fs *flag.FlagSet) {
+	fs.StringVar(&f.caFile, "ca", "ca.pem", "CA certificate used to sign")
+	fs.StringVar(&f.caKey, "ca-key", "ca.key.pem", "Private key for the CA certificate")
+	fs.StringVar(&f.dns, "dns", "", "Comma-separated DNS Subject Alternative Names")
+	fs.StringVar(&f.ips, "ip", "", "Comma-separated IP address Subject Alternative Names")
+	fs.StringVar(&f.emails, "email", "", "Comma-separated email Subject Alternative Names")
+}
+
+func (f *keyFlags) parse(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if f.days <= 0 {
+		log.Fatalln("-days must be positive")
+	}
+	f.validity = time.Duration(f.days) * 24 * time.Hour
+}
+
+func (f *keyFlags) options(usage auth.CertUsage) auth.CertOptions {
+	ips := make([]net.IP, 0)
+	for _, ipStr := range splitList(f.ips) {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			log.Fatalf("Invalid IP address %q\n", ipStr)
+		}
+		ips = append(ips, ip)
+	}
+	return auth.CertOptions{
+		CommonName:     f.name,
+		DNSNames:       splitList(f.dns),
+		IPAddresses:    ips,
+		EmailAddresses: splitList(f.emails),
+		Validity:       f.validity,
+		Usage:          usage,
+	}
+}
+
+func (f *keyFlags) write(der []byte, key crypto.Signer) {
+	certFile := f.out + ".pem"
+	keyFile := f.out + ".key.pem"
+	for _, filename := range []string{certFile, keyFile} {
+		if _, err := os.Stat(filename); err == nil {
+			log.Fatalf("%s already exists, refusing to overwrite\n", filename)
+		}
+	}
+	keyBlock, err := auth.PrivateKeyPEM(key)
 	if err != nil {
 		log.Fatalf("Unable to marshal private key: %v\n", err)
 	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		log.Fatalf("Failed to write data to %s: %v\n", certFile, err)
+	if err := auth.WritePEMFile(keyFile, 0600, keyBlock); err != nil {
+		log.Fatalf("Failed to write %s: %v\n", keyFile, err)
 	}
-	if err := certOut.Close(); err != nil {
-		log.Fatalf("Error closing %s: %v\n", certFile, err)
+	if err := auth.WritePEMFile(certFile, 0644, auth.CertificatePEM(der)); err != nil {
+		log.Fatalf("Failed to write %s: %v\n", certFile, err)
+	}
+	fmt.Printf("wrote %s and %s\n", certFile, keyFile)
+}
+
+func splitList(value string) []string {
+	ret := make([]string, 0)
+	for _, piece := range strings.Split(value, ",") {
+		piece = strings.TrimSpace(piece)
+		if piece != "" {
+			ret = append(ret, piece)
+		}
+	}
+	return ret
+}
+
+func runCertCA(args []string) {
+	var f keyFlags
+	fs := flag.NewFlagSet("cert ca", flag.ExitOnError)
+	f.register(fs, "ca", 10*365)
+	f.parse(fs, args)
+	if f.name == "" {
+		f.name = "PairLS CA"
+	}
+
+	key, err := auth.GenerateKey(f.keyType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	der, err := auth.CreateCertificate(f.options(auth.UsageCA), key, nil, nil)
+	if err != nil {
+		log.Fatalln("Failed to create CA:", err)
+	}
+	f.write(der, key)
+}
+
+func runCertLeaf(args []string, usage auth.CertUsage) {
+	var f keyFlags
+	name := "server"
+	if usage == auth.UsageClient {
+		name = "client"
+	}
+	fs := flag.NewFlagSet("cert "+name, flag.ExitOnError)
+	f.register(fs, name, 365)
+	f.registerSigning(fs)
+	f.parse(fs, args)
+	opts := f.options(usage)
+	if usage == auth.UsageServer && len(opts.DNSNames) == 0 && len(opts.IPAddresses) == 0 {
+		log.Fatalln("Server certificates need at least one -dns or -ip")
+	}
+	if usage == auth.UsageClient && opts.CommonName == "" && len(opts.DNSNames) == 0 && len(opts.EmailAddresses) == 0 {
+		log.Fatalln("Client certificates need a -name, -dns, or -email to identify the client")
+	}
+
+	ca, caKey, err := auth.LoadCA(f.caFile, f.caKey)
+	if err != nil {
+		log.Fatalln("Failed to load CA:", err)
+	}
+	key, err := auth.GenerateKey(f.keyType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	der, err := auth.CreateCertificate(opts, key, ca, caKey)
+	if err != nil {
+		log.Fatalf("Failed to create %s certificate: %v\n", name, err)
+	}
+	f.write(der, key)
+}
+
+// Expands directories into the PEM files they contain
+func findPEMFiles(args []string) []string {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	files := make([]string, 0, len(args))
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.pem"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	return files
+}
+
+func certKind(cert *x509.Certificate) string {
+	if cert.IsCA {
+		return "ca"
+	}
+	kinds := make([]string, 0, 2)
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			kinds = append(kinds, "server")
+		case x509.ExtKeyUsageClientAuth:
+			kinds = append(kinds, "client")
+		}
+	}
+	if len(kinds) == 0 {
+		return "unknown"
+	}
+	return strings.Join(kinds, "+")
+}
+
+func certStatus(cert *x509.Certificate) string {
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return "not yet valid"
+	} else if now.After(cert.NotAfter) {
+		return "EXPIRED"
+	}
+	return fmt.Sprintf("%d days left", int(cert.NotAfter.Sub(now).Hours()/24))
+}
+
+func runCertList(args []string) {
+	for _, filename := range findPEMFiles(args) {
+		certs, err := auth.ReadCertificates(filename)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, cert := range certs {
+			fmt.Printf("%s\t%s\t%s\t%s\n", filename, certKind(cert), strings.Join(auth.CertNames(cert), ","), certStatus(cert))
+		}
+	}
+}
+
+func runCertInspect(args []string) {
+	if len(args) == 0 {
+		log.Fatalln("Usage: cert inspect <file.pem>...")
+	}
+	for _, filename := range findPEMFiles(args) {
+		certs, err := auth.ReadCertificates(filename)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, cert := range certs {
+			fmt.Printf("%s:\n", filename)
+			fmt.Printf("  Type:        %s\n", certKind(cert))
+			fmt.Printf("  Subject:     %s\n", cert.Subject)
+			fmt.Printf("  Issuer:      %s\n", cert.Issuer)
+			fmt.Printf("  Serial:      %s\n", cert.SerialNumber.Text(16))
+			fmt.Printf("  Not before:  %s\n", cert.NotBefore.Format(time.RFC3339))
+			fmt.Printf("  Not after:   %s (%s)\n", cert.NotAfter.Format(time.RFC3339), certStatus(cert))
+			fmt.Printf("  Key:         %s\n", cert.PublicKeyAlgorithm)
+			if len(cert.DNSNames) > 0 {
+				fmt.Printf("  DNS names:   %s\n", strings.Join(cert.DNSNames, ", "))
+			}
+			if len(cert.IPAddresses) > 0 {
+				ips := make([]string, len(cert.IPAddresses))
+				for i, ip := range cert.IPAddresses {
+					ips[i] = ip.String()
+				}
+				fmt.Printf("  IPs:         %s\n", strings.Join(ips, ", "))
+			}
+			if len(cert.EmailAddresses) > 0 {
+				fmt.Printf("  Emails:      %s\n", strings.Join(cert.EmailAddresses, ", "))
+			}
+			fmt.Printf("  SHA-256:     %s\n", auth.Fingerprint(cert))
+		}
 	}
-	fmt.Printf("wrote %s\n", certFile)
 }
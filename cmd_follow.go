@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"pair-ls/lsp_handler"
+	"pair-ls/server"
+
+	"github.com/rakyll/command"
+)
+
+type followCommand struct {
+	config   *PairConfig
+	password string
+	name     string
+}
+
+func NewFollowCmd(conf *PairConfig) command.Cmd {
+	return &followCommand{
+		config: conf,
+	}
+}
+
+func (cmd *followCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	fs.StringVar(&cmd.password, "password", cmd.config.Server.WebPassword, "Web password for the share (defaults to $PAIR_WEB_PASS)")
+	fs.StringVar(&cmd.name, "name", "", "Name to show the editor when connecting over WebRTC")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s follow [flags] <share>
+
+Run as an LSP server in your own editor to follow a shared editor. The sharer's
+files are opened as read-only pairls:// documents, and your cursor moves along
+with theirs. Needs an editor plugin; see docs/FOLLOW.md.
+
+Flags:
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (cmd *followCommand) Run(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Missing share URL")
+		os.Exit(1)
+	}
+	f, err := os.OpenFile(cmd.config.LogFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+
+	logger := log.New(f, "[Follow]", log.Ldate|log.Ltime|log.Lshortfile)
+	handler := lsp_handler.NewFollowHandler(logger, &lsp_handler.FollowConfig{
+		Target:     args[0],
+		Password:   cmd.password,
+		Name:       cmd.name,
+		ICEServers: cmd.config.ICEServers,
+	})
+	handler.ListenOnStdin(cmd.config.LogLevel)
+}
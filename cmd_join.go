@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"pair-ls/server"
+	"pair-ls/state"
+	"pair-ls/viewer"
+
+	"github.com/rakyll/command"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+type joinCommand struct {
+	config *PairConfig
+	name   string
+	files  map[int32]string
+}
+
+func NewJoinCmd(conf *PairConfig) command.Cmd {
+	return &joinCommand{
+		config: conf,
+		files:  make(map[int32]string),
+	}
+}
+
+func (cmd *joinCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	fs.StringVar(&cmd.name, "name", "", "Name to show the editor when connecting over WebRTC")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s join [token]
+
+Connect to an editor over WebRTC without a signal server or the static site.
+If the editor started the call (pair-ls lsp -manual), pass its token.
+Otherwise a token is printed for the editor to answer.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (cmd *joinCommand) Run(args []string) {
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+	token := ""
+	if len(args) > 0 {
+		token = args[0]
+	}
+	session, conn, err := viewer.Join(context.Background(), cmd.config.ICEServers, viewer.PeerAuth{Name: cmd.name}, token, jsonrpc2.HandlerWithError(cmd.handle), os.Stdin, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	fmt.Println("Connected")
+	<-conn.DisconnectNotify()
+	fmt.Println("Disconnected")
+}
+
+// Prints what the editor is doing
+func (cmd *joinCommand) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, nil
+	}
+	switch req.Method {
+	case "initialize":
+		var params server.InitializeClient
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		for _, file := range params.Files {
+			cmd.files[file.ID] = escapeControl(file.Filename)
+			fmt.Println("Open:", cmd.files[file.ID])
+		}
+		if params.View != nil {
+			cmd.printView(*params.View)
+		}
+	case "openFile":
+		var params state.OpenFileEvent
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		cmd.files[params.ID] = escapeControl(params.Filename)
+		fmt.Println("Opened:", cmd.files[params.ID])
+	case "closeFile":
+		var params state.CloseFileEvent
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		fmt.Println("Closed:", cmd.files[params.FileID])
+		delete(cmd.files, params.FileID)
+	case "updateView":
+		var params state.ChangeViewEvent
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		cmd.printView(params.View)
+	}
+	return nil, nil
+}
+
+func (cmd *joinCommand) printView(view state.View) {
+	filename := cmd.files[view.FileID]
+	if len(view.Cursors) > 0 {
+		fmt.Printf("Viewing: %s:%d\n", filename, view.Cursors[0].Position.Line+1)
+	} else {
+		fmt.Println("Viewing:", filename)
+	}
+}
@@ -9,12 +9,14 @@ import (
 	"pair-ls/server"
 	"pair-ls/state"
 	"pair-ls/util"
+	"strings"
 
 	"github.com/rakyll/command"
 )
 
 type lspCommand struct {
 	config       *PairConfig
+	fs           *flag.FlagSet
 	host         string
 	port         int
 	forwardHost  string
@@ -31,11 +33,27 @@ func (cmd *lspCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
 	fs.StringVar(&cmd.host, "hostname", "", "Hostname for webserver to bind to")
 	fs.IntVar(&cmd.port, "port", -1, "Port for webserver to listen on")
 	addServerFlags(cmd.config, fs)
+	cmd.fs = fs
 	fs.StringVar(&cmd.forwardHost, "forward", "", "Forward to relay server (use full ws:// or wss:// url format)")
+	fs.BoolVar(&cmd.config.RelayE2E, "e2e", cmd.config.RelayE2E, "Encrypt everything forwarded to the relay server so that only viewers with the share link can read it")
 	fs.StringVar(&cmd.signalServer, "signal", "", "Connect to signal server (use full ws:// or wss:// url format)")
-	fs.StringVar(&cmd.config.CallToken, "call-token", cmd.config.CallToken, "WebRTC token copied from static server")
+	fs.StringVar(&cmd.config.CallToken, "call-token", cmd.config.CallToken, "WebRTC token copied from static server or from pair-ls join")
+	fs.BoolVar(&cmd.config.ManualRTC, "manual", cmd.config.ManualRTC, "Exchange short WebRTC tokens with pair-ls join instead of using the static WebRTC site")
+	fs.BoolVar(&cmd.config.Collaborate, "collab", cmd.config.Collaborate, "Allow viewers to edit the shared files")
+	fs.StringVar(&cmd.config.FocusRequests, "focus-requests", cmd.config.FocusRequests, "When a viewer asks you to look at something: show (jump there), prompt (ask first), or off")
+	fs.StringVar(&cmd.config.OutsideRoot, "outside-root", cmd.config.OutsideRoot, "What to do with files outside the workspace: share (under "+lsp_handler.OutsideRootPrefix+") or ignore")
+	fs.Func("schemes", "Comma-separated URI schemes of the documents to share, e.g. file,untitled (default all)", func(value string) error {
+		cmd.config.ShareSchemes = nil
+		for _, scheme := range strings.Split(value, ",") {
+			if scheme = strings.TrimSpace(scheme); scheme != "" {
+				cmd.config.ShareSchemes = append(cmd.config.ShareSchemes, scheme)
+			}
+		}
+		return nil
+	})
 	fs.StringVar(&cmd.config.Client.CertFile, "client-cert", cmd.config.Client.CertFile, "Client certificate used to connect to relay/signal server")
 	fs.StringVar(&cmd.config.Client.KeyFile, "client-key", cmd.config.Client.KeyFile, "Client key used to connect to relay/signal server")
+	fs.StringVar(&cmd.config.Client.CAFile, "ca", cmd.config.Client.CAFile, "Extra CA certificates to trust when connecting to relay/signal server")
 	return fs
 }
 
@@ -46,18 +64,42 @@ func (cmd *lspCommand) Run(args []string) {
 	}
 	defer f.Close()
 
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+	switch cmd.config.FocusRequests {
+	case lsp_handler.FocusRequestsShow, lsp_handler.FocusRequestsPrompt, lsp_handler.FocusRequestsOff:
+	default:
+		log.Fatalf("Invalid focusRequests %q (must be show, prompt, or off)", cmd.config.FocusRequests)
+	}
+	switch cmd.config.OutsideRoot {
+	case lsp_handler.OutsideRootShare, lsp_handler.OutsideRootIgnore:
+	default:
+		log.Fatalf("Invalid outsideRoot %q (must be share or ignore)", cmd.config.OutsideRoot)
+	}
+
 	state := state.NewState(log.New(f, "[State]", log.Ldate|log.Ltime|log.Lshortfile))
 
 	if cmd.port > 0 {
-		server := server.NewServer(state, log.New(f, "[Webserver]", log.Ldate|log.Ltime|log.Lshortfile), cmd.config.Server)
+		logger := log.New(f, "[Webserver]", log.Ldate|log.Ltime|log.Lshortfile)
+		server := server.NewServer(state, logger, cmd.config.Server)
+		go watchServerConfig(cmd.config, cmd.fs, server, logger)
 		go server.Serve(cmd.host, cmd.port)
 	}
 
 	conf := lsp_handler.HandlerConfig{
 		RelayServer:   cmd.forwardHost,
+		E2E:           cmd.config.RelayE2E,
 		SignalServer:  cmd.signalServer,
 		StaticRTCSite: cmd.config.StaticRTCSite,
+		ManualRTC:     cmd.config.ManualRTC,
+		ICEServers:    cmd.config.ICEServers,
 		ClientAuth:    cmd.config.Client,
+		Collaborate:   cmd.config.Collaborate,
+		EditPassword:  cmd.config.serverConfig().EditPassword,
+		FocusRequests: cmd.config.FocusRequests,
+		OutsideRoot:   cmd.config.OutsideRoot,
+		Schemes:       cmd.config.ShareSchemes,
 	}
 	lspLogger := log.New(f, "[LSP server]", log.Ldate|log.Ltime|log.Lshortfile)
 	handler := lsp_handler.NewHandler(state, lspLogger, &conf)
@@ -67,7 +109,7 @@ func (cmd *lspCommand) Run(args []string) {
 		if hostname == "" {
 			hostname = "localhost"
 		}
-		if cmd.config.Server.CertFile != "" {
+		if serverConfig := cmd.config.serverConfig(); serverConfig.TLSEnabled() {
 			hostname = "wss://" + hostname
 		}
 		handler.SendShareString(util.CreateShareURL(fmt.Sprintf("%s:%d", hostname, cmd.port), ""))
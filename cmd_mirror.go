@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"pair-ls/server"
+	"pair-ls/viewer"
+
+	"github.com/rakyll/command"
+)
+
+type mirrorCommand struct {
+	config     *PairConfig
+	password   string
+	name       string
+	statusFile string
+}
+
+func NewMirrorCmd(conf *PairConfig) command.Cmd {
+	return &mirrorCommand{
+		config: conf,
+	}
+}
+
+func (cmd *mirrorCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	fs.StringVar(&cmd.password, "password", cmd.config.Server.WebPassword, "Web password for the share (defaults to $PAIR_WEB_PASS)")
+	fs.StringVar(&cmd.name, "name", "", "Name to show the editor when connecting over WebRTC")
+	fs.StringVar(&cmd.statusFile, "status", "", "Write the sharer's current file and cursors to this file as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s mirror [flags] <dir> [share]
+
+Write the files shared by an editor into dir and keep them up to date, so you
+can browse them with your own editor and tools. The share is the same as for
+pair-ls view.
+
+Flags:
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (cmd *mirrorCommand) Run(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Missing directory")
+		os.Exit(1)
+	}
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+	dir := args[0]
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Fatal(err)
+	}
+	target := ""
+	if len(args) > 1 {
+		target = args[1]
+	}
+	mirror := viewer.NewMirror(dir, cmd.statusFile)
+	client, err := viewer.Dial(context.Background(), target, viewer.Options{
+		Password:   cmd.password,
+		Name:       cmd.name,
+		ICEServers: cmd.config.ICEServers,
+		In:         os.Stdin,
+		Out:        os.Stdout,
+		OnChange: func(w *viewer.Workspace, method string, fileID int32) {
+			if err := mirror.Sync(w, method, fileID); err != nil {
+				log.Println("Error writing files:", err)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	fmt.Println("Mirroring to", dir)
+	<-client.DisconnectNotify()
+	if err := client.Err(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Disconnected")
+}
@@ -9,10 +9,12 @@ import (
 	"pair-ls/state"
 
 	"github.com/rakyll/command"
+	"github.com/sourcegraph/jsonrpc2"
 )
 
 type relayCommand struct {
 	config *PairConfig
+	fs     *flag.FlagSet
 	host   string
 	port   int
 }
@@ -27,6 +29,7 @@ func (cmd *relayCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
 	fs.StringVar(&cmd.host, "host", "", "Hostname to bind to")
 	fs.IntVar(&cmd.port, "port", -1, "Port to listen on")
 	addServerFlags(cmd.config, fs)
+	cmd.fs = fs
 	fs.BoolVar(&cmd.config.RelayPersist, "persist", cmd.config.RelayPersist, "Keep file data even after all forwarding servers disconnect")
 	return fs
 }
@@ -38,16 +41,20 @@ func (cmd *relayCommand) Run(args []string) {
 	}
 	defer f.Close()
 
-	state := state.NewState(log.New(f, "[State]", log.Ldate|log.Ltime|log.Lshortfile))
-
 	lspLogger := log.New(f, "[LSP server]", log.Ldate|log.Ltime|log.Lshortfile)
-	handler := lsp_handler.NewHandler(state, lspLogger, &lsp_handler.HandlerConfig{})
+	newHandler := func(state *state.WorkspaceState) jsonrpc2.Handler {
+		return lsp_handler.NewHandler(state, lspLogger, &lsp_handler.HandlerConfig{}).GetRPCHandler()
+	}
+
+	state := state.NewState(log.New(f, "[State]", log.Ldate|log.Ltime|log.Lshortfile))
 
-	srv := server.NewServer(state, log.New(f, "[Relay]", log.Ldate|log.Ltime|log.Lshortfile), cmd.config.Server)
+	logger := log.New(f, "[Relay]", log.Ldate|log.Ltime|log.Lshortfile)
+	srv := server.NewServer(state, logger, cmd.config.Server)
 
 	relayConf := server.RelayConfig{
 		Persist: cmd.config.RelayPersist,
 	}
-	srv.AddRelayServer(handler.GetRPCHandler(), relayConf)
+	srv.AddRelayServer(newHandler, relayConf)
+	go watchServerConfig(cmd.config, cmd.fs, srv, logger)
 	srv.Serve(cmd.host, cmd.port)
 }
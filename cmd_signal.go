@@ -11,6 +11,7 @@ import (
 
 type signalCommand struct {
 	config *PairConfig
+	fs     *flag.FlagSet
 	host   string
 	port   int
 }
@@ -25,6 +26,7 @@ func (cmd *signalCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
 	fs.StringVar(&cmd.host, "host", "", "Hostname for webserver to bind to")
 	fs.IntVar(&cmd.port, "port", -1, "Port for webserver to listen on")
 	addServerFlags(cmd.config, fs)
+	cmd.fs = fs
 	return fs
 }
 
@@ -37,6 +39,10 @@ func (cmd *signalCommand) Run(args []string) {
 
 	logger := log.New(f, "[Signal]", log.Ldate|log.Ltime|log.Lshortfile)
 	srv := server.NewServer(nil, logger, cmd.config.Server)
-	srv.MakeSignalServer()
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+	srv.MakeSignalServer(cmd.config.ICEServers)
+	go watchServerConfig(cmd.config, cmd.fs, srv, logger)
 	srv.Serve(cmd.host, cmd.port)
 }
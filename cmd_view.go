@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"pair-ls/server"
+	"pair-ls/state"
+	"pair-ls/viewer"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rakyll/command"
+	"github.com/sourcegraph/go-lsp"
+	"golang.org/x/term"
+)
+
+const viewTabWidth = 4
+
+type viewCommand struct {
+	config   *PairConfig
+	password string
+	name     string
+	client   *viewer.Client
+	redraw   chan struct{}
+	// The file being displayed
+	fileID int32
+	follow bool
+	top    int
+	// The last view we scrolled to, so that we only jump to the sharer's
+	// cursor when it moves
+	lastView *state.View
+}
+
+func NewViewCmd(conf *PairConfig) command.Cmd {
+	return &viewCommand{
+		config: conf,
+		redraw: make(chan struct{}, 1),
+		fileID: -1,
+		follow: true,
+	}
+}
+
+func (cmd *viewCommand) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	fs.StringVar(&cmd.password, "password", cmd.config.Server.WebPassword, "Web password for the share (defaults to $PAIR_WEB_PASS)")
+	fs.StringVar(&cmd.name, "name", "", "Name to show the editor when connecting over WebRTC")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %s view [flags] [share]
+
+Follow a shared editor from the terminal. The share is the URL printed by the
+editor (from a pair-ls server, relay, or signal server) or a WebRTC token from
+pair-ls lsp -manual. With no share, a token is printed for the editor to answer.
+
+Keys:
+  Tab, n, p      switch files (stops following)
+  f              toggle following the sharer's cursor
+  j, k, arrows   scroll
+  q              quit
+
+Flags:
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (cmd *viewCommand) Run(args []string) {
+	if err := server.ValidateICEServers(cmd.config.ICEServers); err != nil {
+		log.Fatal("Invalid iceServers config: ", err)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		log.Fatal("pair-ls view must be run in a terminal")
+	}
+	target := ""
+	if len(args) > 0 {
+		target = args[0]
+	}
+	client, err := viewer.Dial(context.Background(), target, viewer.Options{
+		Password:   cmd.password,
+		Name:       cmd.name,
+		ICEServers: cmd.config.ICEServers,
+		In:         os.Stdin,
+		Out:        os.Stdout,
+		OnChange:   cmd.onChange,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	cmd.client = client
+
+	if err := cmd.run(); err != nil {
+		log.Fatal(err)
+	}
+	if err := client.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (cmd *viewCommand) onChange(w *viewer.Workspace, method string, fileID int32) {
+	select {
+	case cmd.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// run draws the workspace until the user quits or the editor disconnects
+func (cmd *viewCommand) run() error {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldState)
+	// Use the alternate screen and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(keys)
+	// There's no portable resize signal, so check the size periodically
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	width, height := terminalSize()
+	cmd.render(width, height)
+	for {
+		select {
+		case <-cmd.redraw:
+		case key, ok := <-keys:
+			if !ok || !cmd.handleKey(key, height) {
+				return nil
+			}
+		case <-ticker.C:
+			w, h := terminalSize()
+			if w == width && h == height {
+				continue
+			}
+		case <-cmd.client.DisconnectNotify():
+			return nil
+		}
+		width, height = terminalSize()
+		cmd.render(width, height)
+	}
+}
+
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+func readKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		keys <- string(buf[:n])
+	}
+}
+
+// handleKey returns false if the user wants to quit
+func (cmd *viewCommand) handleKey(key string, height int) bool {
+	page := height - 2
+	switch key {
+	case "q", "\x03":
+		return false
+	case "\t", "n", "l", "\x1b[C":
+		cmd.switchFile(1)
+	case "p", "h", "\x1b[Z", "\x1b[D":
+		cmd.switchFile(-1)
+	case "f":
+		cmd.follow = !cmd.follow
+		cmd.lastView = nil
+	case "j", "\x1b[B", "\r":
+		cmd.top++
+	case "k", "\x1b[A":
+		cmd.top--
+	case "\x04", " ", "\x1b[6~":
+		cmd.top += page / 2
+	case "\x15", "\x1b[5~":
+		cmd.top -= page / 2
+	case "g", "\x1b[H":
+		cmd.top = 0
+	case "G", "\x1b[F":
+		// Clamped when rendering
+		cmd.top = 1 << 30
+	}
+	return true
+}
+
+func (cmd *viewCommand) switchFile(delta int) {
+	cmd.client.Read(func(w *viewer.Workspace) {
+		files := sortedFiles(w)
+		if len(files) == 0 {
+			return
+		}
+		idx := 0
+		for i, file := range files {
+			if file.ID == cmd.fileID {
+				idx = (i + delta + len(files)) % len(files)
+				break
+			}
+		}
+		cmd.fileID = files[idx].ID
+		cmd.top = 0
+		cmd.follow = false
+	})
+}
+
+func sortedFiles(w *viewer.Workspace) []*state.File {
+	files := make([]*state.File, 0, len(w.Files))
+	for _, file := range w.Files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+	return files
+}
+
+func (cmd *viewCommand) render(width int, height int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	cmd.client.Read(func(w *viewer.Workspace) {
+		cmd.draw(&b, w, width, height)
+	})
+	os.Stdout.WriteString(b.String())
+}
+
+func (cmd *viewCommand) draw(b *strings.Builder, w *viewer.Workspace, width int, height int) {
+	bodyHeight := height - 2
+	if cmd.follow && w.View != nil && w.Files[w.View.FileID] != nil {
+		if cmd.fileID != w.View.FileID {
+			cmd.fileID = w.View.FileID
+			cmd.lastView = nil
+		}
+	}
+	file := w.Files[cmd.fileID]
+	if file == nil {
+		if files := sortedFiles(w); len(files) > 0 {
+			file = files[0]
+			cmd.fileID = file.ID
+			cmd.top = 0
+		}
+	}
+
+	var cursors []state.CursorPosition
+	if w.View != nil && file != nil && w.View.FileID == file.ID {
+		cursors = w.View.Cursors
+		if cmd.follow && w.View != cmd.lastView && len(cursors) > 0 {
+			line := cursors[0].Position.Line
+			if line < cmd.top || line >= cmd.top+bodyHeight {
+				cmd.top = line - bodyHeight/2
+			}
+		}
+		cmd.lastView = w.View
+	}
+
+	// Header
+	header := " pair-ls"
+	if w.Identity != "" {
+		header += " | " + escapeControl(w.Identity)
+	}
+	if file != nil {
+		header += " | " + escapeControl(file.Filename)
+	}
+	if cmd.follow {
+		header += " | following"
+	} else {
+		header += " | not following"
+	}
+	b.WriteString("\x1b[7m")
+	b.WriteString(padRight(header, width))
+	b.WriteString("\x1b[0m\r\n")
+
+	// Body
+	var lines []string
+	language := ""
+	if file != nil {
+		lines = file.Lines
+		language = file.Language
+	}
+	if cmd.top > len(lines)-bodyHeight {
+		cmd.top = len(lines) - bodyHeight
+	}
+	if cmd.top < 0 {
+		cmd.top = 0
+	}
+	gutter := len(fmt.Sprint(len(lines)))
+	for row := 0; row < bodyHeight; row++ {
+		b.WriteString("\x1b[2K")
+		lineNum := cmd.top + row
+		if lineNum < len(lines) {
+			fmt.Fprintf(b, "\x1b[90m%*d\x1b[0m ", gutter, lineNum+1)
+			drawLine(b, lines[lineNum], viewer.Highlight(language, lines[lineNum]), lineNum, cursors, width-gutter-1)
+		} else if file == nil && row == 0 {
+			b.WriteString("Waiting for the editor to open a file...")
+		} else {
+			b.WriteString("\x1b[90m~\x1b[0m")
+		}
+		b.WriteString("\r\n")
+	}
+
+	// Footer
+	b.WriteString("\x1b[2K\x1b[90m")
+	b.WriteString(truncate(" Tab/n/p: switch file  f: follow  j/k: scroll  q: quit", width))
+	b.WriteString("\x1b[0m")
+}
+
+var tokenColors = map[viewer.TokenKind]string{
+	viewer.TokenKeyword: ";35",
+	viewer.TokenString:  ";32",
+	viewer.TokenComment: ";90",
+	viewer.TokenNumber:  ";33",
+}
+
+// drawLine writes one line of code with syntax colors and the sharer's
+// cursors and selections. Control characters are shown escaped, so the
+// sharer's files can't send commands to the terminal.
+func drawLine(b *strings.Builder, line string, kinds []viewer.TokenKind, lineNum int, cursors []state.CursorPosition, width int) {
+	runes := []rune(line)
+	col := 0
+	style := ""
+	setStyle := func(s string) {
+		if s != style {
+			b.WriteString("\x1b[0" + s + "m")
+			style = s
+		}
+	}
+	for i := 0; i <= len(runes); i++ {
+		s := ""
+		isCursor := false
+		for _, cursor := range cursors {
+			if cursor.Position.Line == lineNum && cursor.Position.Character == i {
+				isCursor = true
+			} else if cursor.Range != nil && inRange(*cursor.Range, lineNum, i) {
+				s = ";48;5;238"
+			}
+		}
+		if i == len(runes) {
+			// A cursor at the end of the line
+			if isCursor && col < width {
+				setStyle(";7")
+				b.WriteString(" ")
+			}
+			break
+		}
+		s = tokenColors[kinds[i]] + s
+		if isCursor {
+			s += ";7"
+		}
+		setStyle(s)
+		text := escapeRune(runes[i])
+		if runes[i] == '\t' {
+			text = strings.Repeat(" ", viewTabWidth-col%viewTabWidth)
+		}
+		if col+len([]rune(text)) > width {
+			break
+		}
+		b.WriteString(text)
+		col += len([]rune(text))
+	}
+	setStyle("")
+}
+
+func inRange(rng lsp.Range, line int, character int) bool {
+	if line < rng.Start.Line || line > rng.End.Line {
+		return false
+	}
+	if line == rng.Start.Line && character < rng.Start.Character {
+		return false
+	}
+	if line == rng.End.Line && character >= rng.End.Character {
+		return false
+	}
+	return true
+}
+
+// escapeRune shows C0 control characters in caret notation (ESC is ^[) and C1
+// control characters as U+FFFD
+func escapeRune(r rune) string {
+	switch {
+	case r < 0x20 || r == 0x7f:
+		return "^" + string(r^0x40)
+	case r >= 0x80 && r < 0xa0:
+		return "\ufffd"
+	}
+	return string(r)
+}
+
+// escapeControl makes text from the sharer safe to print to a terminal
+func escapeControl(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		b.WriteString(escapeRune(r))
+	}
+	return b.String()
+}
+
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return text
+}
+
+func padRight(text string, width int) string {
+	text = truncate(text, width)
+	return text + strings.Repeat(" ", width-len([]rune(text)))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"pair-ls/viewer"
+	"strings"
+	"testing"
+)
+
+func TestDrawLineEscapesControlCharacters(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"plain", "abc", "abc"},
+		{"tab", "a\tb", "a   b"},
+		{"escape sequence", "\x1b[2Jhi", "^[[2Jhi"},
+		{"OSC title", "\x1b]0;pwned\x07", "^[]0;pwned^G"},
+		{"carriage return", "a\rb", "a^Mb"},
+		{"delete", "a\x7fb", "a^?b"},
+		{"C1 CSI", "a\u009b2Jb", "a�2Jb"},
+		{"unicode", "héllo ✓", "héllo ✓"},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		kinds := make([]viewer.TokenKind, len([]rune(test.line)))
+		drawLine(&b, test.line, kinds, 0, nil, 80)
+		// Strip the SGR sequences that drawLine adds itself
+		got := b.String()
+		for _, sgr := range []string{"\x1b[0m", "\x1b[07m"} {
+			got = strings.ReplaceAll(got, sgr, "")
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if strings.ContainsAny(got, "\x1b\x07\r\x7f\u009b") {
+			t.Errorf("%s: output has control characters: %q", test.name, got)
+		}
+	}
+}
+
+func TestEscapeControl(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"filename", "src/main.go", "src/main.go"},
+		{"escape sequence", "a\x1b[31mb", "a^[[31mb"},
+		{"newline", "a\nb", "a^Jb"},
+		{"tab", "a\tb", "a^Ib"},
+	}
+	for _, test := range tests {
+		if got := escapeControl(test.text); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Beyond this many differences the rest of the changed region is treated as
+// replaced, so that huge rewrites don't use quadratic memory
+const maxEditDistance = 1000
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Line is one line of an edit script
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// Lines returns the edits that turn a into b, using the Myers algorithm
+func Lines(a []string, b []string) []Line {
+	// Most changes are small, so skip the common start and end
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ret := make([]Line, 0, len(a)+len(b)-prefix-suffix)
+	for _, text := range a[:prefix] {
+		ret = append(ret, Line{Kind: Equal, Text: text})
+	}
+	ret = append(ret, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		ret = append(ret, Line{Kind: Equal, Text: text})
+	}
+	return ret
+}
+
+func myers(a []string, b []string) []Line {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	// v[offset+k] is the furthest x reached on diagonal k
+	offset := max
+	v := make([]int, 2*max+1)
+	// The part of v used by each step, for backtracking
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		if d > maxEditDistance {
+			return replace(a, b)
+		}
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replace(a, b)
+}
+
+// backtrack follows the trace from the end to the start
+func backtrack(trace [][]int, a []string, b []string) []Line {
+	var reversed []Line
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+d] < v[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+d]
+		prevY := prevX - prevK
+		// The snake after the insertion or deletion
+		startX, startY := prevX, prevY+1
+		if prevK == k-1 {
+			startX, startY = prevX+1, prevY
+		}
+		for x > startX && y > startY {
+			reversed = append(reversed, Line{Kind: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if prevK == k-1 {
+			reversed = append(reversed, Line{Kind: Delete, Text: a[prevX]})
+		} else {
+			reversed = append(reversed, Line{Kind: Insert, Text: b[prevY]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, Line{Kind: Equal, Text: a[x-1]})
+		x--
+		y--
+	}
+	ret := make([]Line, len(reversed))
+	for i, line := range reversed {
+		ret[len(ret)-1-i] = line
+	}
+	return ret
+}
+
+func replace(a []string, b []string) []Line {
+	ret := make([]Line, 0, len(a)+len(b))
+	for _, text := range a {
+		ret = append(ret, Line{Kind: Delete, Text: text})
+	}
+	for _, text := range b {
+		ret = append(ret, Line{Kind: Insert, Text: text})
+	}
+	return ret
+}
+
+// Unified formats an edit script as a unified diff with the given lines of
+// context around each change. It returns an empty string if nothing changed.
+func Unified(fromName string, toName string, lines []Line, context int) string {
+	var out strings.Builder
+	// Line numbers (from 0) in each file at each line of the script
+	aLines := make([]int, len(lines)+1)
+	bLines := make([]int, len(lines)+1)
+	for i, line := range lines {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if line.Kind != Insert {
+			aLines[i+1]++
+		}
+		if line.Kind != Delete {
+			bLines[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == Equal {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Changes separated by less than twice the context share a hunk
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != Equal {
+				end++
+				continue
+			}
+			run := 0
+			for end+run < len(lines) && lines[end+run].Kind == Equal {
+				run++
+			}
+			if end+run == len(lines) || run > 2*context {
+				break
+			}
+			end += run
+		}
+		end += context
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLines[start], aLines[end]-aLines[start]),
+			hunkRange(bLines[start], bLines[end]-bLines[start]))
+		for _, line := range lines[start:end] {
+			switch line.Kind {
+			case Equal:
+				out.WriteString(" ")
+			case Insert:
+				out.WriteString("+")
+			case Delete:
+				out.WriteString("-")
+			}
+			out.WriteString(line.Text)
+			out.WriteString("\n")
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the start (from 0) and length of a hunk like git does
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		// An empty range refers to the line before it
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// indexEntry is a file in the index (the staging area)
+type indexEntry struct {
+	path string
+	hash string
+	mode uint32
+	size uint32
+	// Modification time when the file was added to the index
+	mtimeSec  uint32
+	mtimeNsec uint32
+	// Non-zero for unresolved merge conflicts
+	stage int
+	// Files excluded by a sparse checkout aren't in the working tree
+	skipWorktree bool
+	// Added with git add -N
+	intentToAdd bool
+}
+
+func (e indexEntry) isSubmodule() bool {
+	return e.mode&0170000 == 0160000
+}
+
+func (e indexEntry) isSymlink() bool {
+	return e.mode&0170000 == 0120000
+}
+
+// readIndex parses the index file (versions 2 to 4)
+func (r *Repo) readIndex() ([]indexEntry, error) {
+	filename := filepath.Join(r.gitDir, "index")
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		// Nothing has been added yet
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	errInvalid := fmt.Errorf("Invalid index %s", filename)
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, errInvalid
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("Unsupported index version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+
+	entries := make([]indexEntry, 0, count)
+	pos := 12
+	prevPath := ""
+	for i := 0; i < count; i++ {
+		start := pos
+		// ctime, mtime, dev, ino, mode, uid, gid, size, hash, flags
+		if len(data) < pos+62 {
+			return nil, errInvalid
+		}
+		entry := indexEntry{
+			mtimeSec:  binary.BigEndian.Uint32(data[pos+8:]),
+			mtimeNsec: binary.BigEndian.Uint32(data[pos+12:]),
+			mode:      binary.BigEndian.Uint32(data[pos+24:]),
+			size:      binary.BigEndian.Uint32(data[pos+36:]),
+			hash:      hex.EncodeToString(data[pos+40 : pos+60]),
+		}
+		flags := binary.BigEndian.Uint16(data[pos+60:])
+		entry.stage = int(flags>>12) & 3
+		pos += 62
+		if flags&0x4000 != 0 {
+			if version < 3 || len(data) < pos+2 {
+				return nil, errInvalid
+			}
+			extended := binary.BigEndian.Uint16(data[pos:])
+			entry.skipWorktree = extended&0x4000 != 0
+			entry.intentToAdd = extended&0x2000 != 0
+			pos += 2
+		}
+
+		if version == 4 {
+			// The path is compressed against the previous one: the number of
+			// bytes to remove from its end, then the bytes to add
+			strip, n := decodeVarint(data[pos:])
+			if n <= 0 || strip > len(prevPath) {
+				return nil, errInvalid
+			}
+			pos += n
+			nul := bytes.IndexByte(data[pos:], 0)
+			if nul < 0 {
+				return nil, errInvalid
+			}
+			entry.path = prevPath[:len(prevPath)-strip] + string(data[pos:pos+nul])
+			pos += nul + 1
+		} else {
+			nul := bytes.IndexByte(data[pos:], 0)
+			if nul < 0 {
+				return nil, errInvalid
+			}
+			entry.path = string(data[pos : pos+nul])
+			// Entries are padded with 1-8 NULs to a multiple of 8 bytes
+			pos = start + (pos+nul-start+8)&^7
+		}
+		prevPath = entry.path
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeVarint reads git's variable width integers (the same encoding as the
+// offsets of ofs deltas). It returns the value and the number of bytes read, or
+// 0 if the data ends too soon.
+func decodeVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	value := int(data[0] & 0x7f)
+	n := 1
+	for data[n-1]&0x80 != 0 {
+		if n >= len(data) || n > 8 {
+			return 0, 0
+		}
+		value = (value+1)<<7 | int(data[n]&0x7f)
+		n++
+	}
+	return value, n
+}
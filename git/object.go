@@ -0,0 +1,258 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Object types, as numbered in pack files
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var typeNames = map[string]int{
+	"commit": objCommit,
+	"tree":   objTree,
+	"blob":   objBlob,
+	"tag":    objTag,
+}
+
+// readObject returns the type and contents of an object
+func (r *Repo) readObject(hash string) (int, []byte, error) {
+	if len(hash) != 40 {
+		return 0, nil, fmt.Errorf("Invalid object hash %q", hash)
+	}
+	objType, data, err := r.readLooseObject(hash)
+	if err != ErrNotFound {
+		return objType, data, err
+	}
+	packs, err := r.loadPacks()
+	if err != nil {
+		return 0, nil, err
+	}
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, p := range packs {
+		if offset, ok := p.find(raw); ok {
+			return p.read(r, offset)
+		}
+	}
+	return 0, nil, ErrNotFound
+}
+
+func (r *Repo) readLooseObject(hash string) (int, []byte, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "objects", hash[:2], hash[2:]))
+	if os.IsNotExist(err) {
+		return 0, nil, ErrNotFound
+	} else if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer z.Close()
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		return 0, nil, err
+	}
+	// "<type> <size>\0<contents>"
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return 0, nil, fmt.Errorf("Invalid object %s", hash)
+	}
+	header := strings.SplitN(string(data[:nul]), " ", 2)
+	objType, ok := typeNames[header[0]]
+	if !ok || len(header) != 2 {
+		return 0, nil, fmt.Errorf("Invalid object %s", hash)
+	}
+	if size, err := strconv.Atoi(header[1]); err != nil || size != len(data)-nul-1 {
+		return 0, nil, fmt.Errorf("Invalid object %s", hash)
+	}
+	return objType, data[nul+1:], nil
+}
+
+// readTyped reads an object that must have the given type
+func (r *Repo) readTyped(hash string, objType int) ([]byte, error) {
+	actual, data, err := r.readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if actual != objType {
+		return nil, fmt.Errorf("Object %s has the wrong type", hash)
+	}
+	return data, nil
+}
+
+// commitTree returns the hash of a commit's tree
+func (r *Repo) commitTree(commit string) (string, error) {
+	data, err := r.readTyped(commit, objCommit)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "tree ") {
+			return strings.TrimPrefix(line, "tree "), nil
+		}
+		if line == "" {
+			break
+		}
+	}
+	return "", fmt.Errorf("Commit %s has no tree", commit)
+}
+
+type treeEntry struct {
+	mode string
+	name string
+	hash string
+}
+
+func (e treeEntry) isTree() bool {
+	return e.mode == "40000"
+}
+
+// Gitlinks (submodules) point to commits in another repository
+func (e treeEntry) isSubmodule() bool {
+	return e.mode == "160000"
+}
+
+func (r *Repo) readTree(hash string) ([]treeEntry, error) {
+	data, err := r.readTyped(hash, objTree)
+	if err != nil {
+		return nil, err
+	}
+	// Each entry is "<mode> <name>\0<20 byte hash>"
+	var entries []treeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space < 0 || nul < space || len(data) < nul+21 {
+			return nil, fmt.Errorf("Invalid tree %s", hash)
+		}
+		entries = append(entries, treeEntry{
+			mode: string(data[:space]),
+			name: string(data[space+1 : nul]),
+			hash: hex.EncodeToString(data[nul+1 : nul+21]),
+		})
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// ReadFile returns the contents of a file at a commit. The path is relative to
+// the root of the repository.
+func (r *Repo) ReadFile(commit string, path string) ([]byte, error) {
+	hash, err := r.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	for i, part := range parts {
+		entries, err := r.readTree(hash)
+		if err != nil {
+			return nil, err
+		}
+		hash = ""
+		for _, entry := range entries {
+			if entry.name != part {
+				continue
+			}
+			// Every part but the last must be a directory, and the last must not
+			if entry.isTree() == (i < len(parts)-1) && !entry.isSubmodule() {
+				hash = entry.hash
+			}
+			break
+		}
+		if hash == "" {
+			return nil, ErrNotFound
+		}
+	}
+	return r.readTyped(hash, objBlob)
+}
+
+// applyDelta builds an object from its base and a delta from a pack file
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	errInvalid := errors.New("Invalid delta")
+	pos := 0
+	readSize := func() (int, bool) {
+		size, shift := 0, 0
+		for pos < len(delta) {
+			b := delta[pos]
+			pos++
+			size |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				return size, true
+			}
+		}
+		return 0, false
+	}
+	baseSize, ok := readSize()
+	if !ok || baseSize != len(base) {
+		return nil, errInvalid
+	}
+	resultSize, ok := readSize()
+	if !ok {
+		return nil, errInvalid
+	}
+	result := make([]byte, 0, resultSize)
+	for pos < len(delta) {
+		op := delta[pos]
+		pos++
+		if op&0x80 != 0 {
+			// Copy from the base. The low 4 bits say which offset bytes are
+			// present, and the next 3 say which size bytes are.
+			offset, size := 0, 0
+			for i := uint(0); i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if pos >= len(delta) {
+					return nil, errInvalid
+				}
+				if i < 4 {
+					offset |= int(delta[pos]) << (8 * i)
+				} else {
+					size |= int(delta[pos]) << (8 * (i - 4))
+				}
+				pos++
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, errInvalid
+			}
+			result = append(result, base[offset:offset+size]...)
+		} else if op != 0 {
+			// Insert the next op bytes
+			if pos+int(op) > len(delta) {
+				return nil, errInvalid
+			}
+			result = append(result, delta[pos:pos+int(op)]...)
+			pos += int(op)
+		} else {
+			return nil, errInvalid
+		}
+	}
+	if len(result) != resultSize {
+		return nil, errInvalid
+	}
+	return result, nil
+}
@@ -0,0 +1,223 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// git itself defaults to 50, but aggressive repacks can go much deeper
+const maxDeltaDepth = 1000
+
+// Objects bigger than this are almost certainly a corrupt pack
+const maxObjectSize = 1 << 30
+
+var idxMagic = []byte{0xff, 't', 'O', 'c'}
+
+// pack is a pack file and its (version 2) index
+type pack struct {
+	filename string
+	// Sorted object hashes, 20 bytes each
+	hashes  []byte
+	offsets []int64
+}
+
+// loadPacks reads the pack indexes the first time they're needed
+func (r *Repo) loadPacks() ([]*pack, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.packs != nil {
+		return r.packs, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(r.commonDir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	r.packs = make([]*pack, 0, len(matches))
+	for _, idx := range matches {
+		p, err := readPackIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+		r.packs = append(r.packs, p)
+	}
+	return r.packs, nil
+}
+
+func readPackIndex(filename string) (*pack, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	errInvalid := fmt.Errorf("Invalid pack index %s", filename)
+	// Magic, version, and the fanout table
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], idxMagic) {
+		return nil, errInvalid
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("Unsupported pack index version in %s", filename)
+	}
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	hashStart := 8 + 256*4
+	// Hashes, then CRCs, then offsets
+	offsetStart := hashStart + count*20 + count*4
+	largeStart := offsetStart + count*4
+	if len(data) < largeStart {
+		return nil, errInvalid
+	}
+	offsets := make([]int64, count)
+	for i := range offsets {
+		offset := binary.BigEndian.Uint32(data[offsetStart+i*4:])
+		// Offsets over 2GB are in a separate table of 8 byte offsets
+		if offset&0x80000000 != 0 {
+			pos := largeStart + int(offset&0x7fffffff)*8
+			if len(data) < pos+8 {
+				return nil, errInvalid
+			}
+			offsets[i] = int64(binary.BigEndian.Uint64(data[pos:]))
+		} else {
+			offsets[i] = int64(offset)
+		}
+	}
+	return &pack{
+		filename: strings.TrimSuffix(filename, ".idx") + ".pack",
+		hashes:   data[hashStart : hashStart+count*20],
+		offsets:  offsets,
+	}, nil
+}
+
+// find returns the offset of an object in the pack file
+func (p *pack) find(hash []byte) (int64, bool) {
+	i := sort.Search(len(p.offsets), func(i int) bool {
+		return bytes.Compare(p.hashes[i*20:i*20+20], hash) >= 0
+	})
+	if i < len(p.offsets) && bytes.Equal(p.hashes[i*20:i*20+20], hash) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// read returns the type and contents of the object at an offset, applying any
+// deltas
+func (p *pack) read(r *Repo, offset int64) (int, []byte, error) {
+	f, err := os.Open(p.filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var deltas [][]byte
+	var objType int
+	var data []byte
+	for depth := 0; ; depth++ {
+		if depth > maxDeltaDepth {
+			return 0, nil, errors.New("Delta chain is too long")
+		}
+		entry, err := readEntry(f, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		if entry.objType == objOfsDelta {
+			deltas = append(deltas, entry.data)
+			offset = entry.baseOffset
+			continue
+		}
+		if entry.objType == objRefDelta {
+			deltas = append(deltas, entry.data)
+			if objType, data, err = r.readObject(entry.baseHash); err != nil {
+				return 0, nil, err
+			}
+		} else {
+			objType, data = entry.objType, entry.data
+		}
+		break
+	}
+	for i := len(deltas) - 1; i >= 0; i-- {
+		if data, err = applyDelta(data, deltas[i]); err != nil {
+			return 0, nil, err
+		}
+	}
+	return objType, data, nil
+}
+
+type packEntry struct {
+	objType int
+	// The object, or the delta for delta objects
+	data []byte
+	// The base of an ofs delta
+	baseOffset int64
+	// The base of a ref delta
+	baseHash string
+}
+
+func readEntry(f *os.File, offset int64) (packEntry, error) {
+	var entry packEntry
+	reader := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+	// The type and the uncompressed size. The size is little-endian, 4 bits in
+	// the first byte and 7 in the rest.
+	b, err := reader.ReadByte()
+	if err != nil {
+		return entry, err
+	}
+	entry.objType = int(b>>4) & 7
+	size := int64(b & 0x0f)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, err = reader.ReadByte(); err != nil {
+			return entry, err
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	switch entry.objType {
+	case objOfsDelta:
+		// A big-endian distance back to the base, with an odd encoding that
+		// adds one for every continuation byte
+		if b, err = reader.ReadByte(); err != nil {
+			return entry, err
+		}
+		distance := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = reader.ReadByte(); err != nil {
+				return entry, err
+			}
+			distance = (distance+1)<<7 | int64(b&0x7f)
+		}
+		if distance <= 0 || distance > offset {
+			return entry, errors.New("Invalid delta base offset")
+		}
+		entry.baseOffset = offset - distance
+	case objRefDelta:
+		hash := make([]byte, 20)
+		if _, err := io.ReadFull(reader, hash); err != nil {
+			return entry, err
+		}
+		entry.baseHash = hex.EncodeToString(hash)
+	case objCommit, objTree, objBlob, objTag:
+	default:
+		return entry, fmt.Errorf("Invalid object type %d in pack", entry.objType)
+	}
+
+	if size > maxObjectSize {
+		return entry, errors.New("Object in pack is too big")
+	}
+	z, err := zlib.NewReader(reader)
+	if err != nil {
+		return entry, err
+	}
+	defer z.Close()
+	entry.data = make([]byte, size)
+	if _, err := io.ReadFull(z, entry.data); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,176 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Symbolic refs can point to other symbolic refs, but not forever
+const maxRefDepth = 10
+
+// ErrNotFound is returned for objects, refs and paths that don't exist
+var ErrNotFound = errors.New("Not found")
+
+// Repo reads a git repository directly from its .git directory, without
+// running git
+type Repo struct {
+	// The root of the working tree
+	Root string
+	// The .git directory, which has HEAD and the index
+	gitDir string
+	// Has the objects and refs. This is different from gitDir in a worktree
+	// created with git worktree add.
+	commonDir string
+
+	mu    sync.Mutex
+	packs []*pack
+	// Working tree files that were hashed to check whether they changed
+	hashes map[string]cachedHash
+	// The files in filesCommit, by path
+	filesCommit string
+	files       map[string]string
+}
+
+// Open finds the repository that contains path
+func Open(path string) (*Repo, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			gitDir := dotGit
+			// Worktrees and submodules have a file pointing to the real directory
+			if !info.IsDir() {
+				if gitDir, err = readGitFile(dotGit); err != nil {
+					return nil, err
+				}
+			}
+			return open(dir, gitDir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("%s is not in a git repository", path)
+		}
+		dir = parent
+	}
+}
+
+func open(root string, gitDir string) (*Repo, error) {
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); err != nil {
+		return nil, fmt.Errorf("%s is not a git directory", gitDir)
+	}
+	commonDir := gitDir
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return &Repo{
+		Root:      root,
+		gitDir:    gitDir,
+		commonDir: commonDir,
+	}, nil
+}
+
+// readGitFile reads a .git file ("gitdir: <path>")
+func readGitFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("Invalid .git file %s", filename)
+	}
+	gitDir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(filename), gitDir)
+	}
+	return gitDir, nil
+}
+
+// Head returns the branch that HEAD points to (empty if HEAD is detached) and
+// the commit it points to (empty if the branch has no commits yet)
+func (r *Repo) Head() (branch string, commit string, err error) {
+	target, err := r.readRef("HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if strings.HasPrefix(target, "ref: ") {
+		name := strings.TrimPrefix(target, "ref: ")
+		branch = strings.TrimPrefix(name, "refs/heads/")
+		commit, err = r.ResolveRef(name)
+		if err == ErrNotFound {
+			return branch, "", nil
+		}
+		return branch, commit, err
+	}
+	return "", target, nil
+}
+
+// ResolveRef returns the hash that a ref (e.g. refs/heads/main) points to
+func (r *Repo) ResolveRef(name string) (string, error) {
+	for i := 0; i < maxRefDepth; i++ {
+		target, err := r.readRef(name)
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(target, "ref: ") {
+			return target, nil
+		}
+		name = strings.TrimPrefix(target, "ref: ")
+	}
+	return "", fmt.Errorf("Too many levels of symbolic refs for %s", name)
+}
+
+// readRef returns the contents of a ref: a hash, or "ref: " and another ref
+func (r *Repo) readRef(name string) (string, error) {
+	dir := r.commonDir
+	// Refs that are specific to a worktree
+	if !strings.HasPrefix(name, "refs/") || strings.HasPrefix(name, "refs/bisect/") {
+		dir = r.gitDir
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return r.readPackedRef(name)
+}
+
+func (r *Repo) readPackedRef(name string) (string, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Comments, and the commits that annotated tags point to
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrNotFound
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status is what the working tree has checked out
+type Status struct {
+	// Empty if HEAD is detached
+	Branch string
+	// Empty if the branch has no commits yet
+	Commit string
+	// Set if tracked files have changes that aren't committed, staged or not.
+	// Untracked files are ignored, like git describe --dirty.
+	Dirty bool
+}
+
+// A hash of a working tree file, which is valid as long as the file's size and
+// modification time don't change
+type cachedHash struct {
+	size  int64
+	mtime time.Time
+	hash  string
+}
+
+// Status reads the current branch and commit, and checks for changes
+func (r *Repo) Status() (Status, error) {
+	branch, commit, err := r.Head()
+	if err != nil {
+		return Status{}, err
+	}
+	dirty, err := r.dirty(commit)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Branch: branch,
+		Commit: commit,
+		Dirty:  dirty,
+	}, nil
+}
+
+// dirty compares the index to the commit, and the working tree to the index
+func (r *Repo) dirty(commit string) (bool, error) {
+	entries, err := r.readIndex()
+	if err != nil {
+		return false, err
+	}
+	committed := make(map[string]string)
+	if commit != "" {
+		if committed, err = r.commitFiles(commit); err != nil {
+			return false, err
+		}
+	}
+	if len(entries) != len(committed) {
+		return true, nil
+	}
+	for _, entry := range entries {
+		if entry.stage != 0 || entry.intentToAdd || committed[entry.path] != entry.hash {
+			return true, nil
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.skipWorktree || entry.isSubmodule() {
+			continue
+		}
+		changed, err := r.worktreeChanged(entry)
+		if err != nil {
+			return false, err
+		}
+		if changed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// worktreeChanged is true if a file in the working tree doesn't match the index
+func (r *Repo) worktreeChanged(entry indexEntry) (bool, error) {
+	filename := filepath.Join(r.Root, filepath.FromSlash(entry.path))
+	info, err := os.Lstat(filename)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if entry.isSymlink() != (info.Mode()&os.ModeSymlink != 0) {
+		return true, nil
+	}
+	// Like git, trust the index if the file hasn't been touched since it was
+	// added. The index only has the low 32 bits of the size and time.
+	mtime := info.ModTime()
+	if uint32(info.Size()) == entry.size && uint32(mtime.Unix()) == entry.mtimeSec && uint32(mtime.Nanosecond()) == entry.mtimeNsec {
+		return false, nil
+	}
+
+	r.mu.Lock()
+	cached, ok := r.hashes[entry.path]
+	r.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.mtime.Equal(mtime) {
+		return cached.hash != entry.hash, nil
+	}
+	var data []byte
+	if entry.isSymlink() {
+		target, err := os.Readlink(filename)
+		if err != nil {
+			return false, err
+		}
+		data = []byte(target)
+	} else if data, err = ioutil.ReadFile(filename); err != nil {
+		return false, err
+	}
+	hash := hashBlob(data)
+	r.mu.Lock()
+	if r.hashes == nil {
+		r.hashes = make(map[string]cachedHash)
+	}
+	r.hashes[entry.path] = cachedHash{size: info.Size(), mtime: mtime, hash: hash}
+	r.mu.Unlock()
+	return hash != entry.hash, nil
+}
+
+// commitFiles returns the hash of every file in a commit, by path. It's cached
+// for the last commit asked for.
+func (r *Repo) commitFiles(commit string) (map[string]string, error) {
+	r.mu.Lock()
+	if r.filesCommit == commit {
+		files := r.files
+		r.mu.Unlock()
+		return files, nil
+	}
+	r.mu.Unlock()
+
+	tree, err := r.commitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	if err := r.listTree(tree, "", files); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.filesCommit = commit
+	r.files = files
+	r.mu.Unlock()
+	return files, nil
+}
+
+func (r *Repo) listTree(hash string, prefix string, files map[string]string) error {
+	entries, err := r.readTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.isTree() {
+			if err := r.listTree(entry.hash, prefix+entry.name+"/", files); err != nil {
+				return err
+			}
+		} else {
+			files[prefix+entry.name] = entry.hash
+		}
+	}
+	return nil
+}
+
+// hashBlob is the hash git gives a file with this content
+func hashBlob(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -16,6 +16,7 @@ require (
 	github.com/rakyll/command v0.0.0-20140411201721-0f2fed130caf
 	github.com/vearutop/statigz v1.1.7
 	golang.org/x/crypto v0.0.0-20220209195652-db638375bc3a
+	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
 )
 
 require (
@@ -37,5 +38,6 @@ require (
 	github.com/pion/udp v0.1.1 // indirect
 	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+	golang.org/x/text v0.3.7 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
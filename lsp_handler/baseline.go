@@ -0,0 +1,65 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"pair-ls/git"
+	"pair-ls/state"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// BaselineParams is sent to the relay server so that its viewers see the same
+// diff
+type BaselineParams struct {
+	Filename string `json:"filename"`
+	Text     string `json:"text"`
+}
+
+// setGitBaseline diffs a file against the version in HEAD, if it has one.
+// Files that aren't committed are diffed against the text they're opened with.
+func (h *LspHandler) setGitBaseline(filename string, path string) {
+	rel, ok := relativeTo(h.repo.Root, path)
+	if !ok {
+		return
+	}
+	_, commit, err := h.repo.Head()
+	if err != nil || commit == "" {
+		return
+	}
+	data, err := h.repo.ReadFile(commit, rel)
+	if err == git.ErrNotFound {
+		return
+	} else if err != nil {
+		h.logger.Println("Error reading", rel, "from git:", err)
+		return
+	}
+	text := string(data)
+	h.state.SetBaseline(filename, text)
+	if h.forwardQueue != nil {
+		h.forwardNotification("experimental/baseline", BaselineParams{Filename: filename, Text: text})
+	}
+}
+
+func (h *LspHandler) handleRelayedBaseline(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params BaselineParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.state.SetBaseline(params.Filename, params.Text)
+	return nil, nil
+}
+
+func (h *LspHandler) handleGetDiff(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	var params state.DiffRequest
+	if req.Params != nil {
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	return h.state.GetDiff(params), nil
+}
@@ -0,0 +1,128 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pair-ls/state"
+	"time"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// How many times to retry an edit that the editor rejected because the
+// document changed before it could be applied
+const maxEditAttempts = 3
+
+// How long to wait for the change that made the editor reject an edit before
+// giving up on it
+const editRetryTimeout = 2 * time.Second
+
+// go-lsp is missing the LSP 3.x versions of these
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+type WorkspaceEdit struct {
+	Changes         map[lsp.DocumentURI][]lsp.TextEdit `json:"changes,omitempty"`
+	DocumentChanges []TextDocumentEdit                 `json:"documentChanges,omitempty"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument lsp.VersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []lsp.TextEdit                      `json:"edits"`
+}
+
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// applyViewerEdit is the state.EditHandler used in collaborative mode. It
+// rebases the edit onto the latest version of the file and asks the editor to
+// apply it.
+func (h *LspHandler) applyViewerEdit(req state.EditRequest) (state.EditResult, error) {
+	if !h.clientApplyEdit {
+		return state.EditResult{}, errors.New("Editor does not support workspace/applyEdit")
+	}
+	h.mu.Lock()
+	uri, ok := h.uris[req.Filename]
+	h.mu.Unlock()
+	if !ok {
+		return state.EditResult{}, fmt.Errorf("File is not open: %s", req.Filename)
+	}
+
+	var result ApplyWorkspaceEditResult
+	for attempt := 0; attempt < maxEditAttempts; attempt++ {
+		// Get this first, so that a change that comes in while the editor is
+		// applying the edit isn't missed
+		changed := h.state.TextChanged()
+		edits, version, err := h.state.TransformEdits(req)
+		if err != nil {
+			return state.EditResult{Reason: err.Error()}, nil
+		}
+		params := ApplyWorkspaceEditParams{
+			Label: "pair-ls",
+			Edit:  h.workspaceEdit(uri, version, edits),
+		}
+		if err := h.lspConn.Call(context.Background(), "workspace/applyEdit", params, &result); err != nil {
+			return state.EditResult{}, err
+		}
+		// Without a versioned edit the editor can't tell us that the document
+		// changed underneath us, so there's nothing to retry
+		if result.Applied || !h.clientDocumentChanges {
+			break
+		}
+		// The editor's version is newer than ours. Retrying is only worth it
+		// once we've seen that change.
+		select {
+		case <-changed:
+			h.logger.Println("Editor rejected edit, retrying:", result.FailureReason)
+		case <-time.After(editRetryTimeout):
+			h.logger.Println("Editor rejected edit:", result.FailureReason)
+			return state.EditResult{Reason: result.FailureReason}, nil
+		}
+	}
+	return state.EditResult{
+		Applied: result.Applied,
+		Reason:  result.FailureReason,
+	}, nil
+}
+
+// workspaceEdit creates an edit to a single document. If the editor supports
+// it, the edit only applies to the given version of the document.
+func (h *LspHandler) workspaceEdit(uri lsp.DocumentURI, version int, edits []lsp.TextEdit) WorkspaceEdit {
+	if !h.clientDocumentChanges {
+		return WorkspaceEdit{
+			Changes: map[lsp.DocumentURI][]lsp.TextEdit{uri: edits},
+		}
+	}
+	return WorkspaceEdit{
+		DocumentChanges: []TextDocumentEdit{{
+			TextDocument: lsp.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+				Version:                version,
+			},
+			Edits: edits,
+		}},
+	}
+}
+
+func (h *LspHandler) handleSubmitEdit(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.EditRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	result, err := h.state.SubmitEdit(params)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return result, nil
+}
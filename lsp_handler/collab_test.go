@@ -0,0 +1,70 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"pair-ls/state"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestApplyViewerEditWaitsForChange(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	h := NewHandler(state.NewState(logger), logger, &HandlerConfig{Collaborate: true})
+	h.clientApplyEdit = true
+	h.clientDocumentChanges = true
+	h.uris["a.txt"] = "file:///a.txt"
+	h.state.OpenFile("a.txt", "hello", "plaintext", 1, false)
+
+	// The editor rejects the first edit because the user typed something
+	// that we haven't heard about yet
+	var mu sync.Mutex
+	versions := make([]int, 0)
+	editorSide, ourSide := net.Pipe()
+	editor := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(editorSide, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(
+		func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+			var params ApplyWorkspaceEditParams
+			if err := json.Unmarshal(*req.Params, &params); err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			versions = append(versions, params.Edit.DocumentChanges[0].TextDocument.Version)
+			if len(versions) == 1 {
+				time.AfterFunc(100*time.Millisecond, func() {
+					h.state.ReplaceTextRanges("a.txt", []lsp.TextDocumentContentChangeEvent{
+						{Range: &lsp.Range{Start: lsp.Position{}, End: lsp.Position{}}, Text: ">> "},
+					}, 2, false)
+				})
+				return ApplyWorkspaceEditResult{FailureReason: "Version mismatch"}, nil
+			}
+			return ApplyWorkspaceEditResult{Applied: true}, nil
+		}))
+	defer editor.Close()
+	h.lspConn = jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(ourSide, jsonrpc2.VSCodeObjectCodec{}), jsonrpc2.HandlerWithError(h.handle))
+	defer h.lspConn.Close()
+
+	result, err := h.applyViewerEdit(state.EditRequest{
+		Filename: "a.txt",
+		Version:  1,
+		Edits:    []lsp.TextEdit{{Range: lsp.Range{Start: lsp.Position{Character: 5}, End: lsp.Position{Character: 5}}, NewText: "!"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Applied {
+		t.Errorf("Edit was not applied: %s", result.Reason)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(versions) != 2 || versions[0] != 1 || versions[1] != 2 {
+		t.Errorf("Editor was sent edits for versions %v, want [1 2]", versions)
+	}
+}
@@ -7,10 +7,10 @@ import (
 	"fmt"
 	"net/url"
 	"pair-ls/server"
+	"pair-ls/state"
 	"pair-ls/util"
 	"strings"
 
-	"github.com/pion/randutil"
 	"github.com/pion/webrtc/v3"
 	"github.com/sourcegraph/jsonrpc2"
 )
@@ -19,6 +19,9 @@ type ClientAuthConfig struct {
 	CertFile string `json:"certFile"`
 	KeyFile  string `json:"keyFile"`
 	Password string `json:"password"`
+	Username string `json:"username"`
+	// Extra CA certificates to trust when verifying the relay/signal server
+	CAFile string `json:"caFile"`
 }
 
 func (h *LspHandler) listenForRTC(signalServer string, config ClientAuthConfig) {
@@ -44,28 +47,6 @@ func (h *LspHandler) listenForRTC(signalServer string, config ClientAuthConfig)
 	<-conn.DisconnectNotify()
 }
 
-func (s *LspHandler) setConn(clientID string, conn *webrtc.PeerConnection) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.peerMap[clientID] = conn
-}
-
-func (s *LspHandler) getConn(clientID string) *webrtc.PeerConnection {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.peerMap[clientID]
-}
-
-func (s *LspHandler) delConn(clientID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.peerMap, clientID)
-}
-
-func createClientID() (string, error) {
-	return randutil.GenerateCryptoRandomString(4, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-}
-
 func (h *LspHandler) handleSignalRPC(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -102,23 +83,30 @@ func (h *LspHandler) handleCall(ctx context.Context, conn *jsonrpc2.Conn, req *j
 		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
 	}
 
-	var offer webrtc.SessionDescription
-	if err := json.Unmarshal(*req.Params, &offer); err != nil {
+	var params server.CallRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
 		return nil, err
 	}
 
-	peerConnection, answer, err := h.connectToPeer(offer)
-	if err != nil {
-		return nil, err
-	}
-	clientID, err := createClientID()
+	// Send our candidates to the browser through the signal server as they
+	// are gathered
+	clientID, answer, err := h.connectToPeer(PeerSourceSignal, params.Name, params.EditProof, params.SessionDescription, func(clientID string, c *webrtc.ICECandidate) {
+		var candidate *webrtc.ICECandidateInit
+		if c != nil {
+			init := c.ToJSON()
+			candidate = &init
+		}
+		err := conn.Notify(context.Background(), "ice", server.EditorCandidate{
+			ClientID:  clientID,
+			Candidate: candidate,
+		})
+		if err != nil {
+			h.logger.Println("Error sending ICE candidate", err)
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	h.setConn(clientID, peerConnection)
-	h.runPeerConnection(peerConnection, func() {
-		h.delConn(clientID)
-	})
 
 	return server.CallResponse{
 		Answer:   *answer,
@@ -135,15 +123,15 @@ func (h *LspHandler) handleIce(ctx context.Context, conn *jsonrpc2.Conn, req *js
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
 		return nil, err
 	}
-	peerConn := h.getConn(params.ClientID)
+	peerConn := h.peers.get(params.ClientID)
 	if peerConn == nil {
 		return nil, errors.New(fmt.Sprintf("No peer found with ID %s", params.ClientID))
 	}
-	peerConn.AddICECandidate(params.Candidate)
-	return nil, nil
+	return nil, peerConn.AddICECandidate(params.Candidate)
 }
 
-func (h *LspHandler) handlePeerRPC(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+// handlePeerRPC handles requests from the WebRTC viewer with the given peer ID
+func (h *LspHandler) handlePeerRPC(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request, peerID string) (interface{}, error) {
 	defer func() {
 		if r := recover(); r != nil {
 			h.logger.Println("Error handling peer RPC", req.Method, r)
@@ -152,6 +140,25 @@ func (h *LspHandler) handlePeerRPC(ctx context.Context, conn *jsonrpc2.Conn, req
 	switch req.Method {
 	case "getText":
 		return h.handleGetFile(ctx, conn, req)
+	case "getOutline":
+		return h.handleGetOutline(ctx, conn, req)
+	case "search":
+		return h.handleSearch(ctx, conn, req)
+	case "getDiff":
+		return h.handleGetDiff(ctx, conn, req)
+	case "submitEdit":
+		if !h.peers.canEdit(peerID) {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "Not allowed to edit"}
+		}
+		return h.handleSubmitEdit(ctx, conn, req)
+	case "proposeEdit":
+		return h.handleProposeEdit(ctx, conn, req)
+	case "requestFocus":
+		return h.handleRequestFocus(ctx, conn, req)
+	case "hover":
+		return h.handleHover(ctx, conn, req)
+	case "definition":
+		return h.handleDefinition(ctx, conn, req)
 	}
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
 }
@@ -168,3 +175,46 @@ func (h *LspHandler) handleGetFile(ctx context.Context, conn *jsonrpc2.Conn, req
 
 	return h.state.GetFile(params.Filename), nil
 }
+
+func (h *LspHandler) handleGetOutline(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params server.GetFileRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	symbols, err := h.state.GetOutline(params.Filename)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+	}
+	return symbols, nil
+}
+
+func (h *LspHandler) handleSearch(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.SearchRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	var emit func([]state.SearchMatch)
+	if params.Token != "" {
+		emit = func(matches []state.SearchMatch) {
+			conn.Notify(ctx, "searchResults", state.SearchResultsNotification{
+				Token:   params.Token,
+				Matches: matches,
+			})
+		}
+	}
+	results, err := h.state.Search(params, emit)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+	}
+	return results, nil
+}
@@ -0,0 +1,197 @@
+package lsp_handler
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"pair-ls/server"
+	"pair-ls/state"
+	"pair-ls/viewer"
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// After this many events, send the relay a fresh snapshot so it can drop the
+// old event log
+const e2eSnapshotInterval = 500
+
+// e2eForwarder encrypts the workspace for an end-to-end encrypted relay. The
+// key only appears in the fragment of the share URL, which browsers never
+// send to the server.
+type e2eForwarder struct {
+	logger *log.Logger
+	key    []byte
+	aead   cipher.AEAD
+	queue  chan []byte
+	// The workspace exactly as a viewer sees it, so that snapshots are always
+	// consistent with the events already sent
+	workspace     *viewer.Workspace
+	sinceSnapshot int
+	// The name of the workspace on the relay, which viewers find in the share
+	// URL. Blobs are bound to it and numbered so the relay can't tamper with
+	// the stream.
+	registered chan string
+	name       string
+	seq        uint64
+	// Closed when events stop being sent, so that they stop being queued
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newE2EForwarder(logger *log.Logger) (*e2eForwarder, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	aead, err := server.NewE2ECipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &e2eForwarder{
+		logger:     logger,
+		key:        key,
+		aead:       aead,
+		queue:      make(chan []byte, 4096),
+		workspace:  viewer.NewWorkspace(),
+		registered: make(chan string, 1),
+		stopped:    make(chan struct{}),
+	}, nil
+}
+
+// URLFragment is appended to the share URL
+func (f *e2eForwarder) URLFragment() string {
+	return "#k=" + base64.RawURLEncoding.EncodeToString(f.key)
+}
+
+// onStateEvent is subscribed to the workspace state. It runs while the state
+// is locked, so it serializes the event immediately (the event may share
+// memory with the state) and leaves the rest to run().
+func (f *e2eForwarder) onStateEvent(value interface{}) {
+	method, ok := server.EventMethod(value)
+	if !ok {
+		f.logger.Printf("Received unknown type from state %T\n", value)
+		return
+	}
+	f.enqueue(method, value)
+	// Viewers can't ask the relay for file text, so send it along with the file
+	if open, ok := value.(state.OpenFileEvent); ok {
+		f.enqueue("textReplaced", state.ReplaceTextEvent{
+			FileID:  open.ID,
+			Text:    open.Lines,
+			Version: open.Version,
+		})
+	}
+}
+
+func (f *e2eForwarder) enqueue(method string, params interface{}) {
+	select {
+	case <-f.stopped:
+		return
+	default:
+	}
+	paramBytes, err := json.Marshal(params)
+	if err != nil {
+		f.logger.Println("Error serializing event", err)
+		return
+	}
+	data, err := json.Marshal(server.E2EMessage{Method: method, Params: paramBytes})
+	if err != nil {
+		f.logger.Println("Error serializing event", err)
+		return
+	}
+	// This runs with the state locked, so it must never wait for the relay
+	select {
+	case f.queue <- data:
+	default:
+		// Viewers would see a workspace that's missing changes, so stop
+		// sharing instead
+		f.logger.Println("Relay server is not keeping up, stopping the encrypted share")
+		f.stop()
+	}
+}
+
+// register is called when the relay tells us the name of our workspace
+func (f *e2eForwarder) register(name string) {
+	select {
+	case f.registered <- name:
+	default:
+	}
+}
+
+func (f *e2eForwarder) stop() {
+	f.stopOnce.Do(func() {
+		close(f.stopped)
+	})
+}
+
+func (f *e2eForwarder) send(conn *jsonrpc2.Conn, method string, kind byte, plaintext []byte) error {
+	data, err := server.EncryptE2E(f.aead, kind, f.seq, f.name, plaintext)
+	if err != nil {
+		return err
+	}
+	return conn.Notify(context.Background(), method, server.E2EBlob{Data: data})
+}
+
+func (f *e2eForwarder) sendSnapshot(conn *jsonrpc2.Conn) error {
+	params, err := json.Marshal(f.workspace.Snapshot())
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(server.E2EMessage{Method: "initialize", Params: params})
+	if err != nil {
+		return err
+	}
+	f.sinceSnapshot = 0
+	return f.send(conn, "e2e/snapshot", server.E2ESnapshot, data)
+}
+
+// run sends encrypted events to the relay until the connection closes or the
+// queue overflows. Nothing is sent until the relay names the workspace.
+func (f *e2eForwarder) run(conn *jsonrpc2.Conn) {
+	defer f.stop()
+	select {
+	case f.name = <-f.registered:
+	case <-conn.DisconnectNotify():
+		return
+	case <-f.stopped:
+		return
+	}
+	if err := f.sendSnapshot(conn); err != nil {
+		f.logger.Println("Error sending encrypted snapshot", err)
+		return
+	}
+	for {
+		select {
+		case data := <-f.queue:
+			var msg server.E2EMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				f.logger.Println("Error parsing queued event", err)
+				continue
+			}
+			if _, err := f.workspace.Apply(msg.Method, msg.Params); err != nil {
+				f.logger.Println("Error applying event", err)
+			}
+			if err := f.send(conn, "e2e/publish", server.E2EEvent, data); err != nil {
+				f.logger.Println("Error sending encrypted event", err)
+				return
+			}
+			f.seq++
+			f.sinceSnapshot++
+			if f.sinceSnapshot >= e2eSnapshotInterval {
+				if err := f.sendSnapshot(conn); err != nil {
+					f.logger.Println("Error sending encrypted snapshot", err)
+					return
+				}
+			}
+		case <-conn.DisconnectNotify():
+			return
+		case <-f.stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pair-ls/state"
+	"path/filepath"
+	"time"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Values for HandlerConfig.FocusRequests
+const (
+	// Jump to the location right away if the editor can
+	FocusRequestsShow = "show"
+	// Ask the sharer first
+	FocusRequestsPrompt = "prompt"
+	// Reject focus requests
+	FocusRequestsOff = "off"
+)
+
+// How long to wait for the sharer to answer a focus request prompt
+const focusPromptTimeout = time.Minute
+
+const (
+	focusActionGo     = "Go there"
+	focusActionIgnore = "Ignore"
+	focusActionOK     = "OK"
+)
+
+// requestFocus is the state.FocusHandler. It moves the sharer's editor to the
+// location a viewer asked for, or asks the sharer if they want to go there.
+func (h *LspHandler) requestFocus(req state.FocusRequest) (state.FocusResult, error) {
+	h.mu.Lock()
+	uri, ok := h.uris[req.Filename]
+	h.mu.Unlock()
+	if !ok {
+		return state.FocusResult{}, fmt.Errorf("File is not open: %s", req.Filename)
+	}
+
+	selection := h.state.ToEditor(req.Filename, req.Range)
+	if h.config.FocusRequests == FocusRequestsShow && h.clientShowDocument {
+		return h.showDocument(uri, selection)
+	}
+
+	message := fmt.Sprintf("A viewer asked you to look at %s:%d", filepath.Base(req.Filename), req.Range.Start.Line+1)
+	if req.Message != "" {
+		message += ": " + req.Message
+	}
+	actions := []lsp.MessageActionItem{{Title: focusActionOK}}
+	if h.clientShowDocument {
+		actions = []lsp.MessageActionItem{{Title: focusActionGo}, {Title: focusActionIgnore}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), focusPromptTimeout)
+	defer cancel()
+	var choice *lsp.MessageActionItem
+	err := h.lspConn.Call(ctx, "window/showMessageRequest", lsp.ShowMessageRequestParams{
+		Type:    lsp.Info,
+		Message: message,
+		Actions: actions,
+	}, &choice)
+	if err == context.DeadlineExceeded {
+		return state.FocusResult{Status: state.FocusDeclined, Reason: "No response"}, nil
+	} else if err != nil {
+		return state.FocusResult{}, err
+	}
+	if choice == nil || choice.Title == focusActionIgnore {
+		return state.FocusResult{Status: state.FocusDeclined}, nil
+	} else if choice.Title == focusActionOK {
+		return state.FocusResult{Status: state.FocusAcknowledged}, nil
+	}
+	// The file may have changed while the sharer decided
+	return h.showDocument(uri, h.state.ToEditor(req.Filename, req.Range))
+}
+
+func (h *LspHandler) showDocument(uri lsp.DocumentURI, selection lsp.Range) (state.FocusResult, error) {
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err := h.lspConn.Call(context.Background(), "window/showDocument", ShowDocumentParams{
+		URI:       uri,
+		TakeFocus: true,
+		Selection: &selection,
+	}, &result)
+	if err != nil {
+		return state.FocusResult{}, err
+	}
+	if !result.Success {
+		return state.FocusResult{Status: state.FocusFailed, Reason: "Editor could not show the document"}, nil
+	}
+	return state.FocusResult{Status: state.FocusShown}, nil
+}
+
+func (h *LspHandler) handleRequestFocus(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.FocusRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	result, err := h.state.RequestFocus(params)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return result, nil
+}
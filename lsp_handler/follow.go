@@ -0,0 +1,271 @@
+package lsp_handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"pair-ls/server"
+	"pair-ls/state"
+	"pair-ls/viewer"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// FollowURIScheme is used for the sharer's files in the follower's editor
+const FollowURIScheme = "pairls"
+
+// FollowHandler runs in the editor of someone following a share. It's the
+// reverse of LspHandler: it connects to the share as a viewer, and tells the
+// editor to open the sharer's files as virtual documents and move the cursor
+// along with the sharer.
+type FollowHandler struct {
+	logger  *log.Logger
+	config  *FollowConfig
+	lspConn *jsonrpc2.Conn
+	mu      sync.Mutex
+	client  *viewer.Client
+	follow  bool
+	// Set when the editor can open documents with window/showDocument
+	canShowDocument bool
+	// The latest view from the sharer, waiting to be sent to the editor
+	pendingView *FollowViewParams
+	viewChanged chan struct{}
+	// URIs of the shared files. Only used by onChange.
+	uris map[int32]lsp.DocumentURI
+}
+
+type FollowConfig struct {
+	// The share URL, as for pair-ls view
+	Target     string
+	Password   string
+	Name       string
+	ICEServers []server.ICEServerConfig
+}
+
+// FollowDocumentParams is sent with pairls/didChangeContent and
+// pairls/didClose, and is the request for pairls/content
+type FollowDocumentParams struct {
+	URI lsp.DocumentURI `json:"uri"`
+}
+
+// FollowContent is the response to pairls/content
+type FollowContent struct {
+	Text       string `json:"text"`
+	LanguageID string `json:"languageId"`
+	Filename   string `json:"filename"`
+}
+
+// FollowViewParams is sent with pairls/view whenever the sharer moves
+type FollowViewParams struct {
+	URI     lsp.DocumentURI        `json:"uri"`
+	Cursors []state.CursorPosition `json:"cursors"`
+}
+
+// ShowDocumentParams is the LSP 3.16 window/showDocument request, which
+// go-lsp doesn't have
+type ShowDocumentParams struct {
+	URI       lsp.DocumentURI `json:"uri"`
+	External  bool            `json:"external,omitempty"`
+	TakeFocus bool            `json:"takeFocus,omitempty"`
+	Selection *lsp.Range      `json:"selection,omitempty"`
+}
+
+func NewFollowHandler(logger *log.Logger, config *FollowConfig) *FollowHandler {
+	return &FollowHandler{
+		logger:      logger,
+		config:      config,
+		follow:      true,
+		viewChanged: make(chan struct{}, 1),
+		uris:        make(map[int32]lsp.DocumentURI),
+	}
+}
+
+// FollowURI is the URI of a shared file in the follower's editor
+func FollowURI(filename string) lsp.DocumentURI {
+	u := url.URL{
+		Scheme: FollowURIScheme,
+		Path:   "/" + strings.TrimPrefix(filepath.ToSlash(filename), "/"),
+	}
+	return lsp.DocumentURI(u.String())
+}
+
+func (h *FollowHandler) ListenOnStdin(loglevel int) {
+	var connOpt []jsonrpc2.ConnOpt
+	if loglevel >= 5 {
+		connOpt = append(connOpt, jsonrpc2.LogMessages(h.logger))
+	}
+
+	h.logger.Println("Follow server listening on stdin")
+	defer h.logger.Println("Follow server stopped")
+	h.lspConn = jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(stdrwc{}, jsonrpc2.VSCodeObjectCodec{}),
+		jsonrpc2.HandlerWithError(h.handle),
+		connOpt...)
+	go h.sendViews()
+	<-h.lspConn.DisconnectNotify()
+	if client := h.getClient(); client != nil {
+		client.Close()
+	}
+}
+
+func (h *FollowHandler) getClient() *viewer.Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.client
+}
+
+// connect joins the share once the editor is ready for notifications
+func (h *FollowHandler) connect() {
+	if !strings.Contains(h.config.Target, "://") {
+		h.showMessage("pair-ls follow needs a share URL", lsp.MTError)
+		return
+	}
+	client, err := viewer.Dial(context.Background(), h.config.Target, viewer.Options{
+		Password:   h.config.Password,
+		Name:       h.config.Name,
+		ICEServers: h.config.ICEServers,
+		OnChange:   h.onChange,
+	})
+	if err != nil {
+		h.logger.Println("Error connecting to share", err)
+		h.showMessage(fmt.Sprintf("Could not connect to %s: %v", h.config.Target, err), lsp.MTError)
+		return
+	}
+	h.mu.Lock()
+	h.client = client
+	h.mu.Unlock()
+	h.showMessage("Following "+h.config.Target, lsp.Info)
+	<-client.DisconnectNotify()
+	if err := client.Err(); err != nil {
+		h.showMessage(fmt.Sprintf("Disconnected from share: %v", err), lsp.MTError)
+	} else {
+		h.showMessage("Disconnected from share", lsp.MTWarning)
+	}
+}
+
+// onChange runs on the share connection's read loop with the workspace locked
+func (h *FollowHandler) onChange(w *viewer.Workspace, method string, fileID int32) {
+	switch method {
+	case "initialize":
+		h.uris = make(map[int32]lsp.DocumentURI)
+		for id, file := range w.Files {
+			h.uris[id] = FollowURI(file.Filename)
+		}
+	case "openFile":
+		h.uris[fileID] = FollowURI(w.Files[fileID].Filename)
+		return
+	case "textReplaced", "updateText":
+		if uri, ok := h.uris[fileID]; ok {
+			h.notify("pairls/didChangeContent", FollowDocumentParams{URI: uri})
+		}
+		return
+	case "closeFile":
+		// The file is already gone from the workspace
+		if uri, ok := h.uris[fileID]; ok {
+			delete(h.uris, fileID)
+			h.notify("pairls/didClose", FollowDocumentParams{URI: uri})
+		}
+		return
+	case "updateView":
+	default:
+		return
+	}
+	if w.View == nil || w.Files[w.View.FileID] == nil {
+		return
+	}
+	file := w.Files[w.View.FileID]
+	view := &FollowViewParams{
+		URI:     FollowURI(file.Filename),
+		Cursors: w.View.Cursors,
+	}
+	// The view is in code points, and the editor expects UTF-16
+	if w.Loaded(file.ID) {
+		view.Cursors = make([]state.CursorPosition, 0, len(w.View.Cursors))
+		for _, cursor := range w.View.Cursors {
+			converted := state.CursorPosition{Position: toUTF16(file.Lines, cursor.Position)}
+			if cursor.Range != nil {
+				converted.Range = &lsp.Range{
+					Start: toUTF16(file.Lines, cursor.Range.Start),
+					End:   toUTF16(file.Lines, cursor.Range.End),
+				}
+			}
+			view.Cursors = append(view.Cursors, converted)
+		}
+	}
+	h.mu.Lock()
+	h.pendingView = view
+	h.mu.Unlock()
+	h.notify("pairls/view", view)
+	select {
+	case h.viewChanged <- struct{}{}:
+	default:
+	}
+}
+
+// sendViews moves the editor along with the sharer. window/showDocument is a
+// request, so this runs separately from the share connection and skips views
+// that were replaced while waiting for the editor.
+func (h *FollowHandler) sendViews() {
+	var lastURI lsp.DocumentURI
+	for {
+		select {
+		case <-h.viewChanged:
+		case <-h.lspConn.DisconnectNotify():
+			return
+		}
+		h.mu.Lock()
+		view := h.pendingView
+		follow := h.follow && h.canShowDocument
+		h.mu.Unlock()
+		if view == nil || !follow {
+			continue
+		}
+		params := ShowDocumentParams{
+			URI:       view.URI,
+			TakeFocus: view.URI != lastURI,
+		}
+		if len(view.Cursors) > 0 {
+			cursor := view.Cursors[0]
+			params.Selection = &lsp.Range{Start: cursor.Position, End: cursor.Position}
+		}
+		var result struct {
+			Success bool `json:"success"`
+		}
+		if err := h.lspConn.Call(context.Background(), "window/showDocument", params, &result); err != nil {
+			h.logger.Println("Error showing document", err)
+			continue
+		}
+		if result.Success {
+			lastURI = view.URI
+		}
+	}
+}
+
+func (h *FollowHandler) notify(method string, params interface{}) {
+	if err := h.lspConn.Notify(context.Background(), method, params); err != nil {
+		h.logger.Println("Error sending", method, err)
+	}
+}
+
+func (h *FollowHandler) showMessage(message string, mType lsp.MessageType) {
+	h.notify("window/showMessage", lsp.ShowMessageParams{
+		Type:    mType,
+		Message: message,
+	})
+}
+
+func toUTF16(lines []string, pos lsp.Position) lsp.Position {
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return pos
+	}
+	return lsp.Position{
+		Line:      pos.Line,
+		Character: state.ConvertCharacter(lines[pos.Line], pos.Character, state.ViewEncoding, state.PositionEncodingUTF16),
+	}
+}
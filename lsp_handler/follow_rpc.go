@@ -0,0 +1,162 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pair-ls/viewer"
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// FollowFile is an entry in the response to pairls/listFiles
+type FollowFile struct {
+	URI        lsp.DocumentURI `json:"uri"`
+	Filename   string          `json:"filename"`
+	LanguageID string          `json:"languageId"`
+}
+
+type FollowModeParams struct {
+	Follow bool `json:"follow"`
+}
+
+func (h *FollowHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Println("Error handling", req.Method, r)
+		}
+	}()
+
+	switch req.Method {
+	case "initialize":
+		return h.handleInitialize(ctx, conn, req)
+	case "initialized":
+		go h.connect()
+		return nil, nil
+	case "shutdown":
+		if client := h.getClient(); client != nil {
+			client.Close()
+		}
+		return nil, nil
+	case "exit":
+		return nil, conn.Close()
+	case "pairls/content":
+		return h.handleContent(ctx, conn, req)
+	case "pairls/listFiles":
+		return h.handleListFiles(ctx, conn, req)
+	case "pairls/follow":
+		return h.handleFollowMode(ctx, conn, req)
+	}
+
+	// The editor will tell us about its own documents, but we don't care
+	if req.Notif {
+		return nil, nil
+	}
+	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
+}
+
+func (h *FollowHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	// go-lsp doesn't know about window capabilities
+	var params struct {
+		Capabilities struct {
+			Window struct {
+				ShowDocument struct {
+					Support bool `json:"support"`
+				} `json:"showDocument"`
+			} `json:"window"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.canShowDocument = params.Capabilities.Window.ShowDocument.Support
+	h.mu.Unlock()
+	if !params.Capabilities.Window.ShowDocument.Support {
+		h.logger.Println("Client does not support window/showDocument. Only pairls/view notifications will be sent.")
+	}
+
+	return lsp.InitializeResult{
+		Capabilities: lsp.ServerCapabilities{},
+	}, nil
+}
+
+func (h *FollowHandler) handleContent(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params FollowDocumentParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	client := h.getClient()
+	if client == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "Not connected to a share"}
+	}
+	var content *FollowContent
+	client.Read(func(w *viewer.Workspace) {
+		for _, file := range w.Files {
+			if FollowURI(file.Filename) == params.URI {
+				content = &FollowContent{
+					Text:       strings.Join(file.Lines, "\n"),
+					LanguageID: file.Language,
+					Filename:   file.Filename,
+				}
+				return
+			}
+		}
+	})
+	if content == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("File is not shared: %s", params.URI)}
+	}
+	return content, nil
+}
+
+func (h *FollowHandler) handleListFiles(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	files := make([]FollowFile, 0)
+	if client := h.getClient(); client != nil {
+		client.Read(func(w *viewer.Workspace) {
+			for _, file := range w.Files {
+				files = append(files, FollowFile{
+					URI:        FollowURI(file.Filename),
+					Filename:   file.Filename,
+					LanguageID: file.Language,
+				})
+			}
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+	return files, nil
+}
+
+func (h *FollowHandler) handleFollowMode(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params FollowModeParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.follow = params.Follow
+	h.mu.Unlock()
+	// Jump back to the sharer
+	if params.Follow {
+		select {
+		case h.viewChanged <- struct{}{}:
+		default:
+		}
+	}
+	return nil, nil
+}
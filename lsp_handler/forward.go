@@ -3,13 +3,17 @@ package lsp_handler
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
-	"fmt"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
 	"pair-ls/auth"
+	"pair-ls/server"
 	"pair-ls/util"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -34,32 +38,169 @@ func (h *LspHandler) forward(config ClientAuthConfig) {
 	conn := jsonrpc2.NewConn(
 		context.Background(),
 		jsonrpc2.NewBufferedStream(util.WrapWebsocket(c), jsonrpc2.PlainObjectCodec{}),
-		jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
-			return nil, nil
-		}),
+		&relayHandler{next: jsonrpc2.HandlerWithError(h.handleRelayRPC)},
 	)
-	h.SendShareString(util.CreateShareURL(h.config.RelayServer, ""))
-	func() {
-		defer c.Close()
-		for {
-			req := <-h.forwardChan
+	defer c.Close()
+	if h.e2e != nil {
+		h.e2e.run(conn)
+		return
+	}
+	for {
+		pending, ok := h.forwardQueue.wait()
+		if !ok {
+			// Closing the connection takes the workspace off the relay
+			return
+		}
+		for _, req := range pending {
 			conn.Notify(context.Background(), req.Method, req.Params)
 		}
-	}()
+	}
+}
+
+// How many messages can wait for the relay server before we give up on it
+const maxForwardQueue = 4096
+
+// forwardQueue holds the messages waiting to be sent to the relay server.
+// Pushing never blocks, since it's done while handling LSP messages and with
+// the state locked, so a slow relay can't hold up the editor.
+type forwardQueue struct {
+	mu      sync.Mutex
+	logger  *log.Logger
+	pending []*jsonrpc2.Request
+	ready   chan struct{}
+	// Set when the queue overflows. Nothing is queued or sent after that.
+	stopped bool
+}
+
+func newForwardQueue(logger *log.Logger) *forwardQueue {
+	return &forwardQueue{
+		logger: logger,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (q *forwardQueue) push(req *jsonrpc2.Request) {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	if len(q.pending) >= maxForwardQueue {
+		// Viewers would see a workspace that's missing changes, so stop
+		// sharing instead
+		q.logger.Println("Relay server is not keeping up, stopping the share")
+		q.stopped = true
+		q.pending = nil
+	} else {
+		q.pending = append(q.pending, req)
+	}
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the queued messages, blocking until there is at least one. It
+// returns false once the queue has overflowed.
+func (q *forwardQueue) wait() ([]*jsonrpc2.Request, bool) {
+	for {
+		q.mu.Lock()
+		pending := q.pending
+		stopped := q.stopped
+		q.pending = nil
+		q.mu.Unlock()
+		if stopped {
+			return nil, false
+		}
+		if len(pending) > 0 {
+			return pending, true
+		}
+		<-q.ready
+	}
+}
+
+// forwardNotification queues a notification for the relay server
+func (h *LspHandler) forwardNotification(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		h.logger.Println("Error serializing", method, err)
+		return
+	}
+	raw := json.RawMessage(data)
+	h.forwardQueue.push(&jsonrpc2.Request{Method: method, Params: &raw, Notif: true})
+}
+
+func (h *LspHandler) handleRelayRPC(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	switch req.Method {
+	case "register":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params server.RegisterResponse
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		shareURL := util.CreateShareURL(h.config.RelayServer, url.PathEscape(params.Token))
+		if h.e2e != nil {
+			h.e2e.register(params.Token)
+			shareURL += h.e2e.URLFragment()
+		}
+		h.SendShareString(shareURL)
+	case "submitEdit":
+		// Edits from viewers of the relay
+		return h.handleSubmitEdit(ctx, conn, req)
+	case "proposeEdit":
+		return h.handleProposeEdit(ctx, conn, req)
+	case "requestFocus":
+		return h.handleRequestFocus(ctx, conn, req)
+	case "hover":
+		return h.handleHover(ctx, conn, req)
+	case "definition":
+		return h.handleDefinition(ctx, conn, req)
+	}
+	return nil, nil
+}
+
+// Handles the relay server's messages in order, except for the requests that
+// wait for the editor (or its user) to answer. Those run on their own so they
+// don't hold up the rest.
+type relayHandler struct {
+	next jsonrpc2.Handler
+}
+
+func (h *relayHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	switch req.Method {
+	case "requestFocus", "hover", "definition":
+		go h.next.Handle(ctx, conn, req)
+	default:
+		h.next.Handle(ctx, conn, req)
+	}
 }
 
 func wsDialServer(urlStr string, config ClientAuthConfig) (*websocket.Conn, error) {
 	var tlsConfig *tls.Config = nil
-	if config.CertFile != "" {
+	if config.CertFile == "" && config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool.AppendCertsFromPEM(data)
+		tlsConfig = &tls.Config{RootCAs: pool}
+	} else if config.CertFile != "" {
 		var err error
-		tlsConfig, err = auth.LoadTLSConfig(config.CertFile, config.KeyFile)
+		tlsConfig, err = auth.LoadTLSConfig([]string{config.CAFile}, config.CertFile, config.KeyFile)
 		if err != nil {
 			return nil, err
 		}
 	}
 	header := http.Header{}
 	if config.Password != "" {
-		header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(config.Password))))
+		header.Add("Authorization", auth.FormatBasicAuth(config.Username, config.Password))
 	}
 	dialer := &websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment,
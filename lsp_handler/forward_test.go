@@ -0,0 +1,31 @@
+package lsp_handler
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func TestForwardQueueOverflow(t *testing.T) {
+	q := newForwardQueue(log.New(ioutil.Discard, "", 0))
+	for i := 0; i < maxForwardQueue; i++ {
+		q.push(&jsonrpc2.Request{Method: "a"})
+	}
+	pending, ok := q.wait()
+	if !ok || len(pending) != maxForwardQueue {
+		t.Fatalf("Got %d messages, %v", len(pending), ok)
+	}
+
+	for i := 0; i <= maxForwardQueue; i++ {
+		q.push(&jsonrpc2.Request{Method: "b"})
+	}
+	if pending, ok := q.wait(); ok {
+		t.Errorf("Queue kept going after overflowing, with %d messages", len(pending))
+	}
+	q.push(&jsonrpc2.Request{Method: "c"})
+	if pending, ok := q.wait(); ok {
+		t.Errorf("Queue accepted %d messages after overflowing", len(pending))
+	}
+}
@@ -0,0 +1,96 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"pair-ls/state"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// go-lsp doesn't have CodeAction literals
+type CodeAction struct {
+	Title   string             `json:"title"`
+	Kind    lsp.CodeActionKind `json:"kind,omitempty"`
+	Edit    *WorkspaceEdit     `json:"edit,omitempty"`
+	Command *lsp.Command       `json:"command,omitempty"`
+}
+
+// handleTextDocumentCodeAction offers edits proposed by viewers as quick fixes
+func (h *LspHandler) handleTextDocumentCodeAction(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params lsp.CodeActionParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	filename, err := h.filenameFromURI(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil
+	}
+
+	actions := make([]CodeAction, 0)
+	rng := h.state.FromEditor(filename, params.Range)
+	for _, proposal := range h.state.GetProposals(filename) {
+		if !rangesTouch(proposal.Range, rng) {
+			continue
+		}
+		title := proposal.Title
+		if title == "" {
+			title = "Apply suggested edit"
+		}
+		apply := CodeAction{
+			Title: "pair-ls: " + title,
+			Kind:  lsp.CAKQuickFix,
+		}
+		if h.clientApplyEdit {
+			apply.Command = proposalCommand(apply.Title, applyProposalCommand, proposal)
+		} else {
+			// Let the editor apply the edit itself, then tell us it did
+			edit := h.workspaceEdit(params.TextDocument.URI, proposal.Version, []lsp.TextEdit{{
+				Range:   h.state.ToEditor(filename, proposal.Range),
+				NewText: proposal.NewText,
+			}})
+			apply.Edit = &edit
+			apply.Command = proposalCommand(apply.Title, acceptProposalCommand, proposal)
+		}
+		dismissTitle := "pair-ls: Dismiss suggested edit"
+		actions = append(actions, apply, CodeAction{
+			Title:   dismissTitle,
+			Kind:    lsp.CAKQuickFix,
+			Command: proposalCommand(dismissTitle, dismissProposalCommand, proposal),
+		})
+	}
+
+	if h.clientCodeActionLiterals {
+		return actions, nil
+	}
+	// Older clients only understand commands
+	commands := make([]lsp.Command, 0, len(actions))
+	for _, action := range actions {
+		if action.Edit == nil {
+			commands = append(commands, *action.Command)
+		}
+	}
+	return commands, nil
+}
+
+func proposalCommand(title string, command string, proposal state.Proposal) *lsp.Command {
+	return &lsp.Command{
+		Title:     title,
+		Command:   command,
+		Arguments: []interface{}{proposal.ID},
+	}
+}
+
+// rangesTouch is true if the ranges overlap or are next to each other
+func rangesTouch(a lsp.Range, b lsp.Range) bool {
+	return !positionBefore(a.End, b.Start) && !positionBefore(b.End, a.Start)
+}
+
+func positionBefore(a lsp.Position, b lsp.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
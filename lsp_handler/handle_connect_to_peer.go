@@ -2,10 +2,9 @@ package lsp_handler
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"pair-ls/util"
 
-	"github.com/pion/webrtc/v3"
 	"github.com/sourcegraph/jsonrpc2"
 )
 
@@ -13,11 +12,6 @@ type PeerConnectRequestParams struct {
 	Token string `json:"token,omitempty"`
 }
 
-type PeerToken struct {
-	Desc     *webrtc.SessionDescription `json:"desc"`
-	ClientID string                     `json:"client_id,omitempty"`
-}
-
 func (h *LspHandler) handleConnectToPeer(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
 	if req.Params == nil {
 		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
@@ -31,16 +25,27 @@ func (h *LspHandler) handleConnectToPeer(ctx context.Context, conn *jsonrpc2.Con
 	if params.Token == "" {
 		// If no token was provided, initiate a WebRTC call
 		desc, clientID, err := h.callRTCPeer()
+		if err != nil {
+			return nil, err
+		}
 
-		json, err := json.Marshal(PeerToken{
+		token, err := util.EncodePeerToken(util.PeerToken{
 			Desc:     desc,
 			ClientID: clientID,
-		})
+		}, h.config.ManualRTC)
 		if err != nil {
 			return nil, err
 		}
 
-		token := base64.StdEncoding.EncodeToString(json)
+		if h.config.ManualRTC {
+			// Without the static site, the viewer uses "pair-ls join"
+			h.SendShareString("pair-ls join " + token)
+			return struct {
+				Token string `json:"token"`
+			}{
+				Token: token,
+			}, nil
+		}
 		return struct {
 			URL string `json:"url"`
 		}{
@@ -27,11 +27,12 @@ func (h *LspHandler) handleTextDocumentDidChange(ctx context.Context, conn *json
 			h.changeTextChan <- TextChange{
 				Filename: filename,
 				Text:     change.Text,
+				Version:  params.TextDocument.Version,
 			}
 			return nil, nil
 		}
 	}
-	h.state.ReplaceTextRanges(filename, params.ContentChanges, !h.clientSendsCursor)
+	h.state.ReplaceTextRanges(filename, params.ContentChanges, params.TextDocument.Version, !h.clientSendsCursor)
 
 	return nil, nil
 }
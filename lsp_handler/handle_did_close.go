@@ -22,6 +22,9 @@ func (h *LspHandler) handleTextDocumentDidClose(ctx context.Context, conn *jsonr
 	if err != nil {
 		return nil, nil
 	}
+	h.mu.Lock()
+	delete(h.uris, filename)
+	h.mu.Unlock()
 	h.state.CloseFile(filename)
 	return nil, nil
 }
@@ -3,6 +3,8 @@ package lsp_handler
 import (
 	"context"
 	"encoding/json"
+	"pair-ls/util"
+	"path/filepath"
 
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
@@ -19,8 +21,17 @@ func (h *LspHandler) handleTextDocumentDidOpen(ctx context.Context, conn *jsonrp
 	}
 	filename, err := h.filenameFromURI(params.TextDocument.URI)
 	if err != nil {
+		h.logger.Println("Not sharing", params.TextDocument.URI, err)
 		return nil, nil
 	}
-	h.state.OpenFile(filename, params.TextDocument.Text, params.TextDocument.LanguageID, !h.clientSendsCursor)
+	h.mu.Lock()
+	h.uris[filename] = params.TextDocument.URI
+	h.mu.Unlock()
+	if h.repo != nil && !h.state.HasBaseline(filename) {
+		if path, err := util.FromURI(params.TextDocument.URI); err == nil {
+			h.setGitBaseline(filename, filepath.Clean(path))
+		}
+	}
+	h.state.OpenFile(filename, params.TextDocument.Text, params.TextDocument.LanguageID, params.TextDocument.Version, !h.clientSendsCursor)
 	return nil, nil
 }
@@ -0,0 +1,50 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pair-ls/state"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func (h *LspHandler) handleWorkspaceExecuteCommand(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	// A relay server replays the editor's messages, but only the pair-ls
+	// running in the editor can run commands
+	if h.lspConn == nil {
+		return nil, nil
+	}
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params struct {
+		Command   string            `json:"command"`
+		Arguments []json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	var id int32
+	if len(params.Arguments) != 1 {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+	if err := json.Unmarshal(params.Arguments[0], &id); err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	switch params.Command {
+	case applyProposalCommand:
+		// This waits for a response from the editor, so it can't block the
+		// message loop
+		go h.applyProposal(id)
+	case acceptProposalCommand:
+		h.state.ResolveProposal(id, state.ProposalAccepted)
+	case dismissProposalCommand:
+		h.state.ResolveProposal(id, state.ProposalDismissed)
+	default:
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("Unknown command: %s", params.Command)}
+	}
+	return nil, nil
+}
@@ -3,6 +3,8 @@ package lsp_handler
 import (
 	"context"
 	"encoding/json"
+	"pair-ls/git"
+	"pair-ls/state"
 	"pair-ls/util"
 	"path/filepath"
 	"reflect"
@@ -21,6 +23,34 @@ func (h *LspHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn,
 		return nil, err
 	}
 
+	// go-lsp doesn't have the window or general capabilities
+	var capabilityParams struct {
+		Capabilities struct {
+			Window struct {
+				ShowDocument struct {
+					Support bool `json:"support"`
+				} `json:"showDocument"`
+			} `json:"window"`
+			General struct {
+				PositionEncodings []state.PositionEncoding `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(*req.Params, &capabilityParams); err != nil {
+		return nil, err
+	}
+	h.clientShowDocument = capabilityParams.Capabilities.Window.ShowDocument.Support
+	encoding := negotiateEncoding(capabilityParams.Capabilities.General.PositionEncodings)
+	h.state.SetPositionEncoding(encoding)
+
+	// go-lsp doesn't have workspace folders either
+	var folderParams struct {
+		WorkspaceFolders []WorkspaceFolder `json:"workspaceFolders"`
+	}
+	if err := json.Unmarshal(*req.Params, &folderParams); err != nil {
+		return nil, err
+	}
+
 	if params.RootURI != "" {
 		rootPath, err := util.FromURI(params.RootURI)
 		if err != nil {
@@ -28,6 +58,42 @@ func (h *LspHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn,
 		}
 		h.rootPath = filepath.Clean(rootPath)
 	}
+	h.mu.Lock()
+	h.addFolders(folderParams.WorkspaceFolders)
+	if h.rootPath == "" && len(h.folders) > 0 {
+		h.rootPath = h.folders[0].path
+	}
+	folders := h.folderNames()
+	h.mu.Unlock()
+	h.state.SetFolders(folders)
+	if h.forwardQueue != nil {
+		h.forwardSharing()
+	}
+
+	h.clientApplyEdit = params.Capabilities.Workspace.ApplyEdit
+	h.clientDocumentChanges = params.Capabilities.Workspace.WorkspaceEdit.DocumentChanges
+	h.clientCodeActionLiterals = len(params.Capabilities.TextDocument.CodeAction.CodeActionLiteralSupport.CodeActionKind.ValueSet) > 0
+	if h.config.Collaborate {
+		if !h.clientApplyEdit {
+			h.logger.Println("Editor does not support workspace/applyEdit. Edits from viewers will be rejected.")
+		}
+		h.state.SetEditHandler(h.applyViewerEdit)
+	}
+	if h.config.FocusRequests == FocusRequestsShow || h.config.FocusRequests == FocusRequestsPrompt {
+		h.state.SetFocusHandler(h.requestFocus)
+	}
+
+	// The relay server replays messages without an editor to answer these
+	if h.lspConn != nil {
+		h.state.SetLanguageHandler(h.askEditor)
+	}
+	// The relay server's copy of rootPath is on the editor's machine
+	if h.lspConn != nil && h.rootPath != "" {
+		if repo, err := git.Open(h.rootPath); err == nil {
+			h.repo = repo
+			go h.watchRepo(conn.DisconnectNotify())
+		}
+	}
 
 	exp := reflect.ValueOf(params.Capabilities.Experimental)
 	if exp.IsValid() && exp.Kind() == reflect.Map {
@@ -47,17 +113,62 @@ func (h *LspHandler) handleInitialize(ctx context.Context, conn *jsonrpc2.Conn,
 		}
 	}
 
-	return lsp.InitializeResult{
-		Capabilities: lsp.ServerCapabilities{
-			HoverProvider: !h.clientSendsCursor,
-			TextDocumentSync: &lsp.TextDocumentSyncOptionsOrKind{
-				Options: &lsp.TextDocumentSyncOptions{
-					OpenClose:         true,
-					Change:            lsp.TDSKIncremental,
-					WillSave:          false,
-					WillSaveWaitUntil: false,
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			PositionEncoding: encoding,
+			Workspace: &WorkspaceCapabilities{
+				WorkspaceFolders: WorkspaceFoldersCapabilities{
+					Supported:           true,
+					ChangeNotifications: true,
+				},
+			},
+			ServerCapabilities: lsp.ServerCapabilities{
+				HoverProvider: !h.clientSendsCursor,
+				// Offers edits proposed by viewers
+				CodeActionProvider: true,
+				ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
+					Commands: []string{applyProposalCommand, acceptProposalCommand, dismissProposalCommand},
+				},
+				TextDocumentSync: &lsp.TextDocumentSyncOptionsOrKind{
+					Options: &lsp.TextDocumentSyncOptions{
+						OpenClose:         true,
+						Change:            lsp.TDSKIncremental,
+						WillSave:          false,
+						WillSaveWaitUntil: false,
+					},
 				},
 			},
 		},
 	}, nil
 }
+
+// InitializeResult adds the workspace capabilities, which go-lsp doesn't have
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+type ServerCapabilities struct {
+	lsp.ServerCapabilities
+	PositionEncoding state.PositionEncoding `json:"positionEncoding,omitempty"`
+	Workspace        *WorkspaceCapabilities `json:"workspace,omitempty"`
+}
+
+type WorkspaceCapabilities struct {
+	WorkspaceFolders WorkspaceFoldersCapabilities `json:"workspaceFolders"`
+}
+
+type WorkspaceFoldersCapabilities struct {
+	Supported           bool `json:"supported"`
+	ChangeNotifications bool `json:"changeNotifications"`
+}
+
+// negotiateEncoding picks the first position encoding the editor offers that
+// can be converted. Editors that don't offer any use UTF-16.
+func negotiateEncoding(offered []state.PositionEncoding) state.PositionEncoding {
+	for _, encoding := range offered {
+		if encoding.Supported() {
+			return encoding
+		}
+	}
+	return state.PositionEncodingUTF16
+}
@@ -0,0 +1,11 @@
+package lsp_handler
+
+import (
+	"context"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func (h *LspHandler) handleListPeers(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	return h.peers.list(), nil
+}
@@ -7,5 +7,6 @@ import (
 )
 
 func (h *LspHandler) handleShutdown(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	h.peers.closeAll()
 	return nil, conn.Close()
 }
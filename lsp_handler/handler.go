@@ -2,15 +2,13 @@ package lsp_handler
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"pair-ls/git"
+	"pair-ls/server"
 	"pair-ls/state"
-	"pair-ls/util"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -28,18 +26,51 @@ type LspHandler struct {
 	state             *state.WorkspaceState
 	clientSendsCursor bool
 	changeTextChan    chan TextChange
-	forwardChan       chan *jsonrpc2.Request
-	peerMap           map[string]*webrtc.PeerConnection
+	forwardQueue      *forwardQueue
+	e2e               *e2eForwarder
+	peers             *peerManager
 	rtc               *webrtc.API
 	mu                sync.Mutex
 	pendingNotifs     []pendingNotif
+
+	// Used to apply edits from viewers in collaborative mode
+	clientApplyEdit       bool
+	clientDocumentChanges bool
+	uris                  map[string]lsp.DocumentURI
+	// Used to offer proposals from viewers as code actions
+	clientCodeActionLiterals bool
+	// Used to show the sharer where viewers want them to look
+	clientShowDocument bool
+	// Set if the editor can't answer hover and definition requests
+	languageUnsupported bool
+	// The git repository at rootPath, if there is one
+	repo *git.Repo
+	// The editor's workspace folders, if it has more than one root
+	folders []workspaceFolder
+	// Which files are shared
+	sharing FileSharing
 }
 
 type HandlerConfig struct {
 	RelayServer   string
+	E2E           bool
 	SignalServer  string
 	StaticRTCSite string
+	ManualRTC     bool
+	ICEServers    []server.ICEServerConfig
 	ClientAuth    ClientAuthConfig
+	// Allow viewers to edit the shared files
+	Collaborate bool
+	// WebRTC viewers can only edit if they prove they know this password
+	EditPassword string
+	// What to do when a viewer asks the sharer to look at something. One of
+	// FocusRequestsShow, FocusRequestsPrompt, or FocusRequestsOff.
+	FocusRequests string
+	// How to share files outside the workspace. One of OutsideRootIgnore or
+	// OutsideRootShare.
+	OutsideRoot string
+	// URI schemes of the documents to share. All schemes are shared if empty.
+	Schemes []string
 }
 
 func NewHandler(state *state.WorkspaceState, logger *log.Logger, config *HandlerConfig) *LspHandler {
@@ -53,12 +84,17 @@ func NewHandler(state *state.WorkspaceState, logger *log.Logger, config *Handler
 		state:          state,
 		rtc:            webrtc.NewAPI(webrtc.WithSettingEngine(s)),
 		changeTextChan: changeTextChan,
-		peerMap:        make(map[string]*webrtc.PeerConnection),
+		peers:          newPeerManager(),
+		uris:           make(map[string]lsp.DocumentURI),
 		pendingNotifs:  make([]pendingNotif, 0),
+		sharing: FileSharing{
+			OutsideRoot: config.OutsideRoot,
+			Schemes:     config.Schemes,
+		},
 	}
 	// TODO: make this configurable
 	go debounceChangeText(200*time.Millisecond, handler.changeTextChan, func(change TextChange) {
-		handler.state.ReplaceText(change.Filename, change.Text, !handler.clientSendsCursor)
+		handler.state.ReplaceText(change.Filename, change.Text, change.Version, !handler.clientSendsCursor)
 	})
 
 	return handler
@@ -75,14 +111,16 @@ func (h *LspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		}
 	}()
 
-	if h.forwardChan != nil {
-		h.forwardChan <- req
+	if h.forwardQueue != nil {
+		h.forwardQueue.push(req)
 	}
 	switch req.Method {
 	case "initialize":
 		return h.handleInitialize(ctx, conn, req)
 	case "initialized":
 		return h.handleInitialized(ctx, conn, req)
+	case "workspace/didChangeWorkspaceFolders":
+		return h.handleDidChangeWorkspaceFolders(ctx, conn, req)
 	case "shutdown":
 		return h.handleShutdown(ctx, conn, req)
 	case "textDocument/didOpen":
@@ -93,10 +131,26 @@ func (h *LspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		return h.handleTextDocumentDidClose(ctx, conn, req)
 	case "textDocument/hover":
 		return h.handleTextDocumentHover(ctx, conn, req)
+	case "textDocument/codeAction":
+		return h.handleTextDocumentCodeAction(ctx, conn, req)
+	case "workspace/executeCommand":
+		return h.handleWorkspaceExecuteCommand(ctx, conn, req)
 	case "experimental/cursor":
 		return h.handleCursorMove(ctx, conn, req)
 	case "experimental/connectToPeer":
 		return h.handleConnectToPeer(ctx, conn, req)
+	case "experimental/listPeers":
+		return h.handleListPeers(ctx, conn, req)
+	case "experimental/proposal":
+		return h.handleRelayedProposal(ctx, conn, req)
+	case "experimental/proposalResolved":
+		return h.handleRelayedProposalResolved(ctx, conn, req)
+	case "experimental/baseline":
+		return h.handleRelayedBaseline(ctx, conn, req)
+	case "experimental/repo":
+		return h.handleRelayedRepo(ctx, conn, req)
+	case "experimental/fileSharing":
+		return h.handleRelayedSharing(ctx, conn, req)
 	}
 
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
@@ -105,6 +159,7 @@ func (h *LspHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 type TextChange struct {
 	Filename string
 	Text     string
+	Version  int
 }
 
 func debounceChangeText(interval time.Duration, input chan TextChange, cb func(arg TextChange)) {
@@ -134,7 +189,18 @@ func (h *LspHandler) ListenOnStdin(logger *log.Logger, loglevel int, callToken s
 	}
 
 	if h.config.RelayServer != "" {
-		h.forwardChan = make(chan *jsonrpc2.Request)
+		if h.config.E2E {
+			e2e, err := newE2EForwarder(h.logger)
+			if err != nil {
+				h.logger.Fatal("Could not create encryption key:", err)
+			}
+			// Subscribe before any LSP messages so no state changes are missed
+			h.e2e = e2e
+			h.state.Subscribe(e2e.onStateEvent)
+		} else {
+			h.forwardQueue = newForwardQueue(h.logger)
+			h.state.Subscribe(h.forwardEvents)
+		}
 		go h.forward(h.config.ClientAuth)
 	}
 
@@ -191,23 +257,6 @@ func (h *LspHandler) createStaticUrl(offer string) string {
 	return h.config.StaticRTCSite + "?t=" + url.QueryEscape(offer)
 }
 
-func (h *LspHandler) filenameFromURI(uri lsp.DocumentURI) (string, error) {
-	filename, err := util.FromURI(uri)
-	if err != nil {
-		return "", err
-	}
-	if h.rootPath != "" {
-		filename, err := filepath.Rel(h.rootPath, filename)
-		if err != nil {
-			return "", err
-		}
-		if strings.HasPrefix(filename, "..") {
-			return "", errors.New(fmt.Sprintf("File %s is outside root", filename))
-		}
-	}
-	return filename, nil
-}
-
 type pendingNotif struct {
 	method string
 	params interface{}
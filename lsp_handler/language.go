@@ -0,0 +1,202 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"pair-ls/state"
+	"time"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// How long to wait for the editor to answer a hover or definition request
+const languageRequestTimeout = 5 * time.Second
+
+// The editor answers these using its language servers, the same way it would
+// answer textDocument/hover and textDocument/definition
+var languageMethods = map[string]string{
+	state.LanguageHover:      "pairls/hover",
+	state.LanguageDefinition: "pairls/definition",
+}
+
+// go-lsp doesn't handle MarkupContent or LocationLink
+type hoverResult struct {
+	Contents json.RawMessage `json:"contents"`
+	Range    *lsp.Range      `json:"range"`
+}
+
+type markedString struct {
+	Language string `json:"language"`
+	Value    string `json:"value"`
+}
+
+type locationLink struct {
+	TargetURI            lsp.DocumentURI `json:"targetUri"`
+	TargetSelectionRange lsp.Range       `json:"targetSelectionRange"`
+}
+
+// askEditor is the state.LanguageHandler. It asks the editor about a position
+// in a file and converts the answer to the format sent to viewers.
+func (h *LspHandler) askEditor(method string, req state.PositionRequest) (json.RawMessage, error) {
+	h.mu.Lock()
+	uri, ok := h.uris[req.Filename]
+	unsupported := h.languageUnsupported
+	h.mu.Unlock()
+	if unsupported {
+		return nil, errors.New("Editor does not support language requests")
+	}
+	if !ok {
+		return nil, errors.New("File is not open: " + req.Filename)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), languageRequestTimeout)
+	defer cancel()
+	var result json.RawMessage
+	err := h.lspConn.Call(ctx, languageMethods[method], lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		Position:     h.state.ToEditor(req.Filename, lsp.Range{Start: req.Position, End: req.Position}).Start,
+	}, &result)
+	if rpcErr, ok := err.(*jsonrpc2.Error); ok && rpcErr.Code == jsonrpc2.CodeMethodNotFound {
+		// Don't make every viewer wait for a timeout
+		h.mu.Lock()
+		h.languageUnsupported = true
+		h.mu.Unlock()
+		return nil, errors.New("Editor does not support language requests")
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errors.New("Editor did not answer the request")
+	} else if err != nil {
+		return nil, err
+	}
+
+	var converted interface{}
+	switch method {
+	case state.LanguageHover:
+		var hover *state.Hover
+		hover, err = convertHover(result)
+		if hover != nil && hover.Range != nil {
+			rng := h.state.FromEditor(req.Filename, *hover.Range)
+			hover.Range = &rng
+		}
+		converted = hover
+	case state.LanguageDefinition:
+		converted, err = h.convertLocations(result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+// convertHover turns the contents of an LSP hover into markdown
+func convertHover(data json.RawMessage) (*state.Hover, error) {
+	var hover *hoverResult
+	if err := json.Unmarshal(data, &hover); err != nil || hover == nil {
+		return nil, err
+	}
+	var contents []json.RawMessage
+	if err := json.Unmarshal(hover.Contents, &contents); err != nil {
+		contents = []json.RawMessage{hover.Contents}
+	}
+	var markdown string
+	for _, content := range contents {
+		if markdown != "" {
+			markdown += "\n\n"
+		}
+		// A MarkedString can be a plain string
+		var str string
+		if err := json.Unmarshal(content, &str); err == nil {
+			markdown += str
+			continue
+		}
+		// Either a MarkupContent or a MarkedString with a language
+		var fields struct {
+			Kind string `json:"kind"`
+			markedString
+		}
+		if err := json.Unmarshal(content, &fields); err != nil {
+			return nil, err
+		}
+		if fields.Language != "" {
+			markdown += "```" + fields.Language + "\n" + fields.Value + "\n```"
+		} else {
+			markdown += fields.Value
+		}
+	}
+	if markdown == "" {
+		return nil, nil
+	}
+	return &state.Hover{
+		Contents: markdown,
+		Range:    hover.Range,
+	}, nil
+}
+
+// convertLocations turns a Location, a list of Locations, or a list of
+// LocationLinks into shared filenames. Locations outside the workspace are
+// left out.
+func (h *LspHandler) convertLocations(data json.RawMessage) ([]state.Location, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+	locations := make([]state.Location, 0, len(items))
+	for _, item := range items {
+		var location struct {
+			lsp.Location
+			locationLink
+		}
+		if err := json.Unmarshal(item, &location); err != nil {
+			return nil, err
+		}
+		uri, rng := location.URI, location.Range
+		if location.TargetURI != "" {
+			uri, rng = location.TargetURI, location.TargetSelectionRange
+		}
+		if uri == "" {
+			continue
+		}
+		filename, err := h.filenameFromURI(uri)
+		if err != nil {
+			continue
+		}
+		locations = append(locations, state.Location{
+			Filename: filename,
+			Range:    h.state.FromEditor(filename, rng),
+		})
+	}
+	return locations, nil
+}
+
+func (h *LspHandler) handleHover(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.PositionRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	hover, err := h.state.Hover(params)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return hover, nil
+}
+
+func (h *LspHandler) handleDefinition(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.PositionRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	locations, err := h.state.Definition(params)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return locations, nil
+}
@@ -0,0 +1,209 @@
+package lsp_handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/randutil"
+	"github.com/pion/webrtc/v3"
+)
+
+// Peers that haven't connected within this time are closed
+const peerHandshakeTimeout = 5 * time.Minute
+
+// How the WebRTC connection was started
+type PeerSource string
+
+const (
+	// A browser called us through the signal server
+	PeerSourceSignal PeerSource = "signal"
+	// We created an offer for a viewer to answer
+	PeerSourceOffer PeerSource = "offer"
+	// A viewer created an offer and we answered it
+	PeerSourceAnswer PeerSource = "answer"
+)
+
+type peer struct {
+	id      string
+	source  PeerSource
+	conn    *webrtc.PeerConnection
+	created time.Time
+	// The name the viewer gave, if any
+	identity string
+	// Whether the viewer proved that it knows the edit password
+	canEdit bool
+	// Set when the connection is established
+	connected time.Time
+	remote    string
+}
+
+// PeerInfo describes one WebRTC viewer
+type PeerInfo struct {
+	ID     string     `json:"id"`
+	Source PeerSource `json:"source"`
+	State  string     `json:"state"`
+	// The name the viewer gave when connecting. Viewers choose it themselves,
+	// so it isn't proof of who they are.
+	Identity string `json:"identity,omitempty"`
+	CanEdit  bool   `json:"canEdit"`
+	// Address of the viewer, once connected
+	Remote        string     `json:"remote,omitempty"`
+	Created       time.Time  `json:"created"`
+	Connected     *time.Time `json:"connected,omitempty"`
+	BytesSent     uint64     `json:"bytesSent"`
+	BytesReceived uint64     `json:"bytesReceived"`
+}
+
+// peerManager tracks every WebRTC connection to a viewer
+type peerManager struct {
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+func newPeerManager() *peerManager {
+	return &peerManager{
+		peers: make(map[string]*peer),
+	}
+}
+
+// add registers a new connection with a unique ID. If the connection isn't
+// established within peerHandshakeTimeout it is closed.
+func (m *peerManager) add(source PeerSource, identity string, canEdit bool, conn *webrtc.PeerConnection) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		id, err := createClientID()
+		if err != nil {
+			return "", err
+		}
+		if m.peers[id] != nil {
+			continue
+		}
+		m.peers[id] = &peer{
+			id:       id,
+			source:   source,
+			conn:     conn,
+			created:  time.Now(),
+			identity: identity,
+			canEdit:  canEdit,
+		}
+		time.AfterFunc(peerHandshakeTimeout, func() {
+			if !m.hasConnected(id) {
+				conn.Close()
+				m.remove(id)
+			}
+		})
+		return id, nil
+	}
+	return "", errors.New("Could not create a unique peer ID")
+}
+
+func (m *peerManager) get(id string) *webrtc.PeerConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.peers[id]; p != nil {
+		return p.conn
+	}
+	return nil
+}
+
+// setViewer records the viewer's name and edit grant once they answer our
+// offer
+func (m *peerManager) setViewer(id string, identity string, canEdit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.peers[id]; p != nil {
+		p.identity = identity
+		p.canEdit = canEdit
+	}
+}
+
+func (m *peerManager) canEdit(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.peers[id]
+	return p != nil && p.canEdit
+}
+
+func (m *peerManager) hasConnected(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.peers[id]
+	return p != nil && !p.connected.IsZero()
+}
+
+func (m *peerManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers, id)
+}
+
+// setConnected records when and from where a viewer connected
+func (m *peerManager) setConnected(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.peers[id]
+	if p == nil {
+		return
+	}
+	p.connected = time.Now()
+	if sctp := p.conn.SCTP(); sctp != nil {
+		pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+		if err == nil && pair != nil && pair.Remote != nil {
+			p.remote = fmt.Sprintf("%s:%d", pair.Remote.Address, pair.Remote.Port)
+		}
+	}
+}
+
+// closeAll closes every connection
+func (m *peerManager) closeAll() {
+	m.mu.Lock()
+	peers := make([]*peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	m.peers = make(map[string]*peer)
+	m.mu.Unlock()
+	for _, p := range peers {
+		p.conn.Close()
+	}
+}
+
+func (m *peerManager) list() []PeerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]PeerInfo, 0, len(m.peers))
+	for _, p := range m.peers {
+		info := PeerInfo{
+			ID:       p.id,
+			Source:   p.source,
+			State:    p.conn.ConnectionState().String(),
+			Identity: p.identity,
+			CanEdit:  p.canEdit,
+			Remote:   p.remote,
+			Created:  p.created,
+		}
+		if !p.connected.IsZero() {
+			connected := p.connected
+			info.Connected = &connected
+		}
+		for _, stat := range p.conn.GetStats() {
+			if transport, ok := stat.(webrtc.TransportStats); ok {
+				info.BytesSent += transport.BytesSent
+				info.BytesReceived += transport.BytesReceived
+			}
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret
+}
+
+func createClientID() (string, error) {
+	return randutil.GenerateCryptoRandomString(8, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
@@ -0,0 +1,112 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pair-ls/state"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Commands used by the code actions for proposals. They take the proposal ID
+// as the only argument.
+const (
+	// Apply the proposal with workspace/applyEdit
+	applyProposalCommand = "pairls.applyProposal"
+	// The editor already applied the proposal
+	acceptProposalCommand  = "pairls.acceptProposal"
+	dismissProposalCommand = "pairls.dismissProposal"
+)
+
+func (h *LspHandler) handleProposeEdit(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.ProposeEditRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	proposal, err := h.state.ProposeEdit(params)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return proposal, nil
+}
+
+// applyProposal asks the editor to apply a proposal and tells the viewers
+// whether it worked
+func (h *LspHandler) applyProposal(id int32) {
+	proposal, err := h.state.StartApplyProposal(id)
+	if err != nil {
+		h.showMessage(err.Error(), lsp.MTError)
+		return
+	}
+	h.mu.Lock()
+	uri, ok := h.uris[proposal.Filename]
+	h.mu.Unlock()
+	if !ok {
+		h.state.FinishApplyProposal(id, false)
+		return
+	}
+	params := ApplyWorkspaceEditParams{
+		Label: "pair-ls",
+		Edit: h.workspaceEdit(uri, proposal.Version, []lsp.TextEdit{{
+			Range:   h.state.ToEditor(proposal.Filename, proposal.Range),
+			NewText: proposal.NewText,
+		}}),
+	}
+	var result ApplyWorkspaceEditResult
+	if err := h.lspConn.Call(context.Background(), "workspace/applyEdit", params, &result); err != nil {
+		h.logger.Println("Error applying proposal", err)
+	} else if !result.Applied {
+		h.showMessage(fmt.Sprintf("Could not apply suggested edit: %s", result.FailureReason), lsp.MTWarning)
+	}
+	h.state.FinishApplyProposal(id, result.Applied)
+}
+
+// forwardEvents sends the state that doesn't come from LSP messages (proposals
+// and the git repository) to the relay server, which keeps a copy for its
+// viewers. It is called with the state locked.
+func (h *LspHandler) forwardEvents(value interface{}) {
+	var method string
+	switch value.(type) {
+	case state.ProposalEvent:
+		method = "experimental/proposal"
+	case state.ResolveProposalEvent:
+		method = "experimental/proposalResolved"
+	case state.RepoEvent:
+		method = "experimental/repo"
+	default:
+		return
+	}
+	h.forwardNotification(method, value)
+}
+
+func (h *LspHandler) handleRelayedProposal(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.ProposalEvent
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.state.AddProposal(params.Proposal)
+	return nil, nil
+}
+
+func (h *LspHandler) handleRelayedProposalResolved(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.ResolveProposalEvent
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.state.ResolveProposal(params.ID, params.Status)
+	return nil, nil
+}
@@ -0,0 +1,58 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"pair-ls/state"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+const (
+	// How often to check the repository for a new branch, commit, or changes
+	minRepoInterval = 2 * time.Second
+	// Big repositories take a while to check, so they're checked less often.
+	// Checking takes at most 1/repoIntervalFactor of the time.
+	repoIntervalFactor = 20
+)
+
+// watchRepo keeps the viewers' copy of the git status up to date until stop
+// is closed
+func (h *LspHandler) watchRepo(stop <-chan struct{}) {
+	for {
+		start := time.Now()
+		status, err := h.repo.Status()
+		if err != nil {
+			h.logger.Println("Error reading git repository", err)
+		} else {
+			h.state.SetRepo(&state.Repo{
+				Branch: status.Branch,
+				Commit: status.Commit,
+				Dirty:  status.Dirty,
+			})
+		}
+		interval := time.Since(start) * repoIntervalFactor
+		if interval < minRepoInterval {
+			interval = minRepoInterval
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *LspHandler) handleRelayedRepo(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params state.RepoEvent
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.state.SetRepo(params.Repo)
+	return nil, nil
+}
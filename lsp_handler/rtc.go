@@ -6,48 +6,89 @@ import (
 	"encoding/json"
 	"errors"
 	"pair-ls/server"
+	"pair-ls/util"
 
 	"github.com/pion/webrtc/v3"
 	"github.com/sourcegraph/go-lsp"
 	"github.com/sourcegraph/jsonrpc2"
 )
 
-func (h *LspHandler) connectToPeer(offer webrtc.SessionDescription) (*webrtc.PeerConnection, *webrtc.SessionDescription, error) {
-	config := webrtc.Configuration{}
-	peerConnection, err := h.rtc.NewPeerConnection(config)
+func (h *LspHandler) rtcConfiguration() webrtc.Configuration {
+	return webrtc.Configuration{
+		ICEServers: server.ICEServers(h.config.ICEServers),
+	}
+}
+
+// connectToPeer answers an offer and returns the ID of the new peer. identity
+// is whatever name the viewer gave, and editProof is checked against the edit
+// password. If
+// onCandidate is nil, the answer will include the local ICE candidates.
+// Otherwise the answer is returned immediately and onCandidate is called with
+// each candidate as it is gathered (and nil when gathering is done).
+func (h *LspHandler) connectToPeer(source PeerSource, identity string, editProof string, offer webrtc.SessionDescription, onCandidate func(id string, c *webrtc.ICECandidate)) (string, *webrtc.SessionDescription, error) {
+	peerConnection, err := h.rtc.NewPeerConnection(h.rtcConfiguration())
 	if err != nil {
-		return nil, nil, err
+		return "", nil, err
 	}
-	peerConnection.SetRemoteDescription(offer)
-	answer, err := peerConnection.CreateAnswer(nil)
+	id, err := h.peers.add(source, identity, h.checkEditProof(offer, editProof), peerConnection)
 	if err != nil {
-		return nil, nil, err
+		peerConnection.Close()
+		return "", nil, err
 	}
-	err = peerConnection.SetLocalDescription(answer)
+	fail := func(err error) (string, *webrtc.SessionDescription, error) {
+		peerConnection.Close()
+		h.peers.remove(id)
+		return "", nil, err
+	}
+	var gathered <-chan struct{}
+	if onCandidate == nil {
+		gathered = util.GatherCandidates(peerConnection)
+	} else {
+		peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
+			onCandidate(id, c)
+		})
+	}
+	h.runPeerConnection(peerConnection, id)
+	if err = peerConnection.SetRemoteDescription(offer); err != nil {
+		return fail(err)
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		return nil, nil, err
+		return fail(err)
+	}
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return fail(err)
+	}
+	if gathered != nil {
+		<-gathered
+		return id, peerConnection.LocalDescription(), nil
 	}
 
-	return peerConnection, &answer, nil
+	return id, &answer, nil
+}
+
+// checkEditProof decides whether a WebRTC viewer may edit, given its session
+// description and the edit proof it sent
+func (h *LspHandler) checkEditProof(desc webrtc.SessionDescription, proof string) bool {
+	return h.config.Collaborate && util.CheckEditProof(h.config.EditPassword, desc.SDP, proof)
 }
 
 func (h *LspHandler) respondRTCPeer(callToken string) (string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(callToken)
+	data, compact, err := util.DecodePeerToken(callToken)
 	if err != nil {
 		return "", err
 	}
-	var data PeerToken
-	if err := json.Unmarshal(decoded, &data); err != nil {
-		return "", err
-	}
 
 	if data.Desc.Type == webrtc.SDPTypeOffer {
 		// If we were given an offer, create and respond with an answer
-		peerConnection, answer, err := h.connectToPeer(*data.Desc)
+		_, answer, err := h.connectToPeer(PeerSourceAnswer, data.Name, data.EditProof, *data.Desc, nil)
 		if err != nil {
 			return "", err
 		}
-		h.runPeerConnection(peerConnection, func() {})
+		if compact {
+			return util.EncodePeerToken(util.PeerToken{Desc: answer}, true)
+		}
+		// The static site expects a bare session description
 		json, err := json.Marshal(answer)
 		if err != nil {
 			return "", err
@@ -55,80 +96,54 @@ func (h *LspHandler) respondRTCPeer(callToken string) (string, error) {
 
 		return base64.StdEncoding.EncodeToString(json), nil
 	} else {
-		// If we were given an offer, find the corresponding offer connection and
+		// If we were given an answer, find the corresponding offer connection and
 		// complete it
-		peerConnection := h.getConn(data.ClientID)
+		peerConnection := h.peers.get(data.ClientID)
 		if peerConnection == nil {
 			return "", errors.New("No matching connection found")
 		}
-		peerConnection.SetRemoteDescription(*data.Desc)
-		return "", nil
+		h.peers.setViewer(data.ClientID, data.Name, h.checkEditProof(*data.Desc, data.EditProof))
+		return "", peerConnection.SetRemoteDescription(*data.Desc)
 	}
 }
 
 func (h *LspHandler) callRTCPeer() (*webrtc.SessionDescription, string, error) {
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{
-					"stun:openrelay.metered.ca:80",
-					"stun:stun.l.google.com:19302",
-					"stun:stun1.l.google.com:19302",
-					"stun:stun2.l.google.com:19302",
-					"stun:stun3.l.google.com:19302",
-					"stun:stun4.l.google.com:19302",
-				},
-			},
-			{
-				URLs: []string{
-					"turn:openrelay.metered.ca:80",
-					"turn:openrelay.metered.ca:443",
-					"turn:openrelay.metered.ca:443?transport=tcp",
-					"turns:openrelay.metered.ca:443",
-				},
-				Username:   "openrelayproject",
-				Credential: "openrelayproject",
-			},
-		},
-	}
-	clientID, err := createClientID()
+	peerConnection, err := h.rtc.NewPeerConnection(h.rtcConfiguration())
 	if err != nil {
 		return nil, "", err
 	}
-	peerConnection, err := h.rtc.NewPeerConnection(config)
+	clientID, err := h.peers.add(PeerSourceOffer, "", false, peerConnection)
 	if err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	fail := func(err error) (*webrtc.SessionDescription, string, error) {
+		peerConnection.Close()
+		h.peers.remove(clientID)
 		return nil, "", err
 	}
+	h.runPeerConnection(peerConnection, clientID)
 
-	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		if s == webrtc.PeerConnectionStateFailed {
-			h.showMessage("Failed to connect to peer", lsp.MTError)
-		}
-	})
 	ordered := true
 	_, err = peerConnection.CreateDataChannel("messaging-channel", &webrtc.DataChannelInit{Ordered: &ordered})
 	if err != nil {
-		return nil, "", err
+		return fail(err)
 	}
+	gathered := util.GatherCandidates(peerConnection)
 	offer, err := peerConnection.CreateOffer(&webrtc.OfferOptions{})
 	if err != nil {
-		return nil, "", err
+		return fail(err)
 	}
 	err = peerConnection.SetLocalDescription(offer)
 	if err != nil {
-		return nil, "", err
+		return fail(err)
 	}
-	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-	<-gatherComplete
+	<-gathered
 
-	h.setConn(clientID, peerConnection)
-	h.runPeerConnection(peerConnection, func() {
-		h.delConn(clientID)
-	})
 	return peerConnection.LocalDescription(), clientID, nil
 }
 
-func (h *LspHandler) runPeerConnection(peerConnection *webrtc.PeerConnection, closeCallback func()) {
+func (h *LspHandler) runPeerConnection(peerConnection *webrtc.PeerConnection, id string) {
 	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
 		dc.OnOpen(func() {
 			raw, err := dc.Detach()
@@ -141,13 +156,18 @@ func (h *LspHandler) runPeerConnection(peerConnection *webrtc.PeerConnection, cl
 			conn := jsonrpc2.NewConn(
 				context.Background(),
 				jsonrpc2.NewBufferedStream(raw, jsonrpc2.PlainObjectCodec{}),
-				jsonrpc2.HandlerWithError(h.handlePeerRPC),
+				jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+					return h.handlePeerRPC(ctx, conn, req, id)
+				}),
 			)
 			defer h.logger.Println("Closing peer connection")
 			defer peerConnection.Close()
 			conn.Notify(context.Background(), "initialize", server.InitializeClient{
-				View:  h.state.GetView(),
-				Files: h.state.GetFiles(),
+				View:      h.state.GetView(),
+				Files:     h.state.GetFiles(),
+				Proposals: h.state.GetProposals(""),
+				Repo:      h.state.GetRepo(),
+				Folders:   h.state.GetFolders(),
 			})
 			var forward = server.GetForwardStateChangesCallback(h.logger, conn)
 			h.state.Subscribe(forward)
@@ -163,14 +183,16 @@ func (h *LspHandler) runPeerConnection(peerConnection *webrtc.PeerConnection, cl
 		})
 	})
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		h.logger.Printf("Peer Connection State has changed: %s\n", state.String())
+		h.logger.Printf("Peer %s connection state has changed: %s\n", id, state.String())
 		switch state {
 		case webrtc.PeerConnectionStateConnected:
+			h.peers.setConnected(id)
 			h.showMessage("PairLS: Connected to peer", lsp.Info)
 		case webrtc.PeerConnectionStateFailed:
+			h.showMessage("PairLS: Failed to connect to peer", lsp.MTError)
 			peerConnection.Close()
 		case webrtc.PeerConnectionStateClosed:
-			closeCallback()
+			h.peers.remove(id)
 			h.showMessage("PairLS: Peer connection closed", lsp.Info)
 		}
 	})
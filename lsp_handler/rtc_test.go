@@ -0,0 +1,86 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"pair-ls/state"
+	"pair-ls/util"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+func newViewerOffer(t *testing.T) (*webrtc.PeerConnection, webrtc.SessionDescription) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.CreateDataChannel("messaging-channel", nil); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	return pc, *pc.LocalDescription()
+}
+
+func TestPeerEditGrant(t *testing.T) {
+	tests := []struct {
+		name         string
+		collaborate  bool
+		editPassword string
+		// The password the viewer makes its proof with
+		password string
+		// Make the proof for a different session description
+		otherSession bool
+		canEdit      bool
+	}{
+		{name: "edit password", collaborate: true, editPassword: "edit", password: "edit", canEdit: true},
+		{name: "wrong password", collaborate: true, editPassword: "edit", password: "view"},
+		{name: "no proof", collaborate: true, editPassword: "edit"},
+		{name: "no edit password", collaborate: true, password: "edit"},
+		{name: "not collaborating", editPassword: "edit", password: "edit"},
+		{name: "proof for another session", collaborate: true, editPassword: "edit", password: "edit", otherSession: true},
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	for _, test := range tests {
+		h := NewHandler(state.NewState(logger), logger, &HandlerConfig{
+			Collaborate:  test.collaborate,
+			EditPassword: test.editPassword,
+		})
+		viewer, offer := newViewerOffer(t)
+		defer viewer.Close()
+		proof := util.EditProof(test.password, offer.SDP)
+		if test.otherSession {
+			other, otherOffer := newViewerOffer(t)
+			defer other.Close()
+			proof = util.EditProof(test.password, otherOffer.SDP)
+		}
+
+		id, _, err := h.connectToPeer(PeerSourceAnswer, "viewer", proof, offer, func(string, *webrtc.ICECandidate) {})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if h.peers.canEdit(id) != test.canEdit {
+			t.Errorf("%s: got canEdit %v, want %v", test.name, !test.canEdit, test.canEdit)
+		}
+
+		params := json.RawMessage(`{"filename":"main.go","version":1,"edits":[]}`)
+		_, err = h.handlePeerRPC(context.Background(), nil, &jsonrpc2.Request{Method: "submitEdit", Params: &params}, id)
+		rejected := false
+		if rpcErr, ok := err.(*jsonrpc2.Error); ok && rpcErr.Message == "Not allowed to edit" {
+			rejected = true
+		}
+		if rejected == test.canEdit {
+			t.Errorf("%s: submitEdit returned %v", test.name, err)
+		}
+		h.peers.closeAll()
+	}
+}
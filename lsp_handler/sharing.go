@@ -0,0 +1,93 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"pair-ls/util"
+	"path/filepath"
+	"strings"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Values for HandlerConfig.OutsideRoot
+const (
+	// Don't share files outside the workspace
+	OutsideRootIgnore = "ignore"
+	// Share them under OutsideRootPrefix, by their full path
+	OutsideRootShare = "share"
+)
+
+// Files outside the workspace are shared with this in front of their path
+const OutsideRootPrefix = "(external)"
+
+// FileSharing decides which files are shared and what they're called. It's
+// sent to the relay server, which names files itself from the LSP messages.
+type FileSharing struct {
+	// OutsideRootIgnore or OutsideRootShare
+	OutsideRoot string `json:"outsideRoot"`
+	// URI schemes to share. Every scheme is shared if empty.
+	Schemes []string `json:"schemes,omitempty"`
+}
+
+func (s FileSharing) sharesScheme(scheme string) bool {
+	return len(s.Schemes) == 0 || util.ContainsStr(s.Schemes, scheme)
+}
+
+// filenameFromURI is the name a document is shared under. Files are named by
+// their path (see filenameFromPath), and documents that aren't files (e.g.
+// untitled buffers, or jdt:// class files) by their URI.
+func (h *LspHandler) filenameFromURI(uri lsp.DocumentURI) (string, error) {
+	u, err := url.Parse(string(uri))
+	if err != nil {
+		return "", err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.sharing.sharesScheme(u.Scheme) {
+		return "", fmt.Errorf("Not sharing %s: URIs", u.Scheme)
+	}
+	if u.Scheme != "file" {
+		return string(uri), nil
+	}
+	path, err := util.FromURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return h.filenameFromPath(filepath.Clean(path))
+}
+
+// outsideRoot is the name of a file outside the workspace, or an error if
+// those aren't shared. Must be called with h.mu held.
+func (h *LspHandler) outsideRoot(path string, err error) (string, error) {
+	if h.sharing.OutsideRoot != OutsideRootShare {
+		return "", err
+	}
+	return filepath.Join(OutsideRootPrefix, strings.TrimPrefix(path, string(filepath.Separator))), nil
+}
+
+// forwardSharing tells the relay server how to name files
+func (h *LspHandler) forwardSharing() {
+	h.mu.Lock()
+	sharing := h.sharing
+	h.mu.Unlock()
+	h.forwardNotification("experimental/fileSharing", sharing)
+}
+
+func (h *LspHandler) handleRelayedSharing(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params FileSharing
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.sharing = params
+	h.mu.Unlock()
+	return nil, nil
+}
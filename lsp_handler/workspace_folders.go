@@ -0,0 +1,200 @@
+package lsp_handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pair-ls/util"
+	"path/filepath"
+	"strings"
+
+	"github.com/sourcegraph/go-lsp"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// WorkspaceFolder is the LSP 3.6 workspace folder, which go-lsp doesn't have
+type WorkspaceFolder struct {
+	URI  lsp.DocumentURI `json:"uri"`
+	Name string          `json:"name"`
+}
+
+type DidChangeWorkspaceFoldersParams struct {
+	Event struct {
+		Added   []WorkspaceFolder `json:"added"`
+		Removed []WorkspaceFolder `json:"removed"`
+	} `json:"event"`
+}
+
+// A workspace folder, and the name its files are shared under
+type workspaceFolder struct {
+	name string
+	path string
+}
+
+// addFolders adds workspace folders, giving each a unique name. Folders that
+// are already in the workspace are skipped. Must be called with h.mu held.
+func (h *LspHandler) addFolders(folders []WorkspaceFolder) {
+	for _, folder := range folders {
+		path, err := util.FromURI(folder.URI)
+		if err != nil {
+			h.logger.Println("Ignoring workspace folder", folder.URI, err)
+			continue
+		}
+		path = filepath.Clean(path)
+		if h.folderIndex(path) >= 0 {
+			continue
+		}
+		// Names are the first part of filenames, so they can't contain slashes
+		base := strings.NewReplacer("/", "-", "\\", "-").Replace(folder.Name)
+		if base == "" || base == "." || base == ".." {
+			base = filepath.Base(path)
+		}
+		name := base
+		for i := 2; h.folderNamed(name); i++ {
+			name = fmt.Sprintf("%s (%d)", base, i)
+		}
+		h.folders = append(h.folders, workspaceFolder{name: name, path: path})
+	}
+}
+
+// removeFolders must be called with h.mu held
+func (h *LspHandler) removeFolders(folders []WorkspaceFolder) {
+	for _, folder := range folders {
+		path, err := util.FromURI(folder.URI)
+		if err != nil {
+			continue
+		}
+		if i := h.folderIndex(filepath.Clean(path)); i >= 0 {
+			h.folders = append(h.folders[:i], h.folders[i+1:]...)
+		}
+	}
+}
+
+func (h *LspHandler) folderIndex(path string) int {
+	for i, folder := range h.folders {
+		if folder.path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *LspHandler) folderNamed(name string) bool {
+	for _, folder := range h.folders {
+		if folder.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// folderNames are the names shown to viewers. A workspace with a single folder
+// isn't split into folders.
+func (h *LspHandler) folderNames() []string {
+	if len(h.folders) < 2 {
+		return nil
+	}
+	names := make([]string, 0, len(h.folders))
+	for _, folder := range h.folders {
+		names = append(names, folder.name)
+	}
+	return names
+}
+
+// filenameFromPath is the name a file is shared under. In a multi-root
+// workspace it's relative to the innermost folder containing it, prefixed
+// with the folder's name. Must be called with h.mu held.
+func (h *LspHandler) filenameFromPath(path string) (string, error) {
+	if len(h.folders) > 1 {
+		var folder *workspaceFolder
+		filename := ""
+		for i := range h.folders {
+			rel, ok := relativeTo(h.folders[i].path, path)
+			if ok && (folder == nil || len(h.folders[i].path) > len(folder.path)) {
+				folder = &h.folders[i]
+				filename = filepath.Join(folder.name, rel)
+			}
+		}
+		if folder == nil {
+			return h.outsideRoot(path, fmt.Errorf("File %s is outside the workspace folders", path))
+		}
+		return filename, nil
+	}
+	root := h.rootPath
+	if len(h.folders) == 1 {
+		root = h.folders[0].path
+	}
+	if root == "" {
+		return path, nil
+	}
+	rel, ok := relativeTo(root, path)
+	if !ok {
+		return h.outsideRoot(path, fmt.Errorf("File %s is outside root", path))
+	}
+	return rel, nil
+}
+
+// relativeTo returns path relative to root, or false if it isn't inside root
+func relativeTo(root string, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (h *LspHandler) handleDidChangeWorkspaceFolders(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	}
+
+	var params DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+
+	type moved struct {
+		from string
+		to   string
+		path string
+	}
+	var renames []moved
+	h.mu.Lock()
+	h.removeFolders(params.Event.Removed)
+	h.addFolders(params.Event.Added)
+	// Files may now be outside the workspace, or have a different name if
+	// the workspace gained or lost its second folder
+	uris := make(map[string]lsp.DocumentURI, len(h.uris))
+	for filename, uri := range h.uris {
+		// Documents that aren't files are named by their URI, which doesn't
+		// change
+		to := filename
+		path, err := util.FromURI(uri)
+		if err == nil {
+			path = filepath.Clean(path)
+			to, _ = h.filenameFromPath(path)
+		}
+		if to != "" {
+			uris[to] = uri
+		}
+		if to != filename {
+			renames = append(renames, moved{from: filename, to: to, path: path})
+		}
+	}
+	h.uris = uris
+	folders := h.folderNames()
+	h.mu.Unlock()
+
+	h.state.SetFolders(folders)
+	for _, rename := range renames {
+		file := h.state.GetFile(rename.from)
+		h.state.CloseFile(rename.from)
+		if rename.to != "" {
+			if h.repo != nil && !h.state.HasBaseline(rename.to) {
+				h.setGitBaseline(rename.to, rename.path)
+			}
+			h.state.OpenFile(rename.to, strings.Join(file.Lines, "\n"), file.Language, file.Version, false)
+		}
+	}
+	return nil, nil
+}
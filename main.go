@@ -12,6 +12,7 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 func main() {
@@ -34,12 +35,17 @@ func main() {
 	if err != nil {
 		log.Panicln("Could not read config file", err)
 	}
+	config.configFile = confFile
 
 	flag.StringVar(&config.LogFile, "logfile", config.LogFile, "Logs will be written here")
 	flag.IntVar(&config.LogLevel, "loglevel", config.LogLevel, "Log detail")
 	command.On("lsp", "Run the LSP server", NewLSPCmd(config), []string{})
 	command.On("relay", "Run a relay server", NewRelayCmd(config), []string{"port"})
 	command.On("signal", "Run a signal server for making WebRTC connections", NewSignalCmd(config), []string{"port"})
+	command.On("view", "Follow a shared editor from the terminal", NewViewCmd(config), []string{})
+	command.On("mirror", "Write the files shared by an editor to a directory", NewMirrorCmd(config), []string{})
+	command.On("follow", "Run an LSP server that follows a shared editor", NewFollowCmd(config), []string{})
+	command.On("join", "Connect to an editor over WebRTC from the terminal", NewJoinCmd(config), []string{})
 	command.On("cert", "Generate certificates for relay server", NewCertCmd(config), []string{})
 	command.ParseAndRun()
 }
@@ -64,6 +70,8 @@ func readConfig(filename string) (*PairConfig, error) {
 		LogFile:       filepath.Join(cache_dir, "pair-ls.log"),
 		LogLevel:      1,
 		StaticRTCSite: "https://code.stevearc.com/",
+		FocusRequests: lsp_handler.FocusRequestsPrompt,
+		OutsideRoot:   lsp_handler.OutsideRootIgnore,
 	}
 	content, err := ioutil.ReadFile(filename)
 	if err == nil {
@@ -76,6 +84,10 @@ func readConfig(filename string) (*PairConfig, error) {
 	if webPass != "" {
 		config.Server.WebPassword = webPass
 	}
+	editPass := os.Getenv("PAIR_EDIT_PASS")
+	if editPass != "" {
+		config.Server.EditPassword = editPass
+	}
 
 	return &config, nil
 }
@@ -86,6 +98,17 @@ type PairConfig struct {
 	Server        server.WebServerConfig       `json:"server"`
 	Client        lsp_handler.ClientAuthConfig `json:"client"`
 	RelayPersist  bool                         `json:"relayPersist"`
+	RelayE2E      bool                         `json:"relayE2E"`
 	CallToken     string                       `json:"callToken"`
 	StaticRTCSite string                       `json:"staticRTCSite"`
+	ManualRTC     bool                         `json:"manualRTC"`
+	ICEServers    []server.ICEServerConfig     `json:"iceServers"`
+	Collaborate   bool                         `json:"collaborate"`
+	FocusRequests string                       `json:"focusRequests"`
+	OutsideRoot   string                       `json:"outsideRoot"`
+	ShareSchemes  []string                     `json:"shareSchemes"`
+
+	configFile string
+	// Guards Server, which is replaced when the config is reloaded
+	mu sync.Mutex
 }
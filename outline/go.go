@@ -0,0 +1,95 @@
+package outline
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// parseGo finds the declarations in a Go file. Methods are nested under their
+// receiver type if it's declared in the same file.
+func parseGo(lines []string) ([]Symbol, bool) {
+	fset := token.NewFileSet()
+	// The file may be in the middle of an edit, so use whatever parsed
+	f, _ := parser.ParseFile(fset, "", strings.Join(lines, "\n"), parser.SkipObjectResolution)
+	if f == nil || len(f.Decls) == 0 {
+		return nil, false
+	}
+	line := func(pos token.Pos) int {
+		return fset.Position(pos).Line - 1
+	}
+
+	symbols := make([]Symbol, 0, len(f.Decls))
+	types := make(map[string]int)
+	var methods []ast.Node
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil {
+				methods = append(methods, decl)
+				continue
+			}
+			symbols = append(symbols, Symbol{Name: decl.Name.Name, Kind: KindFunction, Line: line(decl.Name.Pos())})
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					types[spec.Name.Name] = len(symbols)
+					symbols = append(symbols, Symbol{Name: spec.Name.Name, Kind: KindType, Line: line(spec.Name.Pos())})
+				case *ast.ValueSpec:
+					kind := KindVariable
+					if decl.Tok == token.CONST {
+						kind = KindConstant
+					}
+					for _, name := range spec.Names {
+						if name.Name == "_" {
+							continue
+						}
+						symbols = append(symbols, Symbol{Name: name.Name, Kind: kind, Line: line(name.Pos())})
+					}
+				}
+			}
+		}
+	}
+	for _, node := range methods {
+		decl := node.(*ast.FuncDecl)
+		recv := receiverType(decl)
+		method := Symbol{Name: decl.Name.Name, Kind: KindMethod, Line: line(decl.Name.Pos())}
+		if i, ok := types[recv]; ok {
+			symbols[i].Children = append(symbols[i].Children, method)
+		} else {
+			if recv != "" {
+				method.Name = recv + "." + method.Name
+			}
+			symbols = append(symbols, method)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return symbols[i].Line < symbols[j].Line
+	})
+	return symbols, true
+}
+
+// receiverType is the name of the type of a method's receiver
+func receiverType(decl *ast.FuncDecl) string {
+	if len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
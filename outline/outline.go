@@ -0,0 +1,213 @@
+package outline
+
+import (
+	"strings"
+)
+
+// Kinds of symbols
+const (
+	KindFunction = "function"
+	KindMethod   = "method"
+	KindClass    = "class"
+	KindType     = "type"
+	KindModule   = "module"
+	KindVariable = "variable"
+	KindConstant = "constant"
+	KindHeading  = "heading"
+)
+
+// Symbol is an entry in the outline of a file
+type Symbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// 0-indexed line of the symbol
+	Line     int      `json:"line"`
+	Children []Symbol `json:"children,omitempty"`
+}
+
+// Outline keeps track of the symbols in a file as it changes. Symbols found a
+// line at a time are updated incrementally, and files that need a full parse
+// (Go) are parsed when the outline is requested.
+type Outline struct {
+	language string
+	patterns []pattern
+	// The symbol on each line of the file, if any
+	lines []*lineSymbol
+	// Lines that have changed since they were last matched
+	dirty map[int]bool
+	// nil when the file has changed
+	symbols []Symbol
+}
+
+type lineSymbol struct {
+	name  string
+	kind  string
+	level int
+	// Markdown code fences, which hide the headings inside them
+	fence bool
+}
+
+func New(language string, lines []string) *Outline {
+	o := &Outline{
+		language: language,
+		patterns: patternsFor(language),
+	}
+	o.reset(len(lines))
+	return o
+}
+
+func (o *Outline) reset(numLines int) {
+	o.lines = make([]*lineSymbol, numLines)
+	o.dirty = make(map[int]bool, numLines)
+	for i := 0; i < numLines; i++ {
+		o.dirty[i] = true
+	}
+	o.symbols = nil
+}
+
+// Replace is called when the whole file is replaced
+func (o *Outline) Replace(numLines int) {
+	o.reset(numLines)
+}
+
+// Splice is called when lines startLine through endLine (inclusive) are
+// replaced with numLines new lines
+func (o *Outline) Splice(startLine int, endLine int, numLines int) {
+	if startLine > len(o.lines) || endLine < startLine {
+		o.reset(len(o.lines))
+		return
+	}
+	if endLine >= len(o.lines) {
+		endLine = len(o.lines) - 1
+	}
+	newLines := make([]*lineSymbol, 0, len(o.lines)-(endLine-startLine+1)+numLines)
+	newLines = append(newLines, o.lines[:startLine]...)
+	newLines = append(newLines, make([]*lineSymbol, numLines)...)
+	newLines = append(newLines, o.lines[endLine+1:]...)
+	// Move the dirty lines after the change
+	shift := numLines - (endLine - startLine + 1)
+	dirty := make(map[int]bool, len(o.dirty)+numLines)
+	for line := range o.dirty {
+		if line < startLine {
+			dirty[line] = true
+		} else if line > endLine {
+			dirty[line+shift] = true
+		}
+	}
+	for i := 0; i < numLines; i++ {
+		dirty[startLine+i] = true
+	}
+	o.lines = newLines
+	o.dirty = dirty
+	o.symbols = nil
+}
+
+// Symbols returns the outline of the file, given its current text
+func (o *Outline) Symbols(lines []string) []Symbol {
+	if len(lines) != len(o.lines) {
+		o.reset(len(lines))
+	}
+	if o.symbols != nil {
+		return o.symbols
+	}
+	for line := range o.dirty {
+		o.lines[line] = matchLine(o.patterns, lines[line])
+	}
+	o.dirty = make(map[int]bool)
+	if o.language == "go" {
+		if symbols, ok := parseGo(lines); ok {
+			o.symbols = symbols
+			return symbols
+		}
+	}
+	o.symbols = o.buildTree()
+	return o.symbols
+}
+
+// buildTree nests the symbols on each line by their level (indentation, or
+// heading level)
+func (o *Outline) buildTree() []Symbol {
+	type entry struct {
+		symbol Symbol
+		level  int
+	}
+	root := make([]Symbol, 0)
+	var stack []entry
+	// Pops the top of the stack into its parent
+	pop := func() {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if len(stack) == 0 {
+			root = append(root, top.symbol)
+		} else {
+			parent := &stack[len(stack)-1].symbol
+			parent.Children = append(parent.Children, top.symbol)
+		}
+	}
+	inFence := false
+	for i, line := range o.lines {
+		if line == nil {
+			continue
+		}
+		if line.fence {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= line.level {
+			pop()
+		}
+		stack = append(stack, entry{
+			symbol: Symbol{Name: line.name, Kind: line.kind, Line: i},
+			level:  line.level,
+		})
+	}
+	for len(stack) > 0 {
+		pop()
+	}
+	return root
+}
+
+// indentation is the width of the leading whitespace of a line
+func indentation(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+func matchLine(patterns []pattern, line string) *lineSymbol {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return nil
+	}
+	for _, p := range patterns {
+		match := p.re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if p.fence {
+			return &lineSymbol{fence: true}
+		}
+		symbol := &lineSymbol{
+			name:  match[1],
+			kind:  p.kind,
+			level: indentation(line),
+		}
+		if p.level != nil {
+			symbol.level = p.level(match)
+		}
+		return symbol
+	}
+	return nil
+}
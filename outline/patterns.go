@@ -0,0 +1,129 @@
+package outline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pattern finds a symbol on a single line. The first submatch is the name.
+type pattern struct {
+	re   *regexp.Regexp
+	kind string
+	// Overrides the indentation as the nesting level
+	level func(match []string) int
+	fence bool
+}
+
+func p(expr string, kind string) pattern {
+	return pattern{re: regexp.MustCompile(expr), kind: kind}
+}
+
+var languagePatterns = map[string][]pattern{
+	// Only used when the file doesn't parse
+	"go": {
+		p(`^func\s+(?:\([^)]*\)\s*)?(\w+)`, KindFunction),
+		p(`^type\s+(\w+)`, KindType),
+	},
+	"python": {
+		p(`^\s*(?:async\s+)?def\s+(\w+)`, KindFunction),
+		p(`^\s*class\s+(\w+)`, KindClass),
+	},
+	"javascript": jsPatterns,
+	"typescript": append([]pattern{
+		p(`^\s*(?:export\s+)?(?:declare\s+)?interface\s+([\w$]+)`, KindType),
+		p(`^\s*(?:export\s+)?(?:declare\s+)?type\s+([\w$]+)\s*(?:<[^=]*>)?\s*=`, KindType),
+		p(`^\s*(?:export\s+)?(?:declare\s+)?(?:const\s+)?enum\s+([\w$]+)`, KindType),
+		p(`^\s*(?:export\s+)?(?:declare\s+)?namespace\s+([\w$.]+)`, KindModule),
+	}, jsPatterns...),
+	"rust": {
+		p(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"[^"]*"\s+)?fn\s+(\w+)`, KindFunction),
+		p(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|union|trait|type)\s+(\w+)`, KindType),
+		p(`^\s*impl(?:<[^>]*>)?\s+(.+?)\s*\{?\s*$`, KindClass),
+		p(`^\s*(?:pub(?:\([^)]*\))?\s+)?mod\s+(\w+)`, KindModule),
+	},
+	"ruby": {
+		p(`^\s*def\s+((?:self\.)?[\w?!=]+)`, KindFunction),
+		p(`^\s*class\s+([\w:]+)`, KindClass),
+		p(`^\s*module\s+([\w:]+)`, KindModule),
+	},
+	"lua": {
+		p(`^\s*(?:local\s+)?function\s+([\w.:]+)`, KindFunction),
+		p(`^\s*(?:local\s+)?([\w.]+)\s*=\s*function\b`, KindFunction),
+	},
+	"java": {
+		p(`^\s*(?:(?:public|protected|private|abstract|static|final|sealed)\s+)*(?:class|interface|enum|record)\s+(\w+)`, KindClass),
+		p(`^\s*(?:(?:public|protected|private|abstract|static|final|synchronized|native)\s+)+[\w<>\[\], ]+\s+(\w+)\s*\(`, KindMethod),
+	},
+	"csharp": {
+		p(`^\s*(?:(?:public|protected|private|internal|abstract|static|sealed|partial)\s+)*(?:class|interface|struct|enum|record)\s+(\w+)`, KindClass),
+		p(`^\s*(?:(?:public|protected|private|internal|abstract|static|virtual|override|async)\s+)+[\w<>\[\], ?]+\s+(\w+)\s*\(`, KindMethod),
+		p(`^\s*namespace\s+([\w.]+)`, KindModule),
+	},
+	"c": cPatterns,
+	"cpp": append([]pattern{
+		p(`^\s*(?:template\s*<[^>]*>\s*)?(?:class|struct)\s+(\w+)[^;]*$`, KindClass),
+		p(`^\s*namespace\s+(\w+)`, KindModule),
+	}, cPatterns...),
+	"php": {
+		p(`^\s*(?:(?:public|protected|private|abstract|static|final)\s+)*function\s+(\w+)`, KindFunction),
+		p(`^\s*(?:(?:abstract|final)\s+)?(?:class|interface|trait|enum)\s+(\w+)`, KindClass),
+	},
+	"shellscript": {
+		p(`^\s*(?:function\s+)?([\w-]+)\s*\(\)\s*\{?`, KindFunction),
+		p(`^\s*function\s+([\w-]+)`, KindFunction),
+	},
+	"markdown": {
+		{re: regexp.MustCompile("^\\s*(```|~~~)"), fence: true},
+		{
+			re:   regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`),
+			kind: KindHeading,
+			level: func(match []string) int {
+				return len(match[0]) - len(strings.TrimLeft(match[0], "#"))
+			},
+		},
+	},
+}
+
+var jsPatterns = []pattern{
+	p(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*([\w$]+)`, KindFunction),
+	p(`^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+([\w$]+)`, KindClass),
+	p(`^\s*(?:export\s+)?(?:const|let|var)\s+([\w$]+)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[\w$]+\s*=>)`, KindFunction),
+}
+
+var cPatterns = []pattern{
+	// A function definition with the opening brace on the same or next line
+	p(`^(?:[\w*&:<>,]+\s+)+\**([\w:~]+)\s*\([^;]*$`, KindFunction),
+	p(`^(?:typedef\s+)?(?:struct|union|enum)\s+(\w+)\s*\{?\s*$`, KindType),
+}
+
+// Used for languages without their own patterns
+var genericPatterns = []pattern{
+	p(`^\s*(?:export\s+)?(?:pub\s+)?(?:async\s+)?(?:func|function|def|fn|sub|proc)\s+([\w$.:]+)`, KindFunction),
+	p(`^\s*(?:export\s+)?(?:pub\s+)?(?:class|struct|interface|trait|enum|record)\s+([\w$]+)`, KindClass),
+	p(`^\s*(?:module|namespace|package)\s+([\w$.:]+)`, KindModule),
+}
+
+// Editors use different language IDs for the same language
+var languageAliases = map[string]string{
+	"javascriptreact": "javascript",
+	"typescriptreact": "typescript",
+	"sh":              "shellscript",
+	"bash":            "shellscript",
+	"zsh":             "shellscript",
+	"cs":              "csharp",
+	"c++":             "cpp",
+	"objective-c":     "c",
+	"objective-cpp":   "cpp",
+	"md":              "markdown",
+	"rmd":             "markdown",
+}
+
+func patternsFor(language string) []pattern {
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	if patterns, ok := languagePatterns[language]; ok {
+		return patterns
+	}
+	return genericPatterns
+}
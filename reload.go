@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"pair-ls/server"
+	"syscall"
+	"time"
+)
+
+const reloadPollInterval = 10 * time.Second
+
+// watchServerConfig reloads the webserver config when the process receives
+// SIGHUP, or when the config file or any of the TLS files change on disk.
+// Values passed on the command line always take precedence over the file.
+func watchServerConfig(config *PairConfig, fs *flag.FlagSet, srv *server.WebServer, logger *log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	overrides := commandLineFlags(flag.CommandLine, fs)
+	mtimes := watchedModTimes(config.configFile, config.serverConfig())
+	for {
+		select {
+		case <-hup:
+			logger.Println("Received SIGHUP, reloading config")
+		case <-ticker.C:
+			newMtimes := watchedModTimes(config.configFile, config.serverConfig())
+			if mapsEqual(mtimes, newMtimes) {
+				continue
+			}
+			logger.Println("Config or certificate files changed, reloading config")
+		}
+		newConfig, err := readConfig(config.configFile)
+		if err != nil {
+			logger.Println("Error reading config file:", err)
+			continue
+		}
+		if err := config.reloadServer(newConfig.Server, overrides, srv); err != nil {
+			logger.Println("Error reloading config:", err)
+		}
+		mtimes = watchedModTimes(config.configFile, config.serverConfig())
+	}
+}
+
+// serverConfig returns the current webserver config, which can change when
+// watchServerConfig reloads it
+func (c *PairConfig) serverConfig() server.WebServerConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Server
+}
+
+// reloadServer replaces the webserver config with the one from the config
+// file, then applies the command line flags on top of it again. The config is
+// only changed if the server accepts it.
+func (c *PairConfig) reloadServer(fromFile server.WebServerConfig, overrides []flagValue, srv *server.WebServer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.Server
+	c.Server = fromFile
+	// Flags are bound to fields of c, so setting them again puts the command
+	// line values back into the new server config. Flags for anything else
+	// still have the same value and aren't touched.
+	for _, override := range overrides {
+		if override.flag.Value.String() == override.value {
+			continue
+		}
+		if err := override.flag.Value.Set(override.value); err != nil {
+			c.Server = old
+			return err
+		}
+	}
+	if err := srv.Reload(c.Server); err != nil {
+		c.Server = old
+		return err
+	}
+	return nil
+}
+
+type flagValue struct {
+	flag  *flag.Flag
+	value string
+}
+
+// commandLineFlags records the value of every flag that was passed on the
+// command line
+func commandLineFlags(flagSets ...*flag.FlagSet) []flagValue {
+	var ret []flagValue
+	for _, fs := range flagSets {
+		fs.Visit(func(f *flag.Flag) {
+			ret = append(ret, flagValue{flag: f, value: f.Value.String()})
+		})
+	}
+	return ret
+}
+
+func watchedModTimes(configFile string, conf server.WebServerConfig) map[string]time.Time {
+	ret := make(map[string]time.Time)
+	for _, filename := range []string{configFile, conf.CertFile, conf.KeyFile, conf.ClientCAs} {
+		if filename == "" {
+			continue
+		}
+		if info, err := os.Stat(filename); err == nil {
+			ret[filename] = info.ModTime()
+		}
+	}
+	return ret
+}
+
+func mapsEqual(a map[string]time.Time, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !b[k].Equal(v) {
+			return false
+		}
+	}
+	return true
+}
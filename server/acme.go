@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig enables automatic TLS certificates (e.g. from Let's Encrypt)
+type ACMEConfig struct {
+	// Domains to request certificates for. Setting this enables ACME.
+	Domains []string `json:"domains"`
+	// Contact email for the ACME account (optional)
+	Email string `json:"email"`
+	// ACME directory. Defaults to Let's Encrypt.
+	DirectoryURL string `json:"directoryURL"`
+	// Extra CA certificates to trust when talking to the ACME directory (for testing against a local ACME server)
+	DirectoryCAFile string `json:"directoryCAFile"`
+	// Where to store certificates and the account key. Defaults to $XDG_CACHE_HOME/pair_ls/acme
+	CacheDir string `json:"cacheDir"`
+	// Port that serves HTTP-01 challenges (and http->https redirects). Defaults to 80.
+	HTTPPort int `json:"httpPort"`
+}
+
+func (c *ACMEConfig) Enabled() bool {
+	return len(c.Domains) > 0
+}
+
+func (c *ACMEConfig) validate() error {
+	if c.HTTPPort < 0 || c.HTTPPort > 65535 {
+		return errors.New("acme.httpPort must be a valid port")
+	}
+	return nil
+}
+
+func (c *ACMEConfig) httpPort() int {
+	if c.HTTPPort == 0 {
+		return 80
+	}
+	return c.HTTPPort
+}
+
+func createACMEManager(conf ACMEConfig) (*autocert.Manager, error) {
+	cacheDir := conf.CacheDir
+	if cacheDir == "" {
+		cacheHome, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(cacheHome, "pair_ls", "acme")
+	}
+	client := &acme.Client{
+		DirectoryURL: conf.DirectoryURL,
+	}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if conf.DirectoryCAFile != "" {
+		data, err := ioutil.ReadFile(conf.DirectoryCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("No certificates found in acme.directoryCAFile")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(conf.Domains...),
+		Email:      conf.Email,
+		Client:     client,
+	}, nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// End-to-end encrypted relay messages. The forwarding pair-ls LSP encrypts
+// every message for viewers with a key the relay never sees, so the relay only
+// stores and forwards opaque blobs.
+
+// E2EBlob is the envelope for one encrypted message
+type E2EBlob struct {
+	Data string `json:"data"`
+}
+
+// E2EMessage is the plaintext of every encrypted blob. Viewers handle these
+// exactly like the notifications they would get from an unencrypted server.
+type E2EMessage struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// NewE2ECipher creates the AES-256-GCM cipher used for encrypted workspaces
+func NewE2ECipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Kinds of encrypted blobs
+const (
+	E2ESnapshot byte = 's'
+	E2EEvent    byte = 'e'
+)
+
+// The header of every blob is its kind and sequence number. Events are
+// numbered consecutively, and a snapshot has the number of the event that
+// comes after it. The header and the name of the workspace are authenticated
+// with the ciphertext, so the relay can't replay, reorder, drop, or move blobs
+// without viewers noticing.
+const e2eHeaderSize = 9
+
+func e2eAdditionalData(header []byte, workspace string) []byte {
+	return append(append([]byte{}, header...), workspace...)
+}
+
+// EncryptE2E returns base64(kind || seq || nonce || ciphertext)
+func EncryptE2E(aead cipher.AEAD, kind byte, seq uint64, workspace string, plaintext []byte) (string, error) {
+	out := make([]byte, e2eHeaderSize+aead.NonceSize(), e2eHeaderSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out[0] = kind
+	binary.BigEndian.PutUint64(out[1:e2eHeaderSize], seq)
+	nonce := out[e2eHeaderSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	out = aead.Seal(out, nonce, plaintext, e2eAdditionalData(out[:e2eHeaderSize], workspace))
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// DecryptE2E reverses EncryptE2E. It fails if the blob was encrypted for a
+// different workspace.
+func DecryptE2E(aead cipher.AEAD, workspace string, data string) (kind byte, seq uint64, plaintext []byte, err error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(raw) < e2eHeaderSize+aead.NonceSize() {
+		return 0, 0, nil, errors.New("Encrypted message is too short")
+	}
+	header := raw[:e2eHeaderSize]
+	nonce := raw[e2eHeaderSize : e2eHeaderSize+aead.NonceSize()]
+	plaintext, err = aead.Open(nil, nonce, raw[e2eHeaderSize+aead.NonceSize():], e2eAdditionalData(header, workspace))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint64(header[1:]), plaintext, nil
+}
+
+// E2ESequence checks that a viewer gets every encrypted blob exactly once and
+// in order
+type E2ESequence struct {
+	next    uint64
+	started bool
+}
+
+// Check is called with each blob that was decrypted. A snapshot may skip
+// ahead (e.g. after reconnecting), but never go back. Events must follow on
+// from the last blob.
+func (s *E2ESequence) Check(kind byte, seq uint64) error {
+	switch kind {
+	case E2ESnapshot:
+		if s.started && seq < s.next {
+			return fmt.Errorf("Encrypted snapshot %d is older than event %d", seq, s.next)
+		}
+	case E2EEvent:
+		if !s.started {
+			return errors.New("Encrypted event before the snapshot")
+		}
+		if seq != s.next {
+			return fmt.Errorf("Expected encrypted event %d, got %d", s.next, seq)
+		}
+		seq++
+	default:
+		return fmt.Errorf("Unknown kind of encrypted message %q", kind)
+	}
+	s.next = seq
+	s.started = true
+	return nil
+}
+
+// E2EInitialize is sent to viewers when they connect. Decrypting the snapshot
+// and then each event in order reproduces the sharer's workspace.
+type E2EInitialize struct {
+	Snapshot string   `json:"snapshot"`
+	Events   []string `json:"events"`
+	// The identity of the sharer, if known (e.g. from a relay client certificate)
+	Identity string `json:"identity,omitempty"`
+}
+
+type e2eStore struct {
+	mu          sync.Mutex
+	snapshot    string
+	events      []string
+	subscribers map[int]func(string)
+	nextID      int
+}
+
+func newE2EStore() *e2eStore {
+	return &e2eStore{
+		events:      make([]string, 0),
+		subscribers: make(map[int]func(string)),
+	}
+}
+
+// active is true once an encrypting forwarder has published to this store
+func (s *e2eStore) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot != ""
+}
+
+func (s *e2eStore) setSnapshot(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = data
+	s.events = s.events[:0]
+}
+
+func (s *e2eStore) publish(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, data)
+	for _, cb := range s.subscribers {
+		cb(data)
+	}
+}
+
+func (s *e2eStore) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = ""
+	s.events = s.events[:0]
+}
+
+// subscribe atomically returns the current snapshot and event log, and
+// registers a callback for all later events
+func (s *e2eStore) subscribe(cb func(string)) (snapshot string, events []string, unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = cb
+	events = make([]string, len(s.events))
+	copy(events, s.events)
+	return s.snapshot, events, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// Handles the encrypted messages from a forwarder, and passes everything else
+// through to the regular LSP handler
+type e2eHandler struct {
+	store *e2eStore
+	next  jsonrpc2.Handler
+}
+
+func (h *e2eHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if req.Method != "e2e/snapshot" && req.Method != "e2e/publish" {
+		h.next.Handle(ctx, conn, req)
+		return
+	}
+	var params E2EBlob
+	if req.Params == nil {
+		h.reply(ctx, conn, req, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams})
+		return
+	}
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		h.reply(ctx, conn, req, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()})
+		return
+	}
+	if req.Method == "e2e/snapshot" {
+		h.store.setSnapshot(params.Data)
+	} else {
+		h.store.publish(params.Data)
+	}
+	h.reply(ctx, conn, req, nil)
+}
+
+func (h *e2eHandler) reply(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request, err *jsonrpc2.Error) {
+	if req.Notif {
+		return
+	}
+	if err != nil {
+		conn.ReplyWithError(ctx, req.ID, err)
+	} else {
+		conn.Reply(ctx, req.ID, nil)
+	}
+}
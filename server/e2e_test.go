@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestE2EEncryption(t *testing.T) {
+	aead, err := NewE2ECipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncryptE2E(aead, E2EEvent, 42, "alice", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, seq, plaintext, err := DecryptE2E(aead, "alice", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != E2EEvent || seq != 42 || string(plaintext) != "hello" {
+		t.Errorf("Got %q, %d, %q", kind, seq, plaintext)
+	}
+
+	if _, _, _, err := DecryptE2E(aead, "bob", data); err == nil {
+		t.Error("Decrypted a message for another workspace")
+	}
+	raw, _ := base64.StdEncoding.DecodeString(data)
+	raw[8]++
+	if _, _, _, err := DecryptE2E(aead, "alice", base64.StdEncoding.EncodeToString(raw)); err == nil {
+		t.Error("Decrypted a message with a changed sequence number")
+	}
+	if _, _, _, err := DecryptE2E(aead, "alice", base64.StdEncoding.EncodeToString(raw[:10])); err == nil {
+		t.Error("Decrypted a truncated message")
+	}
+}
+
+func TestE2ESequence(t *testing.T) {
+	type blob struct {
+		kind byte
+		seq  uint64
+	}
+	tests := []struct {
+		name  string
+		blobs []blob
+		// Index of the first blob that is rejected, or -1
+		reject int
+	}{
+		{"in order", []blob{{E2ESnapshot, 5}, {E2EEvent, 5}, {E2EEvent, 6}}, -1},
+		{"newer snapshot", []blob{{E2ESnapshot, 0}, {E2EEvent, 0}, {E2ESnapshot, 9}, {E2EEvent, 9}}, -1},
+		{"snapshot after events", []blob{{E2ESnapshot, 0}, {E2EEvent, 0}, {E2ESnapshot, 1}}, -1},
+		{"event before snapshot", []blob{{E2EEvent, 0}}, 0},
+		{"gap", []blob{{E2ESnapshot, 0}, {E2EEvent, 0}, {E2EEvent, 2}}, 2},
+		{"duplicate", []blob{{E2ESnapshot, 0}, {E2EEvent, 0}, {E2EEvent, 0}}, 2},
+		{"reordered", []blob{{E2ESnapshot, 0}, {E2EEvent, 1}, {E2EEvent, 0}}, 1},
+		{"older snapshot", []blob{{E2ESnapshot, 3}, {E2EEvent, 3}, {E2ESnapshot, 3}}, 2},
+		{"unknown kind", []blob{{'x', 0}}, 0},
+	}
+	for _, test := range tests {
+		var sequence E2ESequence
+		reject := -1
+		for i, b := range test.blobs {
+			if err := sequence.Check(b.kind, b.seq); err != nil {
+				reject = i
+				break
+			}
+		}
+		if reject != test.reject {
+			t.Errorf("%s: rejected blob %d, want %d", test.name, reject, test.reject)
+		}
+	}
+}
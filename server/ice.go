@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const defaultTURNCredentialTTL = 24 * 60 * 60
+
+// ICEServerConfig is a STUN or TURN server used to establish WebRTC connections
+type ICEServerConfig struct {
+	// stun:, turn:, or turns: URLs
+	URLs []string `json:"urls"`
+	// Static TURN credentials
+	Username   string `json:"username"`
+	Credential string `json:"credential"`
+	// Shared secret for time-limited TURN credentials (the TURN REST API, e.g.
+	// coturn's static-auth-secret). A new username and credential are
+	// generated for every connection, and the secret is never sent to browsers.
+	Secret string `json:"secret"`
+	// How many seconds generated credentials are valid. Defaults to 1 day.
+	TTL int `json:"ttl"`
+}
+
+// DefaultICEServers are used when no ICE servers are configured
+var DefaultICEServers = []ICEServerConfig{
+	{
+		URLs: []string{
+			"stun:openrelay.metered.ca:80",
+			"stun:stun.l.google.com:19302",
+			"stun:stun1.l.google.com:19302",
+			"stun:stun2.l.google.com:19302",
+			"stun:stun3.l.google.com:19302",
+			"stun:stun4.l.google.com:19302",
+		},
+	},
+	{
+		URLs: []string{
+			"turn:openrelay.metered.ca:80",
+			"turn:openrelay.metered.ca:443",
+			"turn:openrelay.metered.ca:443?transport=tcp",
+			"turns:openrelay.metered.ca:443",
+		},
+		Username:   "openrelayproject",
+		Credential: "openrelayproject",
+	},
+}
+
+// ValidateICEServers checks the ICE server config for mistakes
+func ValidateICEServers(servers []ICEServerConfig) error {
+	for _, s := range servers {
+		if len(s.URLs) == 0 {
+			return errors.New("iceServers entry has no urls")
+		}
+		for _, u := range s.URLs {
+			if !strings.HasPrefix(u, "stun:") && !strings.HasPrefix(u, "turn:") && !strings.HasPrefix(u, "turns:") {
+				return fmt.Errorf("Invalid ICE server url %q", u)
+			}
+		}
+		if s.Secret != "" && s.Credential != "" {
+			return errors.New("iceServers entry cannot have both a secret and a credential")
+		}
+		if s.TTL < 0 {
+			return errors.New("iceServers ttl must be positive")
+		}
+	}
+	return nil
+}
+
+// ICEServers converts the config to webrtc.ICEServers, generating fresh
+// credentials for any servers that use a shared secret
+func ICEServers(servers []ICEServerConfig) []webrtc.ICEServer {
+	if len(servers) == 0 {
+		servers = DefaultICEServers
+	}
+	ret := make([]webrtc.ICEServer, 0, len(servers))
+	for _, s := range servers {
+		server := webrtc.ICEServer{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		}
+		if s.Secret != "" {
+			server.Username, server.Credential = turnCredentials(s.Secret, s.Username, s.TTL)
+		}
+		ret = append(ret, server)
+	}
+	return ret
+}
+
+// turnCredentials creates credentials for the TURN REST API. The username is
+// the expiration timestamp (optionally followed by ":<user>"), and the
+// credential is base64(HMAC-SHA1(secret, username)).
+func turnCredentials(secret string, user string, ttl int) (string, string) {
+	if ttl == 0 {
+		ttl = defaultTURNCredentialTTL
+	}
+	username := fmt.Sprint(time.Now().Add(time.Duration(ttl) * time.Second).Unix())
+	if user != "" {
+		username += ":" + user
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
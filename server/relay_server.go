@@ -2,48 +2,94 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"pair-ls/auth"
 	"pair-ls/state"
 	"pair-ls/util"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	"github.com/sourcegraph/jsonrpc2"
 )
 
 type relayServer struct {
-	logger      *log.Logger
-	handler     jsonrpc2.Handler
+	logger        *log.Logger
+	newHandler    func(*state.WorkspaceState) jsonrpc2.Handler
+	authenticator func() auth.Authenticator
+	config        RelayConfig
+	workspaces    map[string]*relayWorkspace
+	mu            sync.Mutex
+}
+
+// Each forwarding identity publishes into its own workspace. Anonymous
+// forwarders (password auth or no auth) all share the "" workspace.
+type relayWorkspace struct {
 	state       *state.WorkspaceState
-	webConfig   WebServerConfig
-	config      RelayConfig
-	connCounter chan bool
+	handler     jsonrpc2.Handler
+	e2e         *e2eStore
 	connections int
 }
 
+func newRelayWorkspace(ws *state.WorkspaceState, handler jsonrpc2.Handler) *relayWorkspace {
+	e2e := newE2EStore()
+	return &relayWorkspace{
+		state: ws,
+		// Encrypted forwarders never send LSP messages, so the LSP handler
+		// only ever sees traffic from unencrypted forwarders
+		handler: &e2eHandler{store: e2e, next: handler},
+		e2e:     e2e,
+	}
+}
+
 type RelayConfig struct {
 	Persist bool
 }
 
 func (s *relayServer) attachHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/relay", s.on_websocket)
-	go func() {
-		for {
-			connected := <-s.connCounter
-			if connected {
-				s.connections++
-			} else {
-				s.connections--
-				if s.connections == 0 && !s.config.Persist {
-					s.state.Clear()
-				}
-			}
-		}
-	}()
+}
+
+func (s *relayServer) connect(identity string) *relayWorkspace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workspace := s.workspaces[identity]
+	if workspace == nil {
+		ws := state.NewState(s.logger)
+		workspace = newRelayWorkspace(ws, s.newHandler(ws))
+		s.workspaces[identity] = workspace
+	}
+	workspace.connections++
+	return workspace
+}
+
+func (s *relayServer) disconnect(workspace *relayWorkspace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workspace.connections--
+	if workspace.connections == 0 {
+		workspace.state.SetEditHandler(nil)
+		workspace.state.SetProposalHandler(nil)
+		workspace.state.SetFocusHandler(nil)
+		workspace.state.SetLanguageHandler(nil)
+	}
+	if workspace.connections == 0 && !s.config.Persist {
+		workspace.state.Clear()
+		workspace.e2e.clear()
+	}
+}
+
+// getWorkspace returns the workspace for a forwarding identity, or nil if no
+// forwarder with that identity has ever connected
+func (s *relayServer) getWorkspace(identity string) *relayWorkspace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workspaces[identity]
 }
 
 func (s *relayServer) on_websocket(w http.ResponseWriter, r *http.Request) {
-	err := s.webConfig.requireAuth(w, r)
+	identity, err := requireAuth(s.logger, s.authenticator(), w, r)
 	if err != nil {
 		return
 	}
@@ -54,15 +100,38 @@ func (s *relayServer) on_websocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer c.Close()
-	s.logger.Println("Forwarding client connected")
-	defer s.logger.Println("Forwarding client disconnected")
+	s.logger.Printf("Forwarding client connected (identity: %q)\n", identity)
+	defer s.logger.Printf("Forwarding client disconnected (identity: %q)\n", identity)
 
-	s.connCounter <- true
-	defer func() { s.connCounter <- false }()
+	workspace := s.connect(identity)
+	defer s.disconnect(workspace)
 	conn := jsonrpc2.NewConn(
 		context.Background(),
 		jsonrpc2.NewBufferedStream(util.WrapWebsocket(c), jsonrpc2.PlainObjectCodec{}),
-		s.handler,
+		workspace.handler,
 	)
+	// Edits from viewers are applied by the forwarder
+	workspace.state.SetEditHandler(func(req state.EditRequest) (state.EditResult, error) {
+		var result state.EditResult
+		err := conn.Call(context.Background(), "submitEdit", req, &result)
+		return result, err
+	})
+	workspace.state.SetProposalHandler(func(req state.ProposeEditRequest) (state.Proposal, error) {
+		var proposal state.Proposal
+		err := conn.Call(context.Background(), "proposeEdit", req, &proposal)
+		return proposal, err
+	})
+	workspace.state.SetFocusHandler(func(req state.FocusRequest) (state.FocusResult, error) {
+		var result state.FocusResult
+		err := conn.Call(context.Background(), "requestFocus", req, &result)
+		return result, err
+	})
+	workspace.state.SetLanguageHandler(func(method string, req state.PositionRequest) (json.RawMessage, error) {
+		var result json.RawMessage
+		err := conn.Call(context.Background(), method, req, &result)
+		return result, err
+	})
+	// Tell the forwarder where viewers can find its workspace
+	conn.Notify(context.Background(), "register", RegisterResponse{Token: identity})
 	<-conn.DisconnectNotify()
 }
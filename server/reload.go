@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"reflect"
+	"sync"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Holds the current TLS config so certificates can be swapped out without
+// restarting the server. Each handshake uses whatever config is current.
+type tlsReloader struct {
+	mu          sync.RWMutex
+	current     *tls.Config
+	acmeManager *autocert.Manager
+}
+
+func (r *tlsReloader) get() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+func (r *tlsReloader) load(conf WebServerConfig) error {
+	tlsConfig, err := createTLSConfig(conf, r.acmeManager)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = tlsConfig
+	return nil
+}
+
+// serverConfig is passed to http.Server
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.get(), nil
+		},
+		// Not used for handshakes (GetConfigForClient takes precedence), but
+		// http.Server requires some certificate source to be set
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			current := r.get()
+			if current.GetCertificate != nil {
+				return current.GetCertificate(hello)
+			}
+			return &current.Certificates[0], nil
+		},
+	}
+}
+
+// Reload applies a new config to the running server. Certificates, client
+// CAs, passwords, and client certificate rules all take effect for new
+// connections. Turning TLS on or off, or changing the ACME settings, requires
+// a restart.
+func (s *WebServer) Reload(config WebServerConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	oldConfig := s.config
+	reloader := s.tls
+	s.mu.RUnlock()
+	if config.TLSEnabled() != oldConfig.TLSEnabled() {
+		return errors.New("Enabling or disabling TLS requires a restart")
+	}
+	if !reflect.DeepEqual(config.ACME, oldConfig.ACME) {
+		return errors.New("Changing the ACME settings requires a restart")
+	}
+	if reloader != nil {
+		if err := reloader.load(config); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	config.warnDeprecated(s.logger)
+	if !s.customAuth {
+		s.authenticator = config.createAuthenticator()
+	}
+	s.logger.Println("Reloaded server config")
+	return nil
+}
@@ -2,8 +2,8 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"embed"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,13 +11,14 @@ import (
 	"net/http"
 	"pair-ls/auth"
 	"pair-ls/state"
-	"strings"
+	"sync"
 	"text/template"
 
 	_ "embed"
 
 	"github.com/sourcegraph/jsonrpc2"
 	"github.com/vearutop/statigz"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 //go:embed dist/*
@@ -30,8 +31,13 @@ var indexTmpl *template.Template
 type WebServerConfig struct {
 	// If provided, will require password auth from web client
 	WebPassword string `json:"webPassword"`
+	// If provided, web clients that log in with this password can edit files
+	// (requires the editor to enable collaborative mode)
+	EditPassword string `json:"editPassword"`
 	// If provided, will require connecting pair-ls LSP to provide this password (only used for relay & signal servers)
 	LspPassword string `json:"lspPassword"`
+	// If provided, the connecting pair-ls LSP must also provide this username (requires LspPassword)
+	LspUsername string `json:"lspUsername"`
 	// If provided, will secure all connections with TLS
 	CertFile string `json:"certFile"`
 	// If the key is not encoded in the CertFile PEM, you can pass it in separately here
@@ -41,45 +47,92 @@ type WebServerConfig struct {
 	// PEM file with one or more certs that pair-ls LSP can match (when RequireClientCert = true)
 	// (only used for relay & signal servers)
 	ClientCAs string `json:"clientCAs"`
+	// If provided, the client cert Common Name or a Subject Alternative Name must match one of these
+	// (requires RequireClientCert = true)
+	ClientCertSubjects []string `json:"clientCertSubjects"`
+	// Maps a client cert Common Name or Subject Alternative Name to the identity used by the relay.
+	// If not found here, the identity is the first name on the cert.
+	ClientCertIdentities map[string]string `json:"clientCertIdentities"`
+	// Obtain and renew TLS certificates automatically (instead of CertFile/KeyFile)
+	ACME ACMEConfig `json:"acme"`
 }
 
 type WebServer struct {
-	logger       *log.Logger
-	state        *state.WorkspaceState
-	config       WebServerConfig
-	relay        *relayServer
-	signalServer *signalServer
+	logger        *log.Logger
+	state         *state.WorkspaceState
+	mu            sync.RWMutex
+	config        WebServerConfig
+	authenticator auth.Authenticator
+	customAuth    bool
+	tls           *tlsReloader
+	relay         *relayServer
+	signalServer  *signalServer
 }
 
 func NewServer(state *state.WorkspaceState, logger *log.Logger, config WebServerConfig) *WebServer {
 	return &WebServer{
-		logger: logger,
-		state:  state,
-		config: config,
+		logger:        logger,
+		state:         state,
+		config:        config,
+		authenticator: config.createAuthenticator(),
 	}
 }
 
-func (s *WebServer) AddRelayServer(handler jsonrpc2.Handler, config RelayConfig) {
+// SetAuthenticator replaces the Authenticator used to check pair-ls LSP
+// connections to the relay and signal servers
+func (s *WebServer) SetAuthenticator(authenticator auth.Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authenticator = authenticator
+	s.customAuth = true
+}
+
+func (s *WebServer) getConfig() WebServerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+func (s *WebServer) getAuthenticator() auth.Authenticator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authenticator
+}
+
+// AddRelayServer accepts connections from forwarding pair-ls LSP servers.
+// newHandler is called once per forwarding identity to create the handler
+// that applies their LSP messages to that identity's workspace.
+func (s *WebServer) AddRelayServer(newHandler func(*state.WorkspaceState) jsonrpc2.Handler, config RelayConfig) {
 	s.relay = &relayServer{
-		logger:      s.logger,
-		handler:     handler,
-		state:       s.state,
-		config:      config,
-		webConfig:   s.config,
-		connCounter: make(chan bool),
+		logger:        s.logger,
+		newHandler:    newHandler,
+		config:        config,
+		authenticator: s.getAuthenticator,
+		workspaces: map[string]*relayWorkspace{
+			"": newRelayWorkspace(s.state, newHandler(s.state)),
+		},
 	}
 }
 
-func (s *WebServer) MakeSignalServer() {
+// MakeSignalServer helps browsers make WebRTC connections to pair-ls LSP
+// servers. iceServers are advertised to the browsers.
+func (s *WebServer) MakeSignalServer(iceServers []ICEServerConfig) {
 	s.signalServer = &signalServer{
-		logger:    s.logger,
-		editorMap: make(map[string]*jsonrpc2.Conn),
-		webConfig: s.config,
+		logger:        s.logger,
+		editorMap:     make(map[string]*jsonrpc2.Conn),
+		candidates:    make(map[string]*candidateQueue),
+		authenticator: s.getAuthenticator,
+		iceServers:    iceServers,
 	}
 }
 
 func (s *WebServer) Serve(hostname string, port int) {
-	if s.config.WebPassword == "" && s.signalServer == nil {
+	config := s.getConfig()
+	if err := config.Validate(); err != nil {
+		s.logger.Fatalln("Invalid server config:", err)
+	}
+	config.warnDeprecated(s.logger)
+	if config.WebPassword == "" && s.signalServer == nil {
 		s.logger.Println("WARNING: running webserver with no password")
 	}
 	mux := http.NewServeMux()
@@ -93,12 +146,33 @@ func (s *WebServer) Serve(hostname string, port int) {
 	if s.signalServer != nil {
 		s.signalServer.attachHandlers(mux)
 	}
-	tlsConfig, err := createTLSConfig(s.config)
-	if err != nil {
-		s.logger.Fatalln(err)
+	var acmeManager *autocert.Manager
+	if config.ACME.Enabled() {
+		var err error
+		acmeManager, err = createACMEManager(config.ACME)
+		if err != nil {
+			s.logger.Fatalln(err)
+		}
 	}
-	if tlsConfig != nil && (port == 80 || port == 443) {
-		go s.serveHTTPSRedirect(hostname, 80)
+	var tlsConfig *tls.Config
+	if config.TLSEnabled() {
+		reloader := &tlsReloader{acmeManager: acmeManager}
+		if err := reloader.load(config); err != nil {
+			s.logger.Fatalln(err)
+		}
+		s.mu.Lock()
+		s.tls = reloader
+		s.mu.Unlock()
+		tlsConfig = reloader.serverConfig()
+	}
+	if acmeManager != nil {
+		if port == 80 {
+			port = 443
+		}
+		// HTTP-01 challenges are answered on the same listener as the redirects
+		go s.serveHTTPSRedirect(hostname, config.ACME.httpPort(), acmeManager.HTTPHandler(http.HandlerFunc(redirect)))
+	} else if tlsConfig != nil && (port == 80 || port == 443) {
+		go s.serveHTTPSRedirect(hostname, 80, http.HandlerFunc(redirect))
 		port = 443
 	}
 	host := fmt.Sprintf("%s:%d", hostname, port)
@@ -130,14 +204,28 @@ func (s *WebServer) on_index(w http.ResponseWriter, r *http.Request) {
 	indexTmpl.Execute(w, useRTC)
 }
 
-func createTLSConfig(conf WebServerConfig) (*tls.Config, error) {
-	if conf.CertFile == "" {
+func createTLSConfig(conf WebServerConfig, acmeManager *autocert.Manager) (*tls.Config, error) {
+	var config *tls.Config
+	var certPool *x509.CertPool
+	if acmeManager != nil {
+		config = acmeManager.TLSConfig()
+	} else if conf.CertFile != "" {
+		cert, pool, err := auth.LoadCertFromPEMs(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool = pool
+		config = &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	} else {
 		return nil, nil
 	}
-	cert, pool, err := auth.LoadCertFromPEMs(conf.CertFile, conf.KeyFile)
-	if err != nil {
-		return nil, err
-	}
+	// Client certificates are trusted (and name the user) only if they chain to
+	// clientCAs. The server's own certificate isn't trusted unless it's listed
+	// there.
+	pool := x509.NewCertPool()
 	auth := tls.NoClientCert
 	if conf.RequireClientCert {
 		auth = tls.VerifyClientCertIfGiven
@@ -147,41 +235,82 @@ func createTLSConfig(conf WebServerConfig) (*tls.Config, error) {
 				return nil, err
 			}
 			pool.AppendCertsFromPEM(data)
+		} else if conf.trustsCertFile() {
+			pool = certPool
 		}
 	}
 
-	config := tls.Config{
-		ClientAuth:   auth,
-		Certificates: []tls.Certificate{*cert},
-		ClientCAs:    pool,
+	config.ClientAuth = auth
+	config.ClientCAs = pool
+	return config, nil
+}
+
+// TLSEnabled is true if the webserver will serve over https
+func (c *WebServerConfig) TLSEnabled() bool {
+	return c.CertFile != "" || c.ACME.Enabled()
+}
+
+// Validate checks for settings that are incompatible with each other
+func (c *WebServerConfig) Validate() error {
+	if c.KeyFile != "" && c.CertFile == "" {
+		return errors.New("keyFile requires certFile")
+	}
+	if c.CertFile != "" && c.ACME.Enabled() {
+		return errors.New("certFile and acme cannot both be used")
+	}
+	if err := c.ACME.validate(); err != nil {
+		return err
+	}
+	if c.RequireClientCert && !c.TLSEnabled() {
+		return errors.New("requireClientCert requires TLS (certFile or acme)")
+	}
+	if c.RequireClientCert && c.ClientCAs == "" && c.CertFile == "" {
+		return errors.New("requireClientCert requires clientCAs")
+	}
+	if len(c.ClientCertSubjects) > 0 && !c.RequireClientCert {
+		return errors.New("clientCertSubjects has no effect unless requireClientCert = true")
+	}
+	if len(c.ClientCertIdentities) > 0 && !c.RequireClientCert {
+		return errors.New("clientCertIdentities has no effect unless requireClientCert = true")
+	}
+	if c.LspUsername != "" && c.LspPassword == "" {
+		return errors.New("lspUsername requires lspPassword")
 	}
-	return &config, nil
+	return nil
 }
 
-func (c *WebServerConfig) requireAuth(w http.ResponseWriter, r *http.Request) error {
-	var err error
-	if c.RequireClientCert && len(r.TLS.VerifiedChains) == 0 {
-		err = errors.New("No valid client certificate")
+// trustsCertFile is true for old configs that require client certificates
+// without setting clientCAs. Those accept any client certificate signed by the
+// server's certFile, which is deprecated.
+func (c *WebServerConfig) trustsCertFile() bool {
+	return c.RequireClientCert && c.ClientCAs == "" && c.CertFile != ""
+}
+
+func (c *WebServerConfig) warnDeprecated(logger *log.Logger) {
+	if c.trustsCertFile() {
+		logger.Println("WARNING: requireClientCert without clientCAs trusts client certs signed by certFile. This is deprecated, set clientCAs instead.")
+	}
+}
+
+func (c *WebServerConfig) createAuthenticator() auth.Authenticator {
+	authenticators := make([]auth.Authenticator, 0, 2)
+	if c.RequireClientCert {
+		authenticators = append(authenticators, auth.NewClientCertAuthenticator(c.ClientCertSubjects, c.ClientCertIdentities))
 	}
 	if c.LspPassword != "" {
-		auth := r.Header.Get("Authorization")
-		if auth == "" {
-			err = errors.New("Missing Authorization header")
-		} else {
-			pieces := strings.SplitN(auth, " ", 2)
-			if len(pieces) < 2 {
-				err = errors.New("Malformed Authorization header")
-			} else {
-				var code []byte
-				code, err = base64.StdEncoding.DecodeString(pieces[1])
-				if string(code) != c.LspPassword {
-					err = errors.New("Password mismatch")
-				}
-			}
-		}
+		authenticators = append(authenticators, auth.NewPasswordAuthenticator(c.LspUsername, c.LspPassword))
+	}
+	if len(authenticators) == 0 {
+		return auth.AllowAll
 	}
+	return auth.RequireAll(authenticators...)
+}
+
+func requireAuth(logger *log.Logger, authenticator auth.Authenticator, w http.ResponseWriter, r *http.Request) (string, error) {
+	identity, err := authenticator.Authenticate(r)
 	if err != nil {
+		logger.Println("Rejected connection from", r.RemoteAddr, err)
 		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 	}
-	return err
+	return identity, err
 }
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"pair-ls/auth"
 	"pair-ls/util"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/randutil"
@@ -15,21 +18,36 @@ import (
 	"github.com/sourcegraph/jsonrpc2"
 )
 
+// How long a browser waits for new ICE candidates from the editor before
+// polling again
+const candidatePollTimeout = 20 * time.Second
+
+// Candidates that no browser polls for within this long are dropped
+const candidateQueueTTL = 2 * time.Minute
+
+// After the browser has received all of the editor's candidates, the queue is
+// kept this long so that another poll finds out right away
+const finishedQueueTTL = 10 * time.Second
+
 type signalServer struct {
-	logger    *log.Logger
-	editorMap map[string]*jsonrpc2.Conn
-	mu        sync.Mutex
-	webConfig WebServerConfig
+	logger        *log.Logger
+	editorMap     map[string]*jsonrpc2.Conn
+	candidates    map[string]*candidateQueue
+	mu            sync.Mutex
+	authenticator func() auth.Authenticator
+	iceServers    []ICEServerConfig
 }
 
 func (s *signalServer) attachHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/signal", s.on_websocket)
 	mux.HandleFunc("/call", s.on_call)
 	mux.HandleFunc("/ice", s.on_ice)
+	mux.HandleFunc("/ice_candidates", s.on_ice_candidates)
+	mux.HandleFunc("/ice_servers", s.on_ice_servers)
 }
 
 func (s *signalServer) on_websocket(w http.ResponseWriter, r *http.Request) {
-	err := s.webConfig.requireAuth(w, r)
+	_, err := requireAuth(s.logger, s.authenticator(), w, r)
 	if err != nil {
 		return
 	}
@@ -43,16 +61,18 @@ func (s *signalServer) on_websocket(w http.ResponseWriter, r *http.Request) {
 	s.logger.Println("Editor connected")
 	defer s.logger.Println("Editor disconnected")
 
-	conn := jsonrpc2.NewConn(
-		context.Background(),
-		jsonrpc2.NewBufferedStream(util.WrapWebsocket(c), jsonrpc2.PlainObjectCodec{}),
-		jsonrpc2.HandlerWithError(s.handle),
-	)
 	token, err := createToken()
 	if err != nil {
 		s.logger.Println("Error creating token", err)
 		return
 	}
+	conn := jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(util.WrapWebsocket(c), jsonrpc2.PlainObjectCodec{}),
+		jsonrpc2.HandlerWithError(func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+			return s.handle(token, ctx, conn, req)
+		}),
+	)
 	s.setConn(token, conn)
 	defer s.delConn(token)
 	conn.Notify(context.Background(), "register", RegisterResponse{Token: token})
@@ -75,6 +95,55 @@ func (s *signalServer) delConn(token string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.editorMap, token)
+	for key := range s.candidates {
+		if strings.HasPrefix(key, token+":") {
+			delete(s.candidates, key)
+		}
+	}
+}
+
+// getCandidates returns the queue of editor ICE candidates for a browser. The
+// queue is created if create is true, and otherwise may be nil.
+func (s *signalServer) getCandidates(token string, clientID string, create bool) *candidateQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireCandidates()
+	if s.editorMap[token] == nil {
+		return nil
+	}
+	key := token + ":" + clientID
+	queue := s.candidates[key]
+	if queue == nil {
+		if !create {
+			return nil
+		}
+		queue = newCandidateQueue()
+		s.candidates[key] = queue
+	}
+	if !queue.finished {
+		queue.expires = time.Now().Add(candidateQueueTTL)
+	}
+	return queue
+}
+
+// finishCandidates is called once the browser has all of the candidates
+func (s *signalServer) finishCandidates(token string, clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if queue := s.candidates[token+":"+clientID]; queue != nil && !queue.finished {
+		queue.finished = true
+		queue.expires = time.Now().Add(finishedQueueTTL)
+	}
+}
+
+// expireCandidates must be called with s.mu held
+func (s *signalServer) expireCandidates() {
+	now := time.Now()
+	for key, queue := range s.candidates {
+		if now.After(queue.expires) {
+			delete(s.candidates, key)
+		}
+	}
 }
 
 func createToken() (string, error) {
@@ -87,12 +156,33 @@ type RegisterResponse struct {
 	Token string `json:"token"`
 }
 
-func (s *signalServer) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+// EditorCandidate trickles an ICE candidate from the editor to a browser
+type EditorCandidate struct {
+	ClientID string `json:"client_id"`
+	// nil when the editor has finished gathering candidates
+	Candidate *webrtc.ICECandidateInit `json:"candidate"`
+}
+
+func (s *signalServer) handle(token string, ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			s.logger.Println("Error handling RTC call", req.Method, r)
 		}
 	}()
+	switch req.Method {
+	case "ice":
+		if req.Params == nil {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+		}
+		var params EditorCandidate
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		if queue := s.getCandidates(token, params.ClientID, true); queue != nil {
+			queue.push(params.Candidate)
+		}
+		return nil, nil
+	}
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
 }
 
@@ -101,7 +191,21 @@ func (s *signalServer) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jso
 type callParams struct {
 	Offer webrtc.SessionDescription `json:"offer"`
 	Token string                    `json:"token"`
+	Name  string                    `json:"name"`
+	// See util.EditProof
+	EditProof string `json:"edit_proof"`
+}
+
+// CallRequest is sent to the editor when a browser calls it. The offer is
+// embedded so editors that only expect a session description still work.
+type CallRequest struct {
+	webrtc.SessionDescription
+	// The name the viewer gave, which isn't verified
+	Name string `json:"name,omitempty"`
+	// Lets the viewer edit if it was made with the editor's edit password
+	EditProof string `json:"edit_proof,omitempty"`
 }
+
 type CallResponse struct {
 	Answer   webrtc.SessionDescription `json:"answer"`
 	ClientID string                    `json:"client_id"`
@@ -121,11 +225,17 @@ func (s *signalServer) on_call(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var response CallResponse
-	err = conn.Call(context.Background(), "call", params.Offer, &response)
+	err = conn.Call(context.Background(), "call", CallRequest{
+		SessionDescription: params.Offer,
+		Name:               params.Name,
+		EditProof:          params.EditProof,
+	}, &response)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	// Only browsers that made a call can poll for candidates
+	s.getCandidates(params.Token, response.ClientID, true)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -165,3 +275,127 @@ func (s *signalServer) on_ice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(struct{}{})
 }
+
+type iceCandidatesParams struct {
+	Token    string `json:"token"`
+	ClientID string `json:"client_id"`
+}
+
+type IceCandidatesResponse struct {
+	Candidates []webrtc.ICECandidateInit `json:"candidates"`
+	// True when the editor has sent all of its candidates
+	Done bool `json:"done"`
+}
+
+// on_ice_candidates long-polls for the editor's ICE candidates
+func (s *signalServer) on_ice_candidates(w http.ResponseWriter, r *http.Request) {
+	var params iceCandidatesParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	queue := s.getCandidates(params.Token, params.ClientID, false)
+	if queue == nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), candidatePollTimeout)
+	defer cancel()
+	candidates, done := queue.wait(ctx)
+	if done {
+		s.finishCandidates(params.Token, params.ClientID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IceCandidatesResponse{
+		Candidates: candidates,
+		Done:       done,
+	})
+}
+
+// The browser version of webrtc.ICEServer
+type BrowserICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// on_ice_servers returns the ICE servers to call an editor with. They can
+// include TURN credentials, so only browsers with an editor's token get them.
+func (s *signalServer) on_ice_servers(w http.ResponseWriter, r *http.Request) {
+	if s.getConn(r.URL.Query().Get("token")) == nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	servers := ICEServers(s.iceServers)
+	response := make([]BrowserICEServer, 0, len(servers))
+	for _, server := range servers {
+		credential, _ := server.Credential.(string)
+		response = append(response, BrowserICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: credential,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Editor ICE candidates waiting to be picked up by a browser
+type candidateQueue struct {
+	mu         sync.Mutex
+	candidates []webrtc.ICECandidateInit
+	done       bool
+	ready      chan struct{}
+	// Guarded by signalServer.mu
+	expires  time.Time
+	finished bool
+}
+
+func newCandidateQueue() *candidateQueue {
+	return &candidateQueue{
+		candidates: make([]webrtc.ICECandidateInit, 0),
+		ready:      make(chan struct{}),
+	}
+}
+
+// push adds a candidate, or marks the queue done if candidate is nil
+func (q *candidateQueue) push(candidate *webrtc.ICECandidateInit) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if candidate == nil {
+		q.done = true
+	} else {
+		q.candidates = append(q.candidates, *candidate)
+	}
+	select {
+	case <-q.ready:
+	default:
+		close(q.ready)
+	}
+}
+
+// wait returns all queued candidates, blocking until there is at least one
+// (or the editor is done, or ctx expires)
+func (q *candidateQueue) wait(ctx context.Context) ([]webrtc.ICECandidateInit, bool) {
+	q.mu.Lock()
+	ready := q.ready
+	q.mu.Unlock()
+	select {
+	case <-ready:
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	candidates := q.candidates
+	q.candidates = make([]webrtc.ICECandidateInit, 0)
+	if !q.done {
+		select {
+		case <-q.ready:
+			q.ready = make(chan struct{})
+		default:
+		}
+	}
+	return candidates, q.done
+}
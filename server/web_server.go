@@ -2,10 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"pair-ls/state"
 	"pair-ls/util"
 
 	"github.com/gorilla/websocket"
@@ -13,7 +15,29 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// findWorkspace finds the workspace a viewer wants to see. Relay servers host
+// one workspace per forwarding identity, selected by the "workspace" query
+// parameter. The e2eStore is only non-nil for relay workspaces.
+func (s *WebServer) findWorkspace(r *http.Request) (*state.WorkspaceState, *e2eStore) {
+	identity := r.URL.Query().Get("workspace")
+	if s.relay != nil {
+		workspace := s.relay.getWorkspace(identity)
+		if workspace == nil {
+			return nil, nil
+		}
+		return workspace.state, workspace.e2e
+	} else if identity != "" {
+		return nil, nil
+	}
+	return s.state, nil
+}
+
 func (s *WebServer) on_websocket(w http.ResponseWriter, r *http.Request) {
+	workspaceState, e2e := s.findWorkspace(r)
+	if workspaceState == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
 	var upgrader = websocket.Upgrader{} // use default options
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -25,9 +49,12 @@ func (s *WebServer) on_websocket(w http.ResponseWriter, r *http.Request) {
 	defer s.logger.Println("Client disconnected")
 
 	handler := websocketHandler{
-		logger:   s.logger,
-		state:    s.state,
-		password: s.config.WebPassword,
+		logger:       s.logger,
+		state:        workspaceState,
+		e2e:          e2e,
+		identity:     r.URL.Query().Get("workspace"),
+		password:     s.getConfig().WebPassword,
+		editPassword: s.getConfig().EditPassword,
 	}
 
 	conn := jsonrpc2.NewConn(
@@ -49,17 +76,31 @@ func (s *WebServer) on_login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	token := ""
-	if s.config.WebPassword != "" {
-		if data.Password == s.config.WebPassword {
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(s.config.WebPassword), bcrypt.DefaultCost)
-			if err != nil {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
+	config := s.getConfig()
+	// The edit password also grants read access
+	passwords := []string{config.EditPassword, config.WebPassword}
+	if config.WebPassword != "" || data.Password != "" {
+		matched := false
+		for _, password := range passwords {
+			if password == "" {
+				continue
 			}
-			token = string(hashedPassword)
-		} else if err := bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(s.config.WebPassword)); err == nil {
-			token = data.Password
-		} else {
+			if subtle.ConstantTimeCompare([]byte(data.Password), []byte(password)) == 1 {
+				hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				token = string(hashedPassword)
+			} else if err := bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(password)); err == nil {
+				token = data.Password
+			} else {
+				continue
+			}
+			matched = true
+			break
+		}
+		if !matched && config.WebPassword != "" {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
@@ -77,9 +118,9 @@ func redirect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("https://%s", r.Host), 301)
 }
 
-func (s *WebServer) serveHTTPSRedirect(hostname string, port int) {
+func (s *WebServer) serveHTTPSRedirect(hostname string, port int, handler http.Handler) {
 	server := http.NewServeMux()
-	server.HandleFunc("/", redirect)
+	server.Handle("/", handler)
 	host := fmt.Sprintf("%s:%d", hostname, port)
 	s.logger.Printf("Serving http->https redirects on %s\n", host)
 	defer s.logger.Println("Redirect server shut down")
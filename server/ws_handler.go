@@ -14,13 +14,27 @@ import (
 type websocketHandler struct {
 	logger   *log.Logger
 	state    *state.WorkspaceState
+	e2e      *e2eStore
+	identity string
 	password string
 	authed   bool
+	// If provided, viewers that log in with this password can edit
+	editPassword string
+	canEdit      bool
 }
 
 type InitializeClient struct {
 	View  *state.View  `json:"view"`
 	Files []state.File `json:"files"`
+	// The identity of the sharer, if known (e.g. from a relay client certificate)
+	Identity string `json:"identity,omitempty"`
+	// Unresolved edits proposed by viewers
+	Proposals []state.Proposal `json:"proposals,omitempty"`
+	// The git repository the workspace is in, if any
+	Repo *state.Repo `json:"repo,omitempty"`
+	// The names of the workspace folders, if there's more than one. Filenames
+	// start with the name of their folder.
+	Folders []string `json:"folders,omitempty"`
 }
 
 type GetFileRequest struct {
@@ -35,6 +49,113 @@ func (h *websocketHandler) handleGetFile(ctx context.Context, conn *jsonrpc2.Con
 	return h.state.GetFile(params.Filename), nil
 }
 
+func (h *websocketHandler) handleGetOutline(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params GetFileRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	symbols, err := h.state.GetOutline(params.Filename)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+	}
+	return symbols, nil
+}
+
+func (h *websocketHandler) handleSearch(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.SearchRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	var emit func([]state.SearchMatch)
+	if params.Token != "" {
+		emit = func(matches []state.SearchMatch) {
+			conn.Notify(ctx, "searchResults", state.SearchResultsNotification{
+				Token:   params.Token,
+				Matches: matches,
+			})
+		}
+	}
+	results, err := h.state.Search(params, emit)
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+	}
+	return results, nil
+}
+
+func (h *websocketHandler) handleGetDiff(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.DiffRequest
+	if req.Params != nil {
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	return h.state.GetDiff(params), nil
+}
+
+func (h *websocketHandler) handleSubmitEdit(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.EditRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	editResult, err := h.state.SubmitEdit(params)
+	if err != nil {
+		if _, ok := err.(*jsonrpc2.Error); ok {
+			return nil, err
+		}
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return editResult, nil
+}
+
+func (h *websocketHandler) handleProposeEdit(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.ProposeEditRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	proposal, err := h.state.ProposeEdit(params)
+	if err != nil {
+		if _, ok := err.(*jsonrpc2.Error); ok {
+			return nil, err
+		}
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return proposal, nil
+}
+
+func (h *websocketHandler) handleRequestFocus(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.FocusRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	focusResult, err := h.state.RequestFocus(params)
+	if err != nil {
+		if _, ok := err.(*jsonrpc2.Error); ok {
+			return nil, err
+		}
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return focusResult, nil
+}
+
+func (h *websocketHandler) handleLanguageRequest(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
+	var params state.PositionRequest
+	if err := json.Unmarshal(*req.Params, &params); err != nil {
+		return nil, err
+	}
+	if req.Method == state.LanguageHover {
+		result, err = h.state.Hover(params)
+	} else {
+		result, err = h.state.Definition(params)
+	}
+	if err != nil {
+		if _, ok := err.(*jsonrpc2.Error); ok {
+			return nil, err
+		}
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: err.Error()}
+	}
+	return result, nil
+}
+
 func (h *websocketHandler) handleAuth(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
 	if h.authed {
 		return nil, nil
@@ -45,20 +166,35 @@ func (h *websocketHandler) handleAuth(ctx context.Context, conn *jsonrpc2.Conn,
 	if err := json.Unmarshal(*req.Params, &params); err != nil {
 		return nil, err
 	}
-	if h.password != "" {
+	if h.editPassword != "" && bcrypt.CompareHashAndPassword([]byte(params.Token), []byte(h.editPassword)) == nil {
+		h.canEdit = true
+	} else if h.password != "" {
 		if err := bcrypt.CompareHashAndPassword([]byte(params.Token), []byte(h.password)); err != nil {
 			return nil, &jsonrpc2.Error{Code: 401, Message: "Invalid auth token"}
 		}
 	}
 
 	h.authed = true
+	if h.e2e != nil && h.e2e.active() {
+		go h.runE2E(conn)
+		return nil, nil
+	}
 	conn.Notify(context.Background(), "initialize", InitializeClient{
-		View:  h.state.GetView(),
-		Files: h.state.GetFiles(),
+		View:      h.state.GetView(),
+		Files:     h.state.GetFiles(),
+		Identity:  h.identity,
+		Proposals: h.state.GetProposals(""),
+		Repo:      h.state.GetRepo(),
+		Folders:   h.state.GetFolders(),
 	})
 	return nil, nil
 }
 
+// Methods that viewers can call when the workspace is end-to-end encrypted.
+// The relay can't read the workspace or pass anything on to the editor, so
+// none of the current ones are.
+var e2eMethods = map[string]bool{}
+
 func (h *websocketHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (result interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -69,30 +205,95 @@ func (h *websocketHandler) handle(ctx context.Context, conn *jsonrpc2.Conn, req
 	if !h.authed {
 		return h.handleAuth(ctx, conn, req)
 	}
+	if h.e2e != nil && h.e2e.active() && !e2eMethods[req.Method] {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "Workspace is end-to-end encrypted"}
+	}
 
 	switch req.Method {
 	case "getText":
 		return h.handleGetFile(ctx, conn, req)
+	case "getOutline":
+		return h.handleGetOutline(ctx, conn, req)
+	case "search":
+		return h.handleSearch(ctx, conn, req)
+	case "getDiff":
+		return h.handleGetDiff(ctx, conn, req)
+	case "submitEdit":
+		if !h.canEdit {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "Not allowed to edit"}
+		}
+		return h.handleSubmitEdit(ctx, conn, req)
+	case "proposeEdit":
+		return h.handleProposeEdit(ctx, conn, req)
+	case "requestFocus":
+		return h.handleRequestFocus(ctx, conn, req)
+	case "hover", "definition":
+		return h.handleLanguageRequest(ctx, conn, req)
 	}
 
 	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
 }
 
+// EventMethod is the name of the notification that sends a state event to viewers
+func EventMethod(value interface{}) (string, bool) {
+	switch value.(type) {
+	case state.OpenFileEvent:
+		return "openFile", true
+	case state.CloseFileEvent:
+		return "closeFile", true
+	case state.ReplaceTextEvent:
+		return "textReplaced", true
+	case state.UpdateTextEvent:
+		return "updateText", true
+	case state.ChangeViewEvent:
+		return "updateView", true
+	case state.ProposalEvent:
+		return "proposal", true
+	case state.ResolveProposalEvent:
+		return "proposalResolved", true
+	case state.RepoEvent:
+		return "updateRepo", true
+	case state.FoldersEvent:
+		return "updateFolders", true
+	}
+	return "", false
+}
+
 func GetForwardStateChangesCallback(logger *log.Logger, conn *jsonrpc2.Conn) func(interface{}) {
 	return func(value interface{}) {
-		switch t := value.(type) {
-		case state.OpenFileEvent:
-			conn.Notify(context.Background(), "openFile", value)
-		case state.CloseFileEvent:
-			conn.Notify(context.Background(), "closeFile", value)
-		case state.ReplaceTextEvent:
-			conn.Notify(context.Background(), "textReplaced", value)
-		case state.UpdateTextEvent:
-			conn.Notify(context.Background(), "updateText", value)
-		case state.ChangeViewEvent:
-			conn.Notify(context.Background(), "updateView", value)
+		method, ok := EventMethod(value)
+		if !ok {
+			logger.Printf("Received unknown type from state %T\n", value)
+			return
+		}
+		conn.Notify(context.Background(), method, value)
+	}
+}
+
+// runE2E streams the encrypted workspace to a viewer that has authenticated
+func (h *websocketHandler) runE2E(conn *jsonrpc2.Conn) {
+	// Notifications are queued so that a slow viewer can't block the forwarder
+	queue := make(chan string, 1024)
+	snapshot, events, unsubscribe := h.e2e.subscribe(func(data string) {
+		select {
+		case queue <- data:
 		default:
-			logger.Println("Received unknown type from state", t)
+			h.logger.Println("Viewer is too far behind, disconnecting")
+			conn.Close()
+		}
+	})
+	defer unsubscribe()
+	conn.Notify(context.Background(), "e2e/initialize", E2EInitialize{
+		Snapshot: snapshot,
+		Events:   events,
+		Identity: h.identity,
+	})
+	for {
+		select {
+		case data := <-queue:
+			conn.Notify(context.Background(), "e2e/event", E2EBlob{Data: data})
+		case <-conn.DisconnectNotify():
+			return
 		}
 	}
 }
@@ -100,7 +301,7 @@ func GetForwardStateChangesCallback(logger *log.Logger, conn *jsonrpc2.Conn) fun
 func (h *websocketHandler) run(conn *jsonrpc2.Conn) {
 	var forward = GetForwardStateChangesCallback(h.logger, conn)
 	var callback = func(value interface{}) {
-		if h.authed {
+		if h.authed && (h.e2e == nil || !h.e2e.active()) {
 			forward(value)
 		}
 	}
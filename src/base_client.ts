@@ -1,9 +1,17 @@
 import { JsonRPC } from "./jsonrpc";
-import { Dispatcher, View, File, ChangeTextRange, showToast } from "./state";
+import {
+  Dispatcher,
+  View,
+  File,
+  ChangeTextRange,
+  Repo,
+  showToast,
+} from "./state";
 
 export default abstract class BaseClient {
   protected dispatch: Dispatcher;
   protected promises: { [filename: string]: Promise<void> };
+  protected encrypted: boolean;
   private rpc: JsonRPC;
   private last_file_fetch: string | null;
 
@@ -19,6 +27,19 @@ export default abstract class BaseClient {
     this.dispatch = dispatch;
     this.promises = {};
     this.last_file_fetch = null;
+    this.encrypted = false;
+  }
+
+  // Dispatch a notification that arrived some other way than the RPC
+  // connection (e.g. decrypted from an end-to-end encrypted relay)
+  protected handleMessage(method: string, params: any) {
+    const key = "on" + method[0].toUpperCase() + method.slice(1);
+    const callback = (BaseClient.prototype as any)[key];
+    if (callback == null) {
+      console.warn("Unknown method", method);
+      return;
+    }
+    callback.call(this, params);
   }
 
   getFileLoadPromise(filename: string): Promise<void> | undefined {
@@ -26,7 +47,8 @@ export default abstract class BaseClient {
   }
 
   getText(filename: string): Promise<void> {
-    if (filename === this.last_file_fetch) {
+    // Encrypted workspaces always send the file text along with the file
+    if (this.encrypted || filename === this.last_file_fetch) {
       return Promise.resolve();
     }
     if (this.promises[filename] != null) {
@@ -52,12 +74,27 @@ export default abstract class BaseClient {
   }
 
   // @ts-ignore
-  private onInitialize({ view, files }: { view: View; files: File[] }) {
+  private onInitialize({
+    view,
+    files,
+    identity,
+    repo,
+    folders,
+  }: {
+    view: View;
+    files: File[];
+    identity?: string;
+    repo?: Repo | null;
+    folders?: string[] | null;
+  }) {
     this.dispatch({
       type: "initialize",
       sync: {
         view,
         files,
+        identity,
+        repo,
+        folders,
       },
     });
   }
@@ -125,4 +162,20 @@ export default abstract class BaseClient {
       view,
     });
   }
+
+  // @ts-ignore
+  private onUpdateRepo({ repo }: { repo: Repo | null }) {
+    this.dispatch({
+      type: "updateRepo",
+      repo,
+    });
+  }
+
+  // @ts-ignore
+  private onUpdateFolders({ folders }: { folders: string[] | null }) {
+    this.dispatch({
+      type: "updateFolders",
+      folders,
+    });
+  }
 }
@@ -1,14 +1,61 @@
 import { Dispatcher, showToast } from "./state";
 import WebSocketRPC from "./websocket_rpc";
 import BaseClient from "./base_client";
+import {
+  decrypt,
+  E2ESequence,
+  EVENT,
+  getKeyFromHash,
+  importKey,
+  SNAPSHOT,
+} from "./e2e";
 
 export default class Client extends BaseClient {
   private reconnectAlertID: number | null;
+  private key: Promise<CryptoKey> | null;
+  // Decrypted messages must be handled in order
+  private decryptQueue: Promise<void>;
+  // Encrypted blobs must be for this workspace, and arrive exactly once and in
+  // order
+  private workspace: string;
+  private sequence: E2ESequence;
 
-  constructor(url: string, dispatch: Dispatcher, token: string) {
+  constructor(
+    url: string,
+    dispatch: Dispatcher,
+    token: string,
+    workspace: string
+  ) {
     const rpc = new WebSocketRPC(url, { batching: false });
     super(rpc, dispatch);
     this.reconnectAlertID = null;
+    const key = getKeyFromHash(window.location.hash);
+    this.key = key == null ? null : importKey(key);
+    this.decryptQueue = Promise.resolve();
+    this.workspace = workspace;
+    this.sequence = new E2ESequence();
+    rpc.registerMethod(
+      "e2e/initialize",
+      ({
+        snapshot,
+        events,
+        identity,
+      }: {
+        snapshot: string;
+        events: string[];
+        identity?: string;
+      }) => {
+        this.encrypted = true;
+        this.decryptMessage(SNAPSHOT, snapshot, (params) => ({
+          ...params,
+          identity,
+        }));
+        events.forEach((event) => this.decryptMessage(EVENT, event));
+      }
+    );
+    rpc.registerMethod("e2e/event", ({ data }: { data: string }) =>
+      this.decryptMessage(EVENT, data)
+    );
     rpc.addEventListener("open", () => {
       const showSuccess = this.reconnectAlertID != null;
       if (this.reconnectAlertID != null) {
@@ -55,4 +102,44 @@ export default class Client extends BaseClient {
       }
     });
   }
+
+  private decryptMessage(
+    kind: number,
+    data: string,
+    transform?: (params: any) => any
+  ) {
+    const key = this.key;
+    if (key == null) {
+      showToast(
+        this.dispatch,
+        "This workspace is encrypted. Use the full share link, including the part after #",
+        { severity: "error" },
+        null
+      );
+      return;
+    }
+    this.decryptQueue = this.decryptQueue
+      .then(() => key)
+      .then((k) => decrypt(k, this.workspace, data))
+      .then((blob) => {
+        if (blob.kind !== kind) {
+          throw new Error(`Expected encrypted message of kind ${kind}`);
+        }
+        this.sequence.check(blob.kind, blob.seq);
+        return blob.message;
+      })
+      .then(
+        ({ method, params }) => {
+          this.handleMessage(method, transform ? transform(params) : params);
+        },
+        (e) => {
+          console.error("Error decrypting message", e);
+          showToast(
+            this.dispatch,
+            "Could not decrypt workspace. Is the share link correct?",
+            { severity: "error" }
+          );
+        }
+      );
+  }
 }
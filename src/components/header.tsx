@@ -2,6 +2,22 @@ import * as React from "react";
 import Tabs from "./tabs";
 import styled from "@emotion/styled";
 import Menu from "./menu";
+import { AppContext } from "../state";
+const { useContext } = React;
+
+const Identity = styled.div`
+  align-self: center;
+  padding: 0 8px;
+  white-space: nowrap;
+  opacity: 0.7;
+`;
+
+const Branch = styled.div`
+  align-self: center;
+  padding: 0 8px;
+  white-space: nowrap;
+  opacity: 0.7;
+`;
 
 const Container = styled.div`
   display: flex;
@@ -11,9 +27,22 @@ const Container = styled.div`
 `;
 
 export default function Header() {
+  const { state } = useContext(AppContext);
+  // Detached HEADs show the commit instead
+  const branch =
+    state.repo && (state.repo.branch || state.repo.commit?.slice(0, 8));
   return (
     <Container>
       <Menu />
+      {state.identity && (
+        <Identity title="Sharing as">{state.identity}</Identity>
+      )}
+      {state.repo && (
+        <Branch title={state.repo.commit}>
+          {branch}
+          {state.repo.dirty && "*"}
+        </Branch>
+      )}
       <Tabs />
     </Container>
   );
@@ -13,10 +13,17 @@ export default function Root(_: {}) {
   const handleLogin = useCallback(
     (token) => {
       const proto = window.location.protocol === "https:" ? "wss" : "ws";
+      // Relay servers host one workspace per sharer, selected by the URL path
+      const workspace = decodeURIComponent(
+        window.location.pathname.replace(/^\/+|\/+$/g, "")
+      );
+      const query =
+        workspace === "" ? "" : `?workspace=${encodeURIComponent(workspace)}`;
       const c = new Client(
-        `${proto}://${window.location.host}/client_ws`,
+        `${proto}://${window.location.host}/client_ws${query}`,
         dispatch,
-        token
+        token,
+        workspace
       );
       setClient(c);
     },
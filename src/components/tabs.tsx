@@ -53,6 +53,12 @@ const Tabs = styled(TabsUnstyled)`
   height: 100%;
 `;
 
+const FolderLabel = styled("span")`
+  color: var(--comment);
+  font-weight: normal;
+  margin-right: 4px;
+`;
+
 export default function PublicTabPanel(_: {}) {
   const { state, dispatch } = useContext(AppContext);
   const files = useMemo(() => {
@@ -67,6 +73,7 @@ export default function PublicTabPanel(_: {}) {
       dispatch={dispatch}
       file_id={state.file_id}
       files={files}
+      folders={state.folders}
       viewFile={state.view?.file_id}
     />
   );
@@ -76,15 +83,17 @@ function TabPanel_({
   dispatch,
   files,
   file_id,
+  folders,
   viewFile,
 }: {
   dispatch: Dispatcher;
   files: File[];
   file_id?: number;
+  folders?: string[] | null;
   viewFile?: number;
 }) {
   const selectedEl = useRef<null | HTMLButtonElement>(null);
-  const shortnames = useShortFilenames(files);
+  const tabs = useFolderTabs(files, folders);
   useEffect(() => {
     if (selectedEl.current != null) {
       selectedEl.current.scrollIntoView();
@@ -110,7 +119,7 @@ function TabPanel_({
         }}
       >
         <TabsList>
-          {files.map((file) => {
+          {tabs.map(({ file, folder, shortname }) => {
             const sx =
               file.id === viewFile
                 ? {
@@ -120,18 +129,15 @@ function TabPanel_({
             return (
               <Tooltip
                 key={file.id}
-                title={
-                  file.filename === shortnames[file.filename]
-                    ? ""
-                    : file.filename
-                }
+                title={file.filename === shortname ? "" : file.filename}
               >
                 <Tab
                   value={file.id}
                   ref={file.id === file_id ? selectedEl : null}
                   sx={sx}
                 >
-                  {shortnames[file.filename]}
+                  {folder != null && <FolderLabel>{folder}</FolderLabel>}
+                  {shortname}
                 </Tab>
               </Tooltip>
             );
@@ -144,34 +150,78 @@ function TabPanel_({
 
 const TabPanel = React.memo(TabPanel_);
 
-function useShortFilenames(files: File[]): { [short: string]: string } {
+type FolderTab = {
+  file: File;
+  // The workspace folder the file is in, if there's more than one
+  folder: string | null;
+  shortname: string;
+};
+
+// In a multi-root workspace files are grouped by folder, in the editor's order
+function useFolderTabs(
+  files: File[],
+  folders?: string[] | null
+): FolderTab[] {
   return useMemo(() => {
-    const shortToLong: { [key: string]: string } = {};
-    function insert(short: string, long: string) {
-      if (shortToLong[short] != null) {
-        const existingLong = shortToLong[short];
-        const [s1, s2] = disambiguate(existingLong, long);
-        if (s2 != null) {
-          delete shortToLong[short];
-          insert(s1, existingLong);
-          insert(s2, long);
-        }
-      } else {
-        shortToLong[short] = long;
+    const groups: { folder: string | null; files: File[] }[] = [];
+    if (folders != null && folders.length > 0) {
+      for (const folder of folders) {
+        groups.push({ folder, files: [] });
       }
+      const other = { folder: null, files: [] as File[] };
+      for (const file of files) {
+        const group = groups.find(({ folder }) =>
+          file.filename.startsWith(folder + "/")
+        );
+        (group ?? other).files.push(file);
+      }
+      groups.push(other);
+    } else {
+      groups.push({ folder: null, files });
     }
-    for (const file of files) {
-      const { filename } = file;
-      const last_idx = filename.lastIndexOf("/");
-      const basename = filename.slice(last_idx + 1);
-      insert(basename, filename);
+    const ret: FolderTab[] = [];
+    for (const { folder, files } of groups) {
+      const prefixLen = folder == null ? 0 : folder.length + 1;
+      const shortnames = shortFilenames(
+        files.map(({ filename }) => filename.slice(prefixLen))
+      );
+      for (const file of files) {
+        ret.push({
+          file,
+          folder,
+          shortname: shortnames[file.filename.slice(prefixLen)],
+        });
+      }
     }
-    const longToShort: { [key: string]: string } = {};
-    for (const shortName in shortToLong) {
-      longToShort[shortToLong[shortName]] = shortName;
+    return ret;
+  }, [files, folders]);
+}
+
+function shortFilenames(filenames: string[]): { [long: string]: string } {
+  const shortToLong: { [key: string]: string } = {};
+  function insert(short: string, long: string) {
+    if (shortToLong[short] != null) {
+      const existingLong = shortToLong[short];
+      const [s1, s2] = disambiguate(existingLong, long);
+      if (s2 != null) {
+        delete shortToLong[short];
+        insert(s1, existingLong);
+        insert(s2, long);
+      }
+    } else {
+      shortToLong[short] = long;
     }
-    return longToShort;
-  }, [files]);
+  }
+  for (const filename of filenames) {
+    const last_idx = filename.lastIndexOf("/");
+    const basename = filename.slice(last_idx + 1);
+    insert(basename, filename);
+  }
+  const longToShort: { [key: string]: string } = {};
+  for (const shortName in shortToLong) {
+    longToShort[shortToLong[shortName]] = shortName;
+  }
+  return longToShort;
 }
 
 function disambiguate(f1: string, f2: string): [string, string | null] {
@@ -0,0 +1,108 @@
+// End-to-end encryption for relay servers. The sharer puts the key in the URL
+// fragment (#k=...), which the browser never sends to the server.
+
+function base64ToBytes(data: string): Uint8Array {
+  const normalized = data.replace(/-/g, "+").replace(/_/g, "/");
+  const binary = window.atob(normalized);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes;
+}
+
+export function getKeyFromHash(hash: string): string | null {
+  const params = new URLSearchParams(hash.replace(/^#/, ""));
+  return params.get("k");
+}
+
+export function importKey(key: string): Promise<CryptoKey> {
+  return window.crypto.subtle.importKey(
+    "raw",
+    base64ToBytes(key),
+    "AES-GCM",
+    false,
+    ["decrypt"]
+  );
+}
+
+export type E2EMessage = {
+  method: string;
+  params: any;
+};
+
+export const SNAPSHOT = "s".charCodeAt(0);
+export const EVENT = "e".charCodeAt(0);
+// Kind (1 byte) and sequence number (8 bytes, big endian)
+const HEADER_SIZE = 9;
+const NONCE_SIZE = 12;
+
+export type E2EBlob = {
+  kind: number;
+  seq: number;
+  message: E2EMessage;
+};
+
+// Data is base64(kind || seq || nonce || ciphertext) encrypted with
+// AES-256-GCM. The header and the workspace name are authenticated with the
+// ciphertext, so the relay can't move blobs to another workspace or change
+// their order without the decryption failing or the sequence check catching
+// it.
+export async function decrypt(
+  key: CryptoKey,
+  workspace: string,
+  data: string
+): Promise<E2EBlob> {
+  const bytes = base64ToBytes(data);
+  if (bytes.length < HEADER_SIZE + NONCE_SIZE) {
+    throw new Error("Encrypted message is too short");
+  }
+  const header = bytes.slice(0, HEADER_SIZE);
+  const name = new TextEncoder().encode(workspace);
+  const additionalData = new Uint8Array(header.length + name.length);
+  additionalData.set(header);
+  additionalData.set(name, header.length);
+  const plaintext = await window.crypto.subtle.decrypt(
+    {
+      name: "AES-GCM",
+      iv: bytes.slice(HEADER_SIZE, HEADER_SIZE + NONCE_SIZE),
+      additionalData,
+    },
+    key,
+    bytes.slice(HEADER_SIZE + NONCE_SIZE)
+  );
+  const view = new DataView(header.buffer);
+  return {
+    kind: header[0],
+    seq: view.getUint32(1) * 2 ** 32 + view.getUint32(5),
+    message: JSON.parse(new TextDecoder().decode(plaintext)),
+  };
+}
+
+// Checks that every blob arrives exactly once and in order. Events are
+// numbered consecutively, and a snapshot has the number of the event after
+// it. A snapshot may skip ahead (e.g. after reconnecting), but never go back.
+export class E2ESequence {
+  private next: number | null = null;
+
+  check(kind: number, seq: number) {
+    if (kind === SNAPSHOT) {
+      if (this.next != null && seq < this.next) {
+        throw new Error(
+          `Encrypted snapshot ${seq} is older than event ${this.next}`
+        );
+      }
+      this.next = seq;
+    } else if (kind === EVENT) {
+      if (this.next == null) {
+        throw new Error("Encrypted event before the snapshot");
+      }
+      if (seq !== this.next) {
+        throw new Error(`Expected encrypted event ${this.next}, got ${seq}`);
+      }
+      this.next = seq + 1;
+    } else {
+      throw new Error(`Unknown kind of encrypted message ${kind}`);
+    }
+  }
+}
@@ -2,6 +2,7 @@ import * as React from "react";
 import { Dispatcher, showToast } from "./state";
 import BaseClient from "./base_client";
 import RTCRPC from "./rtc_rpc";
+import { get } from "./api";
 const { useState, useEffect } = React;
 
 export type ClientState =
@@ -81,7 +82,7 @@ export default class RTCClient extends BaseClient {
     this.setState("connecting");
     try {
       await this.rtc.createLocalOffer();
-      await this.rtc.waitForIceComplete();
+      await this.rtc.waitForCandidates();
     } catch (e) {
       this.setState("failed");
       throw e;
@@ -103,10 +104,19 @@ export default class RTCClient extends BaseClient {
       return Promise.reject("Token is empty");
     }
     this.setState("connecting");
-    return this.rtc.connect(token).then(null, (e) => {
-      this.setState("no_session");
-      return e;
-    });
+    // The signal server decides which STUN/TURN servers to use
+    return get<RTCIceServer[]>(
+      "ice_servers?token=" + encodeURIComponent(token)
+    )
+      .then(
+        (iceServers) => this.rtc.setIceServers(iceServers),
+        (e) => console.warn("Could not fetch ICE servers", e)
+      )
+      .then(() => this.rtc.connect(token))
+      .then(null, (e) => {
+        this.setState("no_session");
+        return e;
+      });
   }
 
   useStatus(): ClientState {
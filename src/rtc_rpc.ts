@@ -89,6 +89,13 @@ export default class RTCRPC implements JsonRPC {
     });
   }
 
+  setIceServers(iceServers: RTCIceServer[]) {
+    this.conn.setConfiguration({
+      ...this.conn.getConfiguration(),
+      iceServers,
+    });
+  }
+
   async createLocalOffer(): Promise<void> {
     const localOffer = await this.conn.createOffer();
     await this.conn.setLocalDescription(localOffer);
@@ -104,6 +111,8 @@ export default class RTCRPC implements JsonRPC {
     }>("call", {
       token,
       offer: localOffer,
+      // Shown to the editor in its list of viewers
+      name: new URLSearchParams(window.location.search).get("name") ?? "",
     });
     this.clientId = response.client_id;
     for (const candidate of this.pendingCandidates) {
@@ -116,6 +125,36 @@ export default class RTCRPC implements JsonRPC {
     this.pendingCandidates.length = 0;
     const sd = new RTCSessionDescription(response.answer);
     await this.conn.setRemoteDescription(sd);
+    this.pollCandidates();
+  }
+
+  // Receive the editor's ICE candidates as it gathers them
+  private async pollCandidates(): Promise<void> {
+    const finished = () => {
+      const state = this.conn.connectionState;
+      return state === "connected" || state === "failed" || state === "closed";
+    };
+    while (!finished()) {
+      let response;
+      try {
+        response = await post<{
+          candidates: RTCIceCandidateInit[];
+          done: boolean;
+        }>("ice_candidates", {
+          token: this.token,
+          client_id: this.clientId,
+        });
+      } catch (e) {
+        console.error("Error fetching ICE candidates", e);
+        return;
+      }
+      for (const candidate of response.candidates) {
+        await this.conn.addIceCandidate(candidate);
+      }
+      if (response.done) {
+        return;
+      }
+    }
   }
 
   onConnectionStateChange(
@@ -141,21 +180,56 @@ export default class RTCRPC implements JsonRPC {
     );
   }
 
-  waitForIceComplete(): Promise<void> {
+  // Wait until we have enough ICE candidates to include them in the session
+  // description. If a TURN server is configured we stop at the first relay
+  // candidate, since that works from any network. Otherwise we stop at the
+  // first server reflexive candidate. Matches util.GatherCandidates.
+  waitForCandidates(timeout: number = 10000): Promise<void> {
+    const enough = this.hasTURNServer() ? "relay" : "srflx";
+    if (
+      this.conn.iceGatheringState === "complete" ||
+      this.conn.localDescription?.sdp.includes(` typ ${enough}`)
+    ) {
+      return Promise.resolve();
+    }
     return new Promise((resolve, reject) => {
-      const cb = () => {
+      const cleanup = () => {
+        clearTimeout(tid);
+        this.conn.removeEventListener("icecandidate", onCandidate);
+        this.conn.removeEventListener("icegatheringstatechange", onState);
+      };
+      const onCandidate = (e: RTCPeerConnectionIceEvent) => {
+        if (e.candidate == null || e.candidate.type === enough) {
+          cleanup();
+          resolve();
+        }
+      };
+      const onState = () => {
         if (this.conn.iceGatheringState === "complete") {
+          cleanup();
           resolve();
-          this.conn.removeEventListener("icegatheringstatechange", cb);
         } else if (this.conn.iceGatheringState === "new") {
+          cleanup();
           reject("ICE gathering failed");
-          this.conn.removeEventListener("icegatheringstatechange", cb);
         }
       };
-      this.conn.addEventListener("icegatheringstatechange", cb);
+      const tid = setTimeout(() => {
+        cleanup();
+        resolve();
+      }, timeout);
+      this.conn.addEventListener("icecandidate", onCandidate);
+      this.conn.addEventListener("icegatheringstatechange", onState);
     });
   }
 
+  private hasTURNServer(): boolean {
+    return (this.conn.getConfiguration().iceServers ?? []).some((server) =>
+      (Array.isArray(server.urls) ? server.urls : [server.urls]).some(
+        (url) => url.startsWith("turn:") || url.startsWith("turns:")
+      )
+    );
+  }
+
   setAnswer(answer: RTCSessionDescriptionInit): Promise<void> {
     if (answer.type !== "answer") {
       return Promise.reject("Invalid RTC answer");
@@ -169,7 +243,9 @@ export default class RTCRPC implements JsonRPC {
     await this.conn.setRemoteDescription(offer);
     const answer = await this.conn.createAnswer();
     await this.conn.setLocalDescription(answer);
-    return answer;
+    // There's no way to trickle candidates through a copy/pasted token
+    await this.waitForCandidates();
+    return this.conn.localDescription?.toJSON() ?? answer;
   }
 
   request<T>(
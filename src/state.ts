@@ -50,9 +50,18 @@ export type FileMap = {
   [file_id: number]: File;
 };
 
+export type Repo = {
+  branch?: string;
+  commit?: string;
+  dirty: boolean;
+};
+
 export type SyncResponse = {
   files: File[];
   view?: View | null;
+  identity?: string;
+  repo?: Repo | null;
+  folders?: string[] | null;
 };
 
 export type AlertWrapper = {
@@ -63,6 +72,11 @@ export type AlertWrapper = {
 
 export type AppState = {
   file_id?: number;
+  identity?: string;
+  repo?: Repo | null;
+  // Set if the workspace has more than one root. Filenames start with the
+  // name of their folder.
+  folders?: string[] | null;
   colorscheme: ColorScheme;
   view?: View | null;
   follow: boolean;
@@ -100,6 +114,14 @@ export type AppAction =
       file_id: number;
       changes: ChangeTextRange[];
     }
+  | {
+      type: "updateRepo";
+      repo: Repo | null;
+    }
+  | {
+      type: "updateFolders";
+      folders: string[] | null;
+    }
   // User actions
   | {
       type: "toggleFollow";
@@ -138,6 +160,9 @@ export function reducer(state: AppState, action: AppAction): AppState {
         files,
         file_id: action.sync.view?.file_id ?? action.sync.files[0]?.id,
         view: action.sync.view,
+        identity: action.sync.identity,
+        repo: action.sync.repo,
+        folders: action.sync.folders,
       };
     }
     case "openFile":
@@ -207,6 +232,16 @@ export function reducer(state: AppState, action: AppAction): AppState {
           view: action.view,
         };
       }
+    case "updateRepo":
+      return {
+        ...state,
+        repo: action.repo,
+      };
+    case "updateFolders":
+      return {
+        ...state,
+        folders: action.folders,
+      };
     case "selectFile":
       return {
         ...state,
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"pair-ls/diff"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Lines of context around each change in a diff
+const diffContext = 3
+
+// DiffRequest asks how files changed during the session
+type DiffRequest struct {
+	// Only diff this file. All open files are diffed if empty.
+	Filename string `json:"filename,omitempty"`
+}
+
+// FileDiff is how a file changed since its baseline
+type FileDiff struct {
+	Filename string `json:"filename"`
+	FileID   int32  `json:"file_id"`
+	// Unified diff
+	Diff    string `json:"diff"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+type DiffResult struct {
+	// Only files that changed
+	Files []FileDiff `json:"files"`
+	// Totals for the whole workspace
+	Changed int `json:"changed"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// SetBaseline sets the text that a file is diffed against, e.g. the version
+// committed in git. Otherwise it's the text the file had when it was first
+// opened.
+func (s *WorkspaceState) SetBaseline(filename string, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baselines[filename] = SplitLines(text)
+}
+
+// HasBaseline is true if a file has been opened or has had its baseline set
+func (s *WorkspaceState) HasBaseline(filename string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.baselines[filename]
+	return ok
+}
+
+// GetDiff compares open files to their baselines
+func (s *WorkspaceState) GetDiff(req DiffRequest) DiffResult {
+	type pending struct {
+		file     File
+		baseline []string
+	}
+	s.mu.Lock()
+	files := make([]pending, 0, len(s.files))
+	for _, f := range s.files {
+		if req.Filename != "" && f.Filename != req.Filename {
+			continue
+		}
+		files = append(files, pending{
+			file:     File{Filename: f.Filename, ID: f.ID, text: f.text},
+			baseline: s.baselines[f.Filename],
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].file.Filename < files[j].file.Filename
+	})
+
+	result := DiffResult{Files: make([]FileDiff, 0)}
+	for _, p := range files {
+		lines := diff.Lines(trimFinalNewline(p.baseline), trimFinalNewline(p.file.text.Strings()))
+		fileDiff := FileDiff{
+			Filename: p.file.Filename,
+			FileID:   p.file.ID,
+		}
+		for _, line := range lines {
+			switch line.Kind {
+			case diff.Insert:
+				fileDiff.Added++
+			case diff.Delete:
+				fileDiff.Removed++
+			}
+		}
+		if fileDiff.Added == 0 && fileDiff.Removed == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(filepath.ToSlash(p.file.Filename), "/")
+		fileDiff.Diff = diff.Unified("a/"+name, "b/"+name, lines, diffContext)
+		result.Files = append(result.Files, fileDiff)
+		result.Changed++
+		result.Added += fileDiff.Added
+		result.Removed += fileDiff.Removed
+	}
+	return result
+}
+
+// A file that ends with a newline splits into a last line that is empty. Diff
+// tools don't count it as a line.
+func trimFinalNewline(lines []string) []string {
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	return lines
+}
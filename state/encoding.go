@@ -0,0 +1,158 @@
+package state
+
+import (
+	"unicode/utf8"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// PositionEncoding is what the character offsets of positions count (LSP 3.17
+// positionEncoding)
+type PositionEncoding string
+
+const (
+	// Bytes of UTF-8
+	PositionEncodingUTF8 PositionEncoding = "utf-8"
+	// UTF-16 code units. This is the LSP default, and what JavaScript strings
+	// count.
+	PositionEncodingUTF16 PositionEncoding = "utf-16"
+	// Unicode code points
+	PositionEncodingUTF32 PositionEncoding = "utf-32"
+)
+
+// The state keeps positions (edit history, proposals) in UTF-16, and so do
+// the requests and results exchanged with viewers: edits, proposals, focus
+// requests, search results, and hover and definition requests.
+const StateEncoding = PositionEncodingUTF16
+
+// ViewEncoding is used for the cursors in View. Viewers draw them as columns,
+// which code points are closest to.
+const ViewEncoding = PositionEncodingUTF32
+
+// Supported is true for the encodings that can be converted
+func (e PositionEncoding) Supported() bool {
+	return e == PositionEncodingUTF8 || e == PositionEncodingUTF16 || e == PositionEncodingUTF32
+}
+
+// ByteOffset converts a character offset in this encoding to a byte offset in
+// the line. Like LSP, offsets past the end of the line mean the end of the
+// line. An offset in the middle of a character rounds down.
+func (e PositionEncoding) ByteOffset(line string, character int) int {
+	if character <= 0 {
+		return 0
+	}
+	if e == PositionEncodingUTF8 {
+		if character >= len(line) {
+			return len(line)
+		}
+		for character > 0 && !utf8.RuneStart(line[character]) {
+			character--
+		}
+		return character
+	}
+	units := 0
+	for i, r := range line {
+		size := e.runeUnits(r)
+		if units+size > character {
+			return i
+		}
+		units += size
+	}
+	return len(line)
+}
+
+// Character converts a byte offset in the line to a character offset in this
+// encoding
+func (e PositionEncoding) Character(line string, offset int) int {
+	if offset > len(line) {
+		offset = len(line)
+	} else if offset < 0 {
+		offset = 0
+	}
+	if e == PositionEncodingUTF8 {
+		return offset
+	}
+	units := 0
+	for _, r := range line[:offset] {
+		units += e.runeUnits(r)
+	}
+	return units
+}
+
+// Len is the length of the line in this encoding
+func (e PositionEncoding) Len(line string) int {
+	return e.Character(line, len(line))
+}
+
+func (e PositionEncoding) runeUnits(r rune) int {
+	if e == PositionEncodingUTF16 && r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// ConvertCharacter converts a character offset in a line between encodings
+func ConvertCharacter(line string, character int, from PositionEncoding, to PositionEncoding) int {
+	if from == to {
+		return character
+	}
+	return to.Character(line, from.ByteOffset(line, character))
+}
+
+// convertPosition converts a position in the text between encodings. Lines
+// that don't exist are left alone.
+func convertPosition(text Rope, pos lsp.Position, from PositionEncoding, to PositionEncoding) lsp.Position {
+	if from == to || pos.Line < 0 || pos.Line >= text.Len() {
+		return pos
+	}
+	return lsp.Position{
+		Line:      pos.Line,
+		Character: ConvertCharacter(text.Line(pos.Line), pos.Character, from, to),
+	}
+}
+
+func convertRange(text Rope, rng lsp.Range, from PositionEncoding, to PositionEncoding) lsp.Range {
+	return lsp.Range{
+		Start: convertPosition(text, rng.Start, from, to),
+		End:   convertPosition(text, rng.End, from, to),
+	}
+}
+
+// SetPositionEncoding sets the encoding of the positions the editor sends and
+// receives
+func (s *WorkspaceState) SetPositionEncoding(encoding PositionEncoding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoding = encoding
+}
+
+func (s *WorkspaceState) editorEncoding() PositionEncoding {
+	if s.encoding == "" {
+		return PositionEncodingUTF16
+	}
+	return s.encoding
+}
+
+// ToEditor converts a range in a file from StateEncoding to the editor's
+// encoding, using the file's current text
+func (s *WorkspaceState) ToEditor(filename string, rng lsp.Range) lsp.Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file := s.files[filename]
+	if file == nil {
+		return rng
+	}
+	return convertRange(file.text, rng, StateEncoding, s.editorEncoding())
+}
+
+// FromEditor converts a range in a file from the editor's encoding to
+// StateEncoding, using the file's current text
+func (s *WorkspaceState) FromEditor(filename string, rng lsp.Range) lsp.Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file := s.files[filename]
+	if file == nil {
+		return rng
+	}
+	return convertRange(file.text, rng, s.editorEncoding(), StateEncoding)
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// One, two, four, and one bytes of UTF-8. The emoji is a surrogate pair in
+// UTF-16.
+const mixedLine = "aé😀b"
+
+func TestByteOffset(t *testing.T) {
+	tests := []struct {
+		name      string
+		encoding  PositionEncoding
+		line      string
+		character int
+		want      int
+	}{
+		{"utf-16 start", PositionEncodingUTF16, mixedLine, 0, 0},
+		{"utf-16 after ascii", PositionEncodingUTF16, mixedLine, 1, 1},
+		{"utf-16 after two byte character", PositionEncodingUTF16, mixedLine, 2, 3},
+		{"utf-16 middle of surrogate pair", PositionEncodingUTF16, mixedLine, 3, 3},
+		{"utf-16 after surrogate pair", PositionEncodingUTF16, mixedLine, 4, 7},
+		{"utf-16 end", PositionEncodingUTF16, mixedLine, 5, 8},
+		{"utf-16 past the end", PositionEncodingUTF16, mixedLine, 99, 8},
+		{"utf-16 negative", PositionEncodingUTF16, mixedLine, -1, 0},
+		{"utf-32 after two byte character", PositionEncodingUTF32, mixedLine, 2, 3},
+		{"utf-32 after astral character", PositionEncodingUTF32, mixedLine, 3, 7},
+		{"utf-32 end", PositionEncodingUTF32, mixedLine, 4, 8},
+		{"utf-32 past the end", PositionEncodingUTF32, mixedLine, 5, 8},
+		{"utf-8 middle of two byte character", PositionEncodingUTF8, mixedLine, 2, 1},
+		{"utf-8 middle of astral character", PositionEncodingUTF8, mixedLine, 5, 3},
+		{"utf-8 after astral character", PositionEncodingUTF8, mixedLine, 7, 7},
+		{"utf-8 past the end", PositionEncodingUTF8, mixedLine, 100, 8},
+		{"utf-8 negative", PositionEncodingUTF8, mixedLine, -5, 0},
+		{"utf-16 CJK", PositionEncodingUTF16, "日本語", 2, 6},
+		{"utf-8 middle of CJK", PositionEncodingUTF8, "日本語", 4, 3},
+		{"empty line", PositionEncodingUTF16, "", 3, 0},
+	}
+	for _, test := range tests {
+		if got := test.encoding.ByteOffset(test.line, test.character); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCharacter(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding PositionEncoding
+		line     string
+		offset   int
+		want     int
+	}{
+		{"utf-16 start", PositionEncodingUTF16, mixedLine, 0, 0},
+		{"utf-16 after two byte character", PositionEncodingUTF16, mixedLine, 3, 2},
+		{"utf-16 after surrogate pair", PositionEncodingUTF16, mixedLine, 7, 4},
+		{"utf-16 end", PositionEncodingUTF16, mixedLine, 8, 5},
+		{"utf-16 past the end", PositionEncodingUTF16, mixedLine, 100, 5},
+		{"utf-16 negative", PositionEncodingUTF16, mixedLine, -1, 0},
+		{"utf-32 after astral character", PositionEncodingUTF32, mixedLine, 7, 3},
+		{"utf-32 end", PositionEncodingUTF32, mixedLine, 8, 4},
+		{"utf-8 after astral character", PositionEncodingUTF8, mixedLine, 7, 7},
+		{"utf-8 past the end", PositionEncodingUTF8, mixedLine, 100, 8},
+		{"utf-8 negative", PositionEncodingUTF8, mixedLine, -1, 0},
+		{"utf-16 CJK", PositionEncodingUTF16, "日本語", 6, 2},
+		{"utf-32 two surrogate pairs", PositionEncodingUTF32, "😀😀", 8, 2},
+		{"utf-16 two surrogate pairs", PositionEncodingUTF16, "😀😀", 8, 4},
+	}
+	for _, test := range tests {
+		if got := test.encoding.Character(test.line, test.offset); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConvertRange(t *testing.T) {
+	// CRLF line endings aren't part of the lines
+	text := NewRope(SplitLines("a😀b\r\n日本語\r\nend"))
+	rng := func(startLine, startChar, endLine, endChar int) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: startLine, Character: startChar},
+			End:   lsp.Position{Line: endLine, Character: endChar},
+		}
+	}
+	tests := []struct {
+		name string
+		rng  lsp.Range
+		from PositionEncoding
+		to   PositionEncoding
+		want lsp.Range
+	}{
+		{"utf-16 to utf-32", rng(0, 3, 1, 2), PositionEncodingUTF16, PositionEncodingUTF32, rng(0, 2, 1, 2)},
+		{"utf-16 to utf-8", rng(0, 3, 1, 2), PositionEncodingUTF16, PositionEncodingUTF8, rng(0, 5, 1, 6)},
+		{"utf-8 to utf-16", rng(0, 5, 0, 6), PositionEncodingUTF8, PositionEncodingUTF16, rng(0, 3, 0, 4)},
+		{"utf-32 to utf-16", rng(0, 1, 0, 2), PositionEncodingUTF32, PositionEncodingUTF16, rng(0, 1, 0, 3)},
+		{"middle of surrogate pair", rng(0, 2, 0, 2), PositionEncodingUTF16, PositionEncodingUTF32, rng(0, 1, 0, 1)},
+		{"end of CRLF line", rng(0, 4, 1, 3), PositionEncodingUTF16, PositionEncodingUTF8, rng(0, 6, 1, 9)},
+		{"past the end of the line", rng(0, 99, 2, 99), PositionEncodingUTF16, PositionEncodingUTF8, rng(0, 6, 2, 3)},
+		{"lines that don't exist", rng(-1, 5, 3, 5), PositionEncodingUTF16, PositionEncodingUTF8, rng(-1, 5, 3, 5)},
+		{"same encoding", rng(0, 99, 7, 1), PositionEncodingUTF16, PositionEncodingUTF16, rng(0, 99, 7, 1)},
+	}
+	for _, test := range tests {
+		if got := convertRange(text, test.rng, test.from, test.to); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"errors"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// FocusStatus is what happened to a FocusRequest
+type FocusStatus string
+
+const (
+	// The editor jumped to the location
+	FocusShown FocusStatus = "shown"
+	// The sharer saw the request, but their editor can't jump to it
+	FocusAcknowledged FocusStatus = "acknowledged"
+	// The sharer turned down the request, or didn't answer it
+	FocusDeclined FocusStatus = "declined"
+	// The editor couldn't show the location
+	FocusFailed FocusStatus = "failed"
+)
+
+// FocusRequest is a viewer asking the sharer to look at part of a file
+type FocusRequest struct {
+	Filename string    `json:"filename"`
+	Range    lsp.Range `json:"range"`
+	// Optional note for the sharer
+	Message string `json:"message,omitempty"`
+}
+
+type FocusResult struct {
+	Status FocusStatus `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// FocusHandler shows a FocusRequest to the sharer
+type FocusHandler func(FocusRequest) (FocusResult, error)
+
+// SetFocusHandler allows viewers to send focus requests. Pass nil to disallow
+// them.
+func (s *WorkspaceState) SetFocusHandler(handler FocusHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.focusHandler = handler
+}
+
+// RequestFocus passes a request from a viewer to the focus handler
+func (s *WorkspaceState) RequestFocus(req FocusRequest) (FocusResult, error) {
+	s.mu.Lock()
+	handler := s.focusHandler
+	s.mu.Unlock()
+	if handler == nil {
+		return FocusResult{}, errors.New("Focus requests are not enabled")
+	}
+	return handler(req)
+}
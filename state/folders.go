@@ -0,0 +1,46 @@
+package state
+
+// FoldersEvent is sent when the editor's workspace folders change. Filenames
+// in a multi-root workspace start with the name of their folder.
+type FoldersEvent struct {
+	Folders []string `json:"folders"`
+}
+
+// SetFolders sets the names of the workspace folders, in the order the editor
+// lists them. Pass nil if the workspace has a single root.
+func (s *WorkspaceState) SetFolders(folders []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if equalStrings(folders, s.folders) {
+		return
+	}
+	s.folders = append([]string(nil), folders...)
+	s.publish(FoldersEvent{
+		Folders: s.copyFolders(),
+	})
+}
+
+func (s *WorkspaceState) GetFolders() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copyFolders()
+}
+
+func (s *WorkspaceState) copyFolders() []string {
+	if len(s.folders) == 0 {
+		return nil
+	}
+	return append([]string(nil), s.folders...)
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
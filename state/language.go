@@ -0,0 +1,133 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// How many language results are cached for each version of a file
+const maxLanguageCache = 1000
+
+// Requests that are answered by the sharer's language servers
+const (
+	LanguageHover      = "hover"
+	LanguageDefinition = "definition"
+)
+
+// PositionRequest asks about a position in a file
+type PositionRequest struct {
+	Filename string       `json:"filename"`
+	Position lsp.Position `json:"position"`
+	// The version of the file that Position refers to. It is moved to the
+	// latest version if the sharer has changed the file since.
+	Version int `json:"version"`
+}
+
+// Hover is the documentation for the symbol at a position
+type Hover struct {
+	// Markdown
+	Contents string     `json:"contents"`
+	Range    *lsp.Range `json:"range,omitempty"`
+}
+
+// Location is a range in a shared file
+type Location struct {
+	Filename string    `json:"filename"`
+	Range    lsp.Range `json:"range"`
+}
+
+// LanguageHandler asks the sharer's language servers about a file. The method
+// is LanguageHover or LanguageDefinition, and the result is a Hover or a list
+// of Locations.
+type LanguageHandler func(method string, req PositionRequest) (json.RawMessage, error)
+
+type languageKey struct {
+	method   string
+	position lsp.Position
+}
+
+// SetLanguageHandler allows viewers to send hover and definition requests.
+// Pass nil to disallow them.
+func (s *WorkspaceState) SetLanguageHandler(handler LanguageHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.languageHandler = handler
+}
+
+// Hover returns the documentation for the symbol at a position, or nil if
+// there is none
+func (s *WorkspaceState) Hover(req PositionRequest) (*Hover, error) {
+	var hover *Hover
+	if err := s.languageRequest(LanguageHover, req, &hover); err != nil {
+		return nil, err
+	}
+	return hover, nil
+}
+
+// Definition returns where the symbol at a position is defined
+func (s *WorkspaceState) Definition(req PositionRequest) ([]Location, error) {
+	locations := make([]Location, 0)
+	if err := s.languageRequest(LanguageDefinition, req, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// languageRequest passes a request to the language handler, unless the answer
+// for this version of the file is cached
+func (s *WorkspaceState) languageRequest(method string, req PositionRequest, result interface{}) error {
+	s.mu.Lock()
+	handler := s.languageHandler
+	if handler == nil {
+		s.mu.Unlock()
+		return errors.New("Language requests are not enabled")
+	}
+	file := s.files[req.Filename]
+	if file == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("File is not open: %s", req.Filename)
+	}
+	if req.Version != file.Version {
+		edits, _, err := s.rebaseEdits(EditRequest{
+			Filename: req.Filename,
+			Version:  req.Version,
+			Edits:    []lsp.TextEdit{{Range: lsp.Range{Start: req.Position, End: req.Position}}},
+		})
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		req.Position = edits[0].Range.Start
+		req.Version = file.Version
+	}
+	if file.languageCacheVersion != file.Version {
+		file.languageCache = nil
+		file.languageCacheVersion = file.Version
+	}
+	key := languageKey{method: method, position: req.Position}
+	data, ok := file.languageCache[key]
+	s.mu.Unlock()
+
+	if !ok {
+		var err error
+		if data, err = handler(method, req); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		// Don't cache answers for a version that is already out of date
+		if s.files[req.Filename] == file && file.Version == req.Version {
+			if file.languageCache == nil || len(file.languageCache) >= maxLanguageCache {
+				file.languageCache = make(map[languageKey]json.RawMessage)
+			}
+			file.languageCache[key] = data
+		}
+		s.mu.Unlock()
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,236 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// How many versions of each file we remember for transforming edits from
+// viewers. Edits based on an older version are rejected.
+const maxEditHistory = 500
+
+// ErrEditConflict is returned when an edit overlaps text that the sharer
+// changed after the version the edit was based on
+var ErrEditConflict = errors.New("Edit conflicts with a newer change")
+
+// The sequential single-range changes that took a file from one version to
+// the next. Ranges use UTF-16 offsets, like LSP.
+type versionEdits struct {
+	version int
+	edits   []lsp.TextEdit
+}
+
+// EditRequest is a change to a file from a viewer. Like the edits of an LSP
+// TextDocumentEdit, all edits are relative to the given version of the file
+// and must not overlap.
+type EditRequest struct {
+	Filename string         `json:"filename"`
+	Version  int            `json:"version"`
+	Edits    []lsp.TextEdit `json:"edits"`
+}
+
+type EditResult struct {
+	Applied bool `json:"applied"`
+	// Why the edit was not applied
+	Reason string `json:"reason,omitempty"`
+}
+
+// EditHandler applies edits from viewers to the sharer's buffer
+type EditHandler func(EditRequest) (EditResult, error)
+
+// SetEditHandler enables collaborative editing. Pass nil to disable it.
+func (s *WorkspaceState) SetEditHandler(handler EditHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.editHandler = handler
+}
+
+// SubmitEdit passes an edit from a viewer to the edit handler
+func (s *WorkspaceState) SubmitEdit(req EditRequest) (EditResult, error) {
+	s.mu.Lock()
+	handler := s.editHandler
+	s.mu.Unlock()
+	if handler == nil {
+		return EditResult{}, errors.New("Editing is not enabled")
+	}
+	return handler(req)
+}
+
+// TextChanged returns a channel that is closed the next time the text of any
+// file changes
+func (s *WorkspaceState) TextChanged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.textChanged
+}
+
+// notifyTextChanged wakes up everyone waiting on TextChanged. The lock must
+// be held.
+func (s *WorkspaceState) notifyTextChanged() {
+	close(s.textChanged)
+	s.textChanged = make(chan struct{})
+}
+
+// TransformEdits rebases an edit onto the current version of the file. It
+// returns the edits in the editor's encoding (sorted from the end of the file
+// to the start) and the version they apply to.
+func (s *WorkspaceState) TransformEdits(req EditRequest) ([]lsp.TextEdit, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	edits, version, err := s.rebaseEdits(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Convert with the text the edits apply to, before the editor changes it
+	text := s.files[req.Filename].text
+	for i := range edits {
+		edits[i].Range = convertRange(text, edits[i].Range, StateEncoding, s.editorEncoding())
+	}
+	return edits, version, nil
+}
+
+func (s *WorkspaceState) rebaseEdits(req EditRequest) ([]lsp.TextEdit, int, error) {
+	file := s.files[req.Filename]
+	if file == nil {
+		return nil, 0, fmt.Errorf("File is not open: %s", req.Filename)
+	}
+	if req.Version > file.Version {
+		return nil, 0, fmt.Errorf("Unknown version %d of %s", req.Version, req.Filename)
+	}
+	if req.Version < file.historyStart {
+		return nil, 0, fmt.Errorf("Version %d of %s is too old", req.Version, req.Filename)
+	}
+	edits, err := sortEdits(req.Edits)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range file.history {
+		if entry.version <= req.Version {
+			continue
+		}
+		if edits, err = transformEdits(edits, entry.edits); err != nil {
+			return nil, 0, err
+		}
+	}
+	for _, edit := range edits {
+		if edit.Range.End.Line >= file.text.Len() {
+			return nil, 0, fmt.Errorf("Edit is outside of %s", req.Filename)
+		}
+	}
+	return edits, file.Version, nil
+}
+
+// recordEdits remembers the changes that produced a new version of a file
+func (f *File) recordEdits(version int, edits []lsp.TextEdit) {
+	f.history = append(f.history, versionEdits{version: version, edits: edits})
+	if len(f.history) > maxEditHistory {
+		f.historyStart = f.history[0].version
+		f.history = f.history[1:]
+	}
+	f.Version = version
+}
+
+// resetHistory is used when the whole file is replaced, since there's no way
+// to transform edits across that
+func (f *File) resetHistory(version int) {
+	f.history = nil
+	f.historyStart = version
+	f.Version = version
+}
+
+// sortEdits orders non-overlapping edits from the end of the file to the
+// start. Applying them in that order one at a time gives the same result as
+// applying them all at once.
+func sortEdits(edits []lsp.TextEdit) ([]lsp.TextEdit, error) {
+	sorted := make([]lsp.TextEdit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return comparePositions(sorted[i].Range.Start, sorted[j].Range.Start) > 0
+	})
+	for i, edit := range sorted {
+		if comparePositions(edit.Range.Start, edit.Range.End) > 0 {
+			return nil, errors.New("Edit range ends before it starts")
+		}
+		if i > 0 && comparePositions(edit.Range.End, sorted[i-1].Range.Start) > 0 {
+			return nil, errors.New("Edits overlap")
+		}
+	}
+	return sorted, nil
+}
+
+// transformEdits rebases sequential edits from a viewer onto sequential edits
+// that the sharer already made. When both insert at the same position, the
+// sharer's text goes first.
+func transformEdits(edits []lsp.TextEdit, applied []lsp.TextEdit) ([]lsp.TextEdit, error) {
+	applied = append([]lsp.TextEdit{}, applied...)
+	ret := make([]lsp.TextEdit, 0, len(edits))
+	for _, edit := range edits {
+		for i, other := range applied {
+			newEdit, err := transformEdit(edit, other, true)
+			if err != nil {
+				return nil, err
+			}
+			newOther, err := transformEdit(other, edit, false)
+			if err != nil {
+				return nil, err
+			}
+			edit, applied[i] = newEdit, newOther
+		}
+		ret = append(ret, edit)
+	}
+	return ret, nil
+}
+
+// transformEdit moves edit so that it applies after other. afterOnTie decides
+// the order of two inserts at the same position.
+func transformEdit(edit lsp.TextEdit, other lsp.TextEdit, afterOnTie bool) (lsp.TextEdit, error) {
+	isInsert := edit.Range.Start == edit.Range.End
+	otherIsInsert := other.Range.Start == other.Range.End
+	if isInsert && otherIsInsert && edit.Range.Start == other.Range.Start {
+		if !afterOnTie {
+			return edit, nil
+		}
+	} else if comparePositions(edit.Range.End, other.Range.Start) <= 0 {
+		return edit, nil
+	} else if comparePositions(edit.Range.Start, other.Range.End) < 0 {
+		return edit, ErrEditConflict
+	}
+	edit.Range = lsp.Range{
+		Start: transformPosition(edit.Range.Start, other),
+		End:   transformPosition(edit.Range.End, other),
+	}
+	return edit, nil
+}
+
+// transformPosition moves a position at or after the end of other to where it
+// is after other is applied
+func transformPosition(pos lsp.Position, other lsp.TextEdit) lsp.Position {
+	lines := SplitLines(other.NewText)
+	newEnd := lsp.Position{
+		Line:      other.Range.Start.Line + len(lines) - 1,
+		Character: StateEncoding.Len(lines[len(lines)-1]),
+	}
+	if len(lines) == 1 {
+		newEnd.Character += other.Range.Start.Character
+	}
+	if pos.Line == other.Range.End.Line {
+		return lsp.Position{
+			Line:      newEnd.Line,
+			Character: newEnd.Character + pos.Character - other.Range.End.Character,
+		}
+	}
+	return lsp.Position{
+		Line:      pos.Line + newEnd.Line - other.Range.End.Line,
+		Character: pos.Character,
+	}
+}
+
+func comparePositions(a lsp.Position, b lsp.Position) int {
+	if a.Line != b.Line {
+		return a.Line - b.Line
+	}
+	return a.Character - b.Character
+}
@@ -0,0 +1,308 @@
+package state
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+func pos(line int, character int) lsp.Position {
+	return lsp.Position{Line: line, Character: character}
+}
+
+func textEdit(startLine int, startChar int, endLine int, endChar int, text string) lsp.TextEdit {
+	return lsp.TextEdit{
+		Range:   lsp.Range{Start: pos(startLine, startChar), End: pos(endLine, endChar)},
+		NewText: text,
+	}
+}
+
+// applyEdits applies sequential edits to text
+func applyEdits(text string, edits []lsp.TextEdit) string {
+	changes := make([]lsp.TextDocumentContentChangeEvent, 0, len(edits))
+	for _, edit := range edits {
+		rng := edit.Range
+		changes = append(changes, lsp.TextDocumentContentChangeEvent{Range: &rng, Text: edit.NewText})
+	}
+	rope, _, _ := applyTextChanges(NewRope(SplitLines(text)), changes, StateEncoding)
+	return strings.Join(rope.Strings(), "\n")
+}
+
+func TestTransformPosition(t *testing.T) {
+	tests := []struct {
+		name  string
+		pos   lsp.Position
+		other lsp.TextEdit
+		want  lsp.Position
+	}{
+		{"insert before on the same line", pos(0, 5), textEdit(0, 2, 0, 2, "abc"), pos(0, 8)},
+		{"delete before on the same line", pos(0, 5), textEdit(0, 1, 0, 3, ""), pos(0, 3)},
+		{"insert lines above", pos(3, 4), textEdit(1, 0, 1, 0, "a\nb\n"), pos(5, 4)},
+		{"delete lines above", pos(3, 4), textEdit(0, 2, 2, 0, ""), pos(1, 4)},
+		{"multi-line insert before on the same line", pos(0, 5), textEdit(0, 2, 0, 2, "ab\ncd"), pos(1, 5)},
+		{"multi-line delete ending on the same line", pos(2, 6), textEdit(0, 3, 2, 4, "x"), pos(0, 6)},
+		{"at the end of a replacement", pos(1, 2), textEdit(0, 1, 1, 2, "yz"), pos(0, 3)},
+		{"surrogate pairs count twice", pos(0, 3), textEdit(0, 1, 0, 1, "😀"), pos(0, 5)},
+	}
+	for _, test := range tests {
+		if got := transformPosition(test.pos, test.other); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTransformEdit(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       lsp.TextEdit
+		other      lsp.TextEdit
+		afterOnTie bool
+		want       lsp.TextEdit
+		conflict   bool
+	}{
+		{
+			name:       "inserts at the same position, edit goes after",
+			edit:       textEdit(0, 3, 0, 3, "v"),
+			other:      textEdit(0, 3, 0, 3, "sh"),
+			afterOnTie: true,
+			want:       textEdit(0, 5, 0, 5, "v"),
+		},
+		{
+			name:  "inserts at the same position, edit goes first",
+			edit:  textEdit(0, 3, 0, 3, "v"),
+			other: textEdit(0, 3, 0, 3, "sh"),
+			want:  textEdit(0, 3, 0, 3, "v"),
+		},
+		{
+			name:  "edit before other",
+			edit:  textEdit(0, 0, 0, 2, "x"),
+			other: textEdit(0, 4, 0, 6, "y"),
+			want:  textEdit(0, 0, 0, 2, "x"),
+		},
+		{
+			name:  "edit ending where other starts",
+			edit:  textEdit(0, 0, 0, 4, "x"),
+			other: textEdit(0, 4, 0, 6, "y"),
+			want:  textEdit(0, 0, 0, 4, "x"),
+		},
+		{
+			name:  "edit after other on the same line",
+			edit:  textEdit(0, 8, 0, 9, "x"),
+			other: textEdit(0, 2, 0, 4, "abcd"),
+			want:  textEdit(0, 10, 0, 11, "x"),
+		},
+		{
+			name:     "overlapping deletes",
+			edit:     textEdit(0, 2, 0, 6, ""),
+			other:    textEdit(0, 4, 0, 8, ""),
+			conflict: true,
+		},
+		{
+			name:     "delete containing other",
+			edit:     textEdit(0, 0, 2, 0, ""),
+			other:    textEdit(1, 0, 1, 3, ""),
+			conflict: true,
+		},
+		{
+			name:     "insert inside a deleted range",
+			edit:     textEdit(0, 5, 0, 5, "x"),
+			other:    textEdit(0, 2, 0, 8, ""),
+			conflict: true,
+		},
+		{
+			name:  "insert at the start of a deleted range",
+			edit:  textEdit(0, 2, 0, 2, "x"),
+			other: textEdit(0, 2, 0, 8, ""),
+			want:  textEdit(0, 2, 0, 2, "x"),
+		},
+		{
+			name:  "insert at the end of a deleted range",
+			edit:  textEdit(0, 8, 0, 8, "x"),
+			other: textEdit(0, 2, 0, 8, ""),
+			want:  textEdit(0, 2, 0, 2, "x"),
+		},
+		{
+			name:  "multi-line edit after multi-line insert",
+			edit:  textEdit(2, 3, 4, 1, "a\nb"),
+			other: textEdit(1, 0, 1, 0, "one\ntwo\n"),
+			want:  textEdit(4, 3, 6, 1, "a\nb"),
+		},
+		{
+			name:  "edit starting on the last line of a multi-line delete",
+			edit:  textEdit(3, 5, 4, 0, ""),
+			other: textEdit(1, 2, 3, 4, ""),
+			want:  textEdit(1, 3, 2, 0, ""),
+		},
+	}
+	for _, test := range tests {
+		got, err := transformEdit(test.edit, test.other, test.afterOnTie)
+		if test.conflict {
+			if err != ErrEditConflict {
+				t.Errorf("%s: got %v, %v, want a conflict", test.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTransformEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// Made by the sharer first, one after another
+		applied []lsp.TextEdit
+		// Made by a viewer to the same text, sorted from the end to the start
+		edits []lsp.TextEdit
+		want  string
+	}{
+		{
+			name:    "inserts at the same position",
+			text:    "abc",
+			applied: []lsp.TextEdit{textEdit(0, 1, 0, 1, "S")},
+			edits:   []lsp.TextEdit{textEdit(0, 1, 0, 1, "V")},
+			want:    "aSVbc",
+		},
+		{
+			name:    "edits on either side",
+			text:    "one two three",
+			applied: []lsp.TextEdit{textEdit(0, 4, 0, 7, "2")},
+			edits:   []lsp.TextEdit{textEdit(0, 8, 0, 13, "3"), textEdit(0, 0, 0, 3, "1")},
+			want:    "1 2 3",
+		},
+		{
+			name: "sequential edits spanning lines",
+			text: "first\nsecond\nthird\nfourth",
+			applied: []lsp.TextEdit{
+				textEdit(0, 5, 1, 6, ""),
+				textEdit(0, 0, 0, 0, "zero\n"),
+			},
+			edits: []lsp.TextEdit{textEdit(3, 0, 3, 0, "> "), textEdit(2, 2, 2, 5, "IRD\nthird")},
+			want:  "zero\nfirst\nthIRD\nthird\n> fourth",
+		},
+		{
+			name:    "viewer deletes lines after sharer inserts lines",
+			text:    "a\nb\nc\nd",
+			applied: []lsp.TextEdit{textEdit(1, 0, 1, 0, "new\nlines\n")},
+			edits:   []lsp.TextEdit{textEdit(2, 1, 3, 1, "")},
+			want:    "a\nnew\nlines\nb\nc",
+		},
+	}
+	for _, test := range tests {
+		transformed, err := transformEdits(test.edits, test.applied)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := applyEdits(applyEdits(test.text, test.applied), transformed)
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	_, err := transformEdits(
+		[]lsp.TextEdit{textEdit(0, 3, 0, 3, "x")},
+		[]lsp.TextEdit{textEdit(0, 0, 0, 1, ""), textEdit(0, 0, 0, 4, "")},
+	)
+	if err != ErrEditConflict {
+		t.Errorf("insert inside a later delete: got %v, want a conflict", err)
+	}
+}
+
+func TestRebaseEdits(t *testing.T) {
+	s := NewState(log.New(ioutil.Discard, "", 0))
+	s.OpenFile("a.txt", "hello\nworld", "plaintext", 1, false)
+	// The second change applies to the text left by the first
+	s.ReplaceTextRanges("a.txt", []lsp.TextDocumentContentChangeEvent{
+		{Range: &lsp.Range{Start: pos(0, 0), End: pos(0, 0)}, Text: ">> "},
+		{Range: &lsp.Range{Start: pos(0, 3), End: pos(0, 4)}, Text: "H"},
+	}, 2, false)
+	if got := strings.Join(s.GetFile("a.txt").Lines, "\n"); got != ">> Hello\nworld" {
+		t.Fatalf("Got text %q", got)
+	}
+
+	edits, version, err := s.TransformEdits(EditRequest{
+		Filename: "a.txt",
+		Version:  1,
+		Edits:    []lsp.TextEdit{textEdit(0, 5, 1, 0, "!\n"), textEdit(1, 0, 1, 1, "W")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Got version %d, want 2", version)
+	}
+	want := []lsp.TextEdit{textEdit(1, 0, 1, 1, "W"), textEdit(0, 8, 1, 0, "!\n")}
+	if len(edits) != len(want) || edits[0] != want[0] || edits[1] != want[1] {
+		t.Errorf("Got edits %v, want %v", edits, want)
+	}
+
+	errorTests := []struct {
+		name string
+		req  EditRequest
+	}{
+		{"conflict", EditRequest{Filename: "a.txt", Version: 1, Edits: []lsp.TextEdit{textEdit(0, 0, 0, 2, "")}}},
+		{"unknown version", EditRequest{Filename: "a.txt", Version: 3}},
+		{"closed file", EditRequest{Filename: "b.txt", Version: 1}},
+		{"overlapping edits", EditRequest{Filename: "a.txt", Version: 2, Edits: []lsp.TextEdit{
+			textEdit(0, 0, 0, 3, ""),
+			textEdit(0, 2, 0, 4, ""),
+		}}},
+		{"outside of the file", EditRequest{Filename: "a.txt", Version: 2, Edits: []lsp.TextEdit{textEdit(5, 0, 5, 0, "x")}}},
+	}
+	for _, test := range errorTests {
+		if _, _, err := s.TransformEdits(test.req); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestReplaceTextKeepsHistory(t *testing.T) {
+	s := NewState(log.New(ioutil.Discard, "", 0))
+	s.OpenFile("a.txt", "hello\nworld", "plaintext", 1, false)
+	changed := s.TextChanged()
+	s.ReplaceTextRanges("a.txt", []lsp.TextDocumentContentChangeEvent{
+		{Range: &lsp.Range{Start: pos(0, 0), End: pos(0, 0)}, Text: ">> "},
+	}, 2, false)
+	select {
+	case <-changed:
+	default:
+		t.Error("TextChanged was not closed after a change")
+	}
+
+	// The whole file, matching the change we already have
+	changed = s.TextChanged()
+	s.ReplaceText("a.txt", ">> hello\nworld", 3, false)
+	select {
+	case <-changed:
+	default:
+		t.Error("TextChanged was not closed after the full text was sent")
+	}
+	if file := s.GetFile("a.txt"); file.Version != 3 {
+		t.Errorf("Got version %d, want 3", file.Version)
+	}
+	edits, version, err := s.TransformEdits(EditRequest{
+		Filename: "a.txt",
+		Version:  1,
+		Edits:    []lsp.TextEdit{textEdit(1, 0, 1, 1, "W")},
+	})
+	if err != nil {
+		t.Fatalf("Edit from before the full text: %v", err)
+	}
+	if version != 3 || len(edits) != 1 || edits[0] != textEdit(1, 0, 1, 1, "W") {
+		t.Errorf("Got edits %v at version %d", edits, version)
+	}
+
+	// Different text can't be rebased across
+	s.ReplaceText("a.txt", "something else", 4, false)
+	if _, _, err := s.TransformEdits(EditRequest{Filename: "a.txt", Version: 3}); err == nil {
+		t.Error("Rebased an edit across a full text replacement")
+	}
+}
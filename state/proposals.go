@@ -0,0 +1,234 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// How many unresolved proposals a workspace can have
+const maxProposals = 100
+
+// ProposalStatus is how a proposed edit was resolved
+type ProposalStatus string
+
+const (
+	// The sharer applied the edit
+	ProposalAccepted ProposalStatus = "accepted"
+	// The sharer chose not to apply the edit
+	ProposalDismissed ProposalStatus = "dismissed"
+	// The sharer changed the text that the edit was for
+	ProposalConflict ProposalStatus = "conflict"
+	// The sharer closed the file
+	ProposalClosed ProposalStatus = "closed"
+)
+
+// ProposeEditRequest is a change to a file suggested by a viewer. Unlike an
+// EditRequest, it is only applied if the sharer chooses to.
+type ProposeEditRequest struct {
+	Filename string    `json:"filename"`
+	Version  int       `json:"version"`
+	Range    lsp.Range `json:"range"`
+	NewText  string    `json:"newText"`
+	// Short description shown to the sharer
+	Title string `json:"title,omitempty"`
+}
+
+type Proposal struct {
+	ID       int32  `json:"id"`
+	Filename string `json:"filename"`
+	// The version of the file that Range refers to. Proposals are moved to the
+	// latest version as the sharer edits the file.
+	Version int       `json:"version"`
+	Range   lsp.Range `json:"range"`
+	NewText string    `json:"newText"`
+	Title   string    `json:"title,omitempty"`
+
+	// Set while the edit handler is asking the editor to apply it
+	applying bool
+	// Set if the sharer changed the text while the proposal was being applied
+	conflict bool
+}
+
+type ProposalEvent struct {
+	Proposal Proposal `json:"proposal"`
+}
+
+type ResolveProposalEvent struct {
+	ID     int32          `json:"id"`
+	Status ProposalStatus `json:"status"`
+}
+
+// ProposalHandler stores proposals somewhere other than this workspace (e.g.
+// a relay server passes them on to the editor)
+type ProposalHandler func(ProposeEditRequest) (Proposal, error)
+
+// SetProposalHandler sends new proposals to the handler instead of storing
+// them. Pass nil to store them here again.
+func (s *WorkspaceState) SetProposalHandler(handler ProposalHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proposalHandler = handler
+}
+
+// ProposeEdit stores an edit suggested by a viewer until the sharer resolves
+// it
+func (s *WorkspaceState) ProposeEdit(req ProposeEditRequest) (Proposal, error) {
+	s.mu.Lock()
+	if handler := s.proposalHandler; handler != nil {
+		s.mu.Unlock()
+		return handler(req)
+	}
+	defer s.mu.Unlock()
+	if len(s.proposals) >= maxProposals {
+		return Proposal{}, errors.New("Too many proposals")
+	}
+	edits, version, err := s.rebaseEdits(EditRequest{
+		Filename: req.Filename,
+		Version:  req.Version,
+		Edits:    []lsp.TextEdit{{Range: req.Range, NewText: req.NewText}},
+	})
+	if err != nil {
+		return Proposal{}, err
+	}
+	proposal := Proposal{
+		ID:       s.nextProposalID,
+		Filename: req.Filename,
+		Version:  version,
+		Range:    edits[0].Range,
+		NewText:  req.NewText,
+		Title:    req.Title,
+	}
+	s.nextProposalID++
+	s.addProposal(proposal)
+	return proposal, nil
+}
+
+// AddProposal stores a proposal that was created by another workspace
+func (s *WorkspaceState) AddProposal(proposal Proposal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addProposal(proposal)
+}
+
+func (s *WorkspaceState) addProposal(proposal Proposal) {
+	s.proposals[proposal.ID] = &proposal
+	s.publish(ProposalEvent{
+		Proposal: proposal,
+	})
+}
+
+// GetProposals returns the unresolved proposals for a file, or for all files if
+// filename is empty
+func (s *WorkspaceState) GetProposals(filename string) []Proposal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]Proposal, 0)
+	for _, proposal := range s.proposals {
+		if filename == "" || proposal.Filename == filename {
+			ret = append(ret, *proposal)
+		}
+	}
+	return ret
+}
+
+// ResolveProposal removes a proposal and tells the viewers how it was
+// resolved. It returns false if there was no such proposal.
+func (s *WorkspaceState) ResolveProposal(id int32, status ProposalStatus) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolveProposal(id, status)
+}
+
+func (s *WorkspaceState) resolveProposal(id int32, status ProposalStatus) bool {
+	if _, ok := s.proposals[id]; !ok {
+		return false
+	}
+	delete(s.proposals, id)
+	s.publish(ResolveProposalEvent{
+		ID:     id,
+		Status: status,
+	})
+	return true
+}
+
+// StartApplyProposal marks a proposal as being applied, so it isn't resolved
+// as a conflict when the editor makes the change
+func (s *WorkspaceState) StartApplyProposal(id int32) (Proposal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proposal := s.proposals[id]
+	if proposal == nil {
+		return Proposal{}, fmt.Errorf("No proposal found with ID %d", id)
+	}
+	if proposal.applying {
+		return Proposal{}, fmt.Errorf("Proposal %d is already being applied", id)
+	}
+	proposal.applying = true
+	return *proposal, nil
+}
+
+// FinishApplyProposal resolves a proposal once the editor has tried to apply it
+func (s *WorkspaceState) FinishApplyProposal(id int32, applied bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proposal := s.proposals[id]
+	if proposal == nil {
+		return
+	}
+	proposal.applying = false
+	if applied {
+		s.resolveProposal(id, ProposalAccepted)
+	} else if proposal.conflict {
+		s.resolveProposal(id, ProposalConflict)
+	}
+}
+
+// deferConflict is true if a conflicting change shouldn't resolve the
+// proposal yet. That's the case while it's being applied, or if a proposal
+// handler owns the proposals and will tell us how they were resolved.
+func (s *WorkspaceState) deferConflict(proposal *Proposal) bool {
+	return proposal.applying || s.proposalHandler != nil
+}
+
+// transformProposals moves the proposals for a file past changes made by the
+// sharer
+func (s *WorkspaceState) transformProposals(file *File, edits []lsp.TextEdit) {
+	for id, proposal := range s.proposals {
+		if proposal.Filename != file.Filename || proposal.conflict {
+			continue
+		}
+		edit := lsp.TextEdit{Range: proposal.Range, NewText: proposal.NewText}
+		// The editor applied the proposal itself
+		if len(edits) == 1 && edits[0] == edit && s.proposalHandler == nil {
+			s.resolveProposal(id, ProposalAccepted)
+			continue
+		}
+		newEdits, err := transformEdits([]lsp.TextEdit{edit}, edits)
+		if err != nil {
+			if s.deferConflict(proposal) {
+				proposal.conflict = true
+			} else {
+				s.resolveProposal(id, ProposalConflict)
+			}
+			continue
+		}
+		proposal.Range = newEdits[0].Range
+		proposal.Version = file.Version
+	}
+}
+
+// resolveFileProposals resolves all proposals for a file
+func (s *WorkspaceState) resolveFileProposals(filename string, status ProposalStatus) {
+	for id, proposal := range s.proposals {
+		if proposal.Filename != filename {
+			continue
+		}
+		if status == ProposalConflict && s.deferConflict(proposal) {
+			proposal.conflict = true
+		} else {
+			s.resolveProposal(id, status)
+		}
+	}
+}
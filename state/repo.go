@@ -0,0 +1,35 @@
+package state
+
+// Repo describes the git repository that the workspace is in
+type Repo struct {
+	// Empty if HEAD is detached
+	Branch string `json:"branch,omitempty"`
+	// Empty if there are no commits yet
+	Commit string `json:"commit,omitempty"`
+	// Set if tracked files have uncommitted changes
+	Dirty bool `json:"dirty"`
+}
+
+type RepoEvent struct {
+	Repo *Repo `json:"repo"`
+}
+
+// SetRepo tells viewers about the workspace's repository if it changed. Pass
+// nil if the workspace isn't in a repository.
+func (s *WorkspaceState) SetRepo(repo *Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if repo == s.repo || (repo != nil && s.repo != nil && *repo == *s.repo) {
+		return
+	}
+	s.repo = repo
+	s.publish(RepoEvent{
+		Repo: repo,
+	})
+}
+
+func (s *WorkspaceState) GetRepo() *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repo
+}
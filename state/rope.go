@@ -0,0 +1,239 @@
+package state
+
+import (
+	"math/rand"
+)
+
+// Most lines per chunk of a Rope
+const ropeChunkSize = 64
+
+// Rope is the text of a file as a list of lines. It's stored as a balanced
+// tree of chunks of lines, so that getting a line and replacing a range of
+// lines take O(log n) time for n lines, however big the file gets.
+//
+// A Rope is never modified. Splice returns a new Rope that shares most of its
+// tree with the old one, so a Rope can be kept as a snapshot of the file.
+type Rope struct {
+	root *ropeNode
+}
+
+// A treap: nodes are in line order, and each node's priority is at least as
+// high as its children's. Random priorities keep it balanced.
+type ropeNode struct {
+	left     *ropeNode
+	right    *ropeNode
+	priority uint32
+	// Never empty, and never appended to
+	chunk []string
+	// Lines in the whole subtree
+	size int
+}
+
+// NewRope creates a rope with the given lines. The rope shares the slice, so
+// it must not be changed afterwards.
+func NewRope(lines []string) Rope {
+	return Rope{root: buildRope(lines)}
+}
+
+// Len is the number of lines
+func (r Rope) Len() int {
+	return r.root.len()
+}
+
+// Line returns line i, or an empty string if there's no such line
+func (r Rope) Line(i int) string {
+	n := r.root
+	for n != nil {
+		leftSize := n.left.len()
+		if i < leftSize {
+			n = n.left
+		} else if i < leftSize+len(n.chunk) {
+			return n.chunk[i-leftSize]
+		} else {
+			i -= leftSize + len(n.chunk)
+			n = n.right
+		}
+	}
+	return ""
+}
+
+// Slice returns lines start (inclusive) to end (exclusive)
+func (r Rope) Slice(start int, end int) []string {
+	start = clampInt(start, 0, r.Len())
+	end = clampInt(end, start, r.Len())
+	ret := make([]string, 0, end-start)
+	r.root.appendRange(start, end, &ret)
+	return ret
+}
+
+// Strings returns all of the lines
+func (r Rope) Strings() []string {
+	return r.Slice(0, r.Len())
+}
+
+// Equal reports whether the rope has exactly these lines
+func (r Rope) Equal(lines []string) bool {
+	if r.Len() != len(lines) {
+		return false
+	}
+	for i, line := range r.Strings() {
+		if line != lines[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Splice replaces lines start (inclusive) to end (exclusive) with new lines
+func (r Rope) Splice(start int, end int, lines []string) Rope {
+	start = clampInt(start, 0, r.Len())
+	end = clampInt(end, start, r.Len())
+	// Widen the range to whole chunks, so that edits don't leave behind ever
+	// smaller pieces of the chunks they touch
+	chunkStart, _ := r.root.chunkAt(start)
+	last := start
+	if end > start {
+		last = end - 1
+	}
+	lastStart, lastLen := r.root.chunkAt(last)
+	chunkEnd := lastStart + lastLen
+	if chunkEnd < end {
+		chunkEnd = end
+	}
+	replaced := make([]string, 0, len(lines)+start-chunkStart+chunkEnd-end)
+	replaced = append(replaced, r.Slice(chunkStart, start)...)
+	replaced = append(replaced, lines...)
+	replaced = append(replaced, r.Slice(end, chunkEnd)...)
+
+	before, rest := splitRope(r.root, chunkStart)
+	_, after := splitRope(rest, chunkEnd-chunkStart)
+	return Rope{root: mergeRopes(mergeRopes(before, buildRope(replaced)), after)}
+}
+
+func (n *ropeNode) len() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// with returns a copy of the node with different children
+func (n *ropeNode) with(left *ropeNode, right *ropeNode) *ropeNode {
+	return newRopeNode(left, n.chunk, right, n.priority)
+}
+
+func newRopeNode(left *ropeNode, chunk []string, right *ropeNode, priority uint32) *ropeNode {
+	return &ropeNode{
+		left:     left,
+		right:    right,
+		priority: priority,
+		chunk:    chunk,
+		size:     left.len() + len(chunk) + right.len(),
+	}
+}
+
+// chunkAt returns the first line and the length of the chunk with line i. If
+// i is past the end it returns the end.
+func (n *ropeNode) chunkAt(i int) (int, int) {
+	offset := 0
+	for n != nil {
+		leftSize := n.left.len()
+		if i < leftSize {
+			n = n.left
+		} else if i < leftSize+len(n.chunk) {
+			return offset + leftSize, len(n.chunk)
+		} else {
+			i -= leftSize + len(n.chunk)
+			offset += leftSize + len(n.chunk)
+			n = n.right
+		}
+	}
+	return offset, 0
+}
+
+func (n *ropeNode) appendRange(start int, end int, ret *[]string) {
+	if n == nil || start >= end {
+		return
+	}
+	leftSize := n.left.len()
+	if start < leftSize {
+		n.left.appendRange(start, end, ret)
+	}
+	chunkStart := clampInt(start-leftSize, 0, len(n.chunk))
+	chunkEnd := clampInt(end-leftSize, 0, len(n.chunk))
+	*ret = append(*ret, n.chunk[chunkStart:chunkEnd]...)
+	rightStart := leftSize + len(n.chunk)
+	if end > rightStart {
+		n.right.appendRange(start-rightStart, end-rightStart, ret)
+	}
+}
+
+// splitRope splits a tree into the first i lines and the rest
+func splitRope(n *ropeNode, i int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	leftSize := n.left.len()
+	if i <= leftSize {
+		left, right := splitRope(n.left, i)
+		return left, n.with(right, n.right)
+	}
+	if i >= leftSize+len(n.chunk) {
+		left, right := splitRope(n.right, i-leftSize-len(n.chunk))
+		return n.with(n.left, left), right
+	}
+	// Both halves keep the priority, which is still at least their children's
+	i -= leftSize
+	return newRopeNode(n.left, n.chunk[:i:i], nil, n.priority),
+		newRopeNode(nil, n.chunk[i:], n.right, n.priority)
+}
+
+// mergeRopes joins two trees
+func mergeRopes(left *ropeNode, right *ropeNode) *ropeNode {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+	if left.priority >= right.priority {
+		return left.with(left.left, mergeRopes(left.right, right))
+	}
+	return right.with(mergeRopes(left, right.left), right.right)
+}
+
+// buildRope builds a tree from lines in linear time. It's the Cartesian tree
+// of the chunks' priorities.
+func buildRope(lines []string) *ropeNode {
+	var stack []*ropeNode
+	for start := 0; start < len(lines); start += ropeChunkSize {
+		end := start + ropeChunkSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		n := &ropeNode{chunk: lines[start:end:end], priority: rand.Uint32()}
+		var last *ropeNode
+		for len(stack) > 0 && stack[len(stack)-1].priority < n.priority {
+			last = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+		n.left = last
+		if len(stack) > 0 {
+			stack[len(stack)-1].right = n
+		}
+		stack = append(stack, n)
+	}
+	if len(stack) == 0 {
+		return nil
+	}
+	stack[0].setSizes()
+	return stack[0]
+}
+
+func (n *ropeNode) setSizes() int {
+	if n == nil {
+		return 0
+	}
+	n.size = n.left.setSizes() + len(n.chunk) + n.right.setSizes()
+	return n.size
+}
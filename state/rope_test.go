@@ -0,0 +1,474 @@
+package state
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+// applyTextChangesSlice is applyTextChanges as it was before files were
+// stored in a Rope, for comparison. Two bugs are fixed so that it can be used
+// to check the Rope: replacing n+1 lines with n lines panicked, and replacing
+// lines with fewer lines left the rest of the last line on a line of its own.
+func applyTextChangesSlice(text []string, changes []lsp.TextDocumentContentChangeEvent, encoding PositionEncoding) []string {
+	for _, change := range changes {
+		rng := *change.Range
+		newLines := SplitLines(change.Text)
+		if change.Text != "" {
+			col := rng.Start.Character
+			var remainder string
+			for i, newLine := range newLines {
+				lineNo := rng.Start.Line + i
+				line := text[lineNo]
+				text[lineNo] = line[:encoding.ByteOffset(line, col)] + newLine
+				if lineNo == rng.End.Line {
+					remainder = line[encoding.ByteOffset(line, rng.End.Character):]
+					break
+				}
+				col = 0
+			}
+			if len(newLines) > rng.End.Line-rng.Start.Line {
+				leftover := newLines[rng.End.Line-rng.Start.Line+1:]
+				text = insertLinesSlice(text, leftover, rng.End.Line+1)
+				if remainder != "" {
+					lastLine := rng.Start.Line + len(newLines) - 1
+					text[lastLine] = text[lastLine] + remainder
+				}
+			} else {
+				lastLine := rng.Start.Line + len(newLines) - 1
+				text = deleteRangeSlice(text, lsp.Range{
+					Start: lsp.Position{Line: lastLine, Character: encoding.Len(text[lastLine])},
+					End:   rng.End,
+				}, encoding)
+			}
+		} else {
+			text = deleteRangeSlice(text, rng, encoding)
+		}
+	}
+	return text
+}
+
+func insertLinesSlice(lines []string, newLines []string, index int) []string {
+	if len(newLines) == 0 {
+		return lines
+	}
+	linesLen := len(lines)
+	lines = append(lines, newLines...)
+	if index == linesLen {
+		return lines
+	}
+	copy(lines[index+len(newLines):], lines[index:])
+	for i, line := range newLines {
+		lines[index+i] = line
+	}
+	return lines
+}
+
+func deleteRangeSlice(text []string, rng lsp.Range, encoding PositionEncoding) []string {
+	startLine := text[rng.Start.Line]
+	endLine := text[rng.End.Line]
+	text[rng.Start.Line] = startLine[:encoding.ByteOffset(startLine, rng.Start.Character)] +
+		endLine[encoding.ByteOffset(endLine, rng.End.Character):]
+	numCut := rng.End.Line - rng.Start.Line
+	if numCut > 0 {
+		i := rng.Start.Line + 1
+		text = text[:i+copy(text[i:], text[i+numCut:])]
+	}
+	return text
+}
+
+// checkRope checks the invariants of the tree: chunks are never empty or too
+// big, sizes add up, and priorities are in heap order
+func checkRope(t *testing.T, n *ropeNode) int {
+	t.Helper()
+	if n == nil {
+		return 0
+	}
+	if len(n.chunk) == 0 || len(n.chunk) > ropeChunkSize {
+		t.Fatalf("Chunk has %d lines", len(n.chunk))
+	}
+	for _, child := range []*ropeNode{n.left, n.right} {
+		if child != nil && child.priority > n.priority {
+			t.Fatalf("Child has priority %d over %d", child.priority, n.priority)
+		}
+	}
+	size := checkRope(t, n.left) + len(n.chunk) + checkRope(t, n.right)
+	if size != n.size {
+		t.Fatalf("Node has size %d, but %d lines", n.size, size)
+	}
+	return size
+}
+
+func numberedLines(start int, end int) []string {
+	lines := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	return lines
+}
+
+func concatLines(parts ...[]string) []string {
+	var lines []string
+	for _, part := range parts {
+		lines = append(lines, part...)
+	}
+	return lines
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRopeSplice(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string
+		start, end int
+		newLines   []string
+		want       []string
+	}{
+		{"empty rope", nil, 0, 0, []string{"a", "b"}, []string{"a", "b"}},
+		{"delete everything", numberedLines(0, 10), 0, 10, nil, []string{}},
+		{"insert at the start", numberedLines(0, 3), 0, 0, []string{"x"}, []string{"x", "0", "1", "2"}},
+		{"insert at the end", numberedLines(0, 3), 3, 3, []string{"x"}, []string{"0", "1", "2", "x"}},
+		{"replace in the middle", numberedLines(0, 5), 1, 4, []string{"x"}, []string{"0", "x", "4"}},
+		{"range past the end", numberedLines(0, 3), 2, 10, []string{"x"}, []string{"0", "1", "x"}},
+		{
+			"insert on a chunk boundary",
+			numberedLines(0, 200), 64, 64, []string{"x"},
+			concatLines(numberedLines(0, 64), []string{"x"}, numberedLines(64, 200)),
+		},
+		{
+			"replace across chunk boundaries",
+			numberedLines(0, 300), 60, 200, []string{"x", "y"},
+			concatLines(numberedLines(0, 60), []string{"x", "y"}, numberedLines(200, 300)),
+		},
+		{
+			"delete a whole chunk",
+			numberedLines(0, 192), 64, 128, nil,
+			concatLines(numberedLines(0, 64), numberedLines(128, 192)),
+		},
+		{
+			"insert more than a chunk",
+			numberedLines(0, 10), 5, 5, numberedLines(1000, 1200),
+			concatLines(numberedLines(0, 5), numberedLines(1000, 1200), numberedLines(5, 10)),
+		},
+	}
+	for _, test := range tests {
+		rope := NewRope(test.lines)
+		before := rope.Strings()
+		spliced := rope.Splice(test.start, test.end, test.newLines)
+		checkRope(t, spliced.root)
+		if got := spliced.Strings(); !equalLines(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		if spliced.Len() != len(test.want) {
+			t.Errorf("%s: got length %d, want %d", test.name, spliced.Len(), len(test.want))
+		}
+		if got := rope.Strings(); !equalLines(got, before) {
+			t.Errorf("%s: the old rope changed to %v", test.name, got)
+		}
+	}
+}
+
+func TestRopeLookup(t *testing.T) {
+	lines := numberedLines(0, 1000)
+	rope := NewRope(lines)
+	checkRope(t, rope.root)
+	for i, line := range lines {
+		if got := rope.Line(i); got != line {
+			t.Fatalf("Line %d is %q", i, got)
+		}
+	}
+	for _, i := range []int{-1, 1000, 5000} {
+		if got := rope.Line(i); got != "" {
+			t.Errorf("Line %d is %q, want empty", i, got)
+		}
+	}
+	slices := []struct{ start, end int }{{0, 0}, {0, 1000}, {63, 65}, {100, 400}, {990, 2000}, {-5, 3}, {500, 100}}
+	for _, s := range slices {
+		start := clampInt(s.start, 0, len(lines))
+		end := clampInt(s.end, start, len(lines))
+		if got := rope.Slice(s.start, s.end); !equalLines(got, lines[start:end]) {
+			t.Errorf("Slice(%d, %d) = %v", s.start, s.end, got)
+		}
+	}
+
+	empty := NewRope(nil)
+	if empty.Len() != 0 || len(empty.Strings()) != 0 || empty.Line(0) != "" {
+		t.Errorf("Empty rope has lines %v", empty.Strings())
+	}
+}
+
+func TestRopeSharing(t *testing.T) {
+	// Copies of a rope (like the snapshots that GetFile takes) never change
+	lines := numberedLines(0, 500)
+	rope := NewRope(lines)
+	snapshots := []Rope{rope}
+	want := [][]string{rope.Strings()}
+	for i := 0; i < 50; i++ {
+		rope = rope.Splice(i*7, i*7+3, []string{"edit", fmt.Sprint(i)})
+		snapshots = append(snapshots, rope)
+		want = append(want, rope.Strings())
+	}
+	for i, snapshot := range snapshots {
+		if !equalLines(snapshot.Strings(), want[i]) {
+			t.Fatalf("Snapshot %d changed", i)
+		}
+	}
+	for i, line := range numberedLines(0, 500) {
+		if lines[i] != line {
+			t.Fatalf("Line %d of the original slice changed to %q", i, lines[i])
+		}
+	}
+}
+
+func TestApplyTextChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		changes []lsp.TextDocumentContentChangeEvent
+		want    string
+		// The lines each change produced
+		changeText [][]string
+	}{
+		{
+			name:       "insert into an empty file",
+			text:       "",
+			changes:    changeAt(0, 0, 0, 0, "hello\n"),
+			want:       "hello\n",
+			changeText: [][]string{{"hello", ""}},
+		},
+		{
+			name:       "type at the end of a line",
+			text:       "abc\ndef",
+			changes:    changeAt(0, 3, 0, 3, "x"),
+			want:       "abcx\ndef",
+			changeText: [][]string{{"abcx"}},
+		},
+		{
+			name:       "join lines",
+			text:       "abc\ndef\nghi",
+			changes:    changeAt(0, 2, 2, 1, ""),
+			want:       "abhi",
+			changeText: [][]string{{"abhi"}},
+		},
+		{
+			name:       "replace lines with fewer lines",
+			text:       "one\ntwo\nthree\nfour",
+			changes:    changeAt(0, 1, 3, 2, "X\nY"),
+			want:       "oX\nYur",
+			changeText: [][]string{{"oX", "Yur"}},
+		},
+		{
+			name:       "replace lines with more lines",
+			text:       "one\ntwo",
+			changes:    changeAt(0, 3, 1, 0, "\na\nb\n"),
+			want:       "one\na\nb\ntwo",
+			changeText: [][]string{{"one", "a", "b", "two"}},
+		},
+		{
+			name:       "delete the trailing newline",
+			text:       "abc\n",
+			changes:    changeAt(0, 3, 1, 0, ""),
+			want:       "abc",
+			changeText: [][]string{{"abc"}},
+		},
+		{
+			name:       "range past the end of the file",
+			text:       "abc\ndef",
+			changes:    changeAt(1, 1, 5, 0, "!"),
+			want:       "abc\nd!",
+			changeText: [][]string{{"d!"}},
+		},
+		{
+			name: "changes apply one after another",
+			text: "hello",
+			changes: append(
+				changeAt(0, 0, 0, 0, "> "),
+				changeAt(0, 2, 0, 3, "H")...,
+			),
+			want:       "> Hello",
+			changeText: [][]string{{"> hello"}, {"> Hello"}},
+		},
+		{
+			name:       "utf-16 offsets",
+			text:       "a😀b",
+			changes:    changeAt(0, 3, 0, 4, "c"),
+			want:       "a😀c",
+			changeText: [][]string{{"a😀c"}},
+		},
+	}
+	for _, test := range tests {
+		rope, changeText, edits := applyTextChanges(NewRope(SplitLines(test.text)), test.changes, PositionEncodingUTF16)
+		checkRope(t, rope.root)
+		if got := strings.Join(rope.Strings(), "\n"); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if len(edits) != len(test.changes) {
+			t.Errorf("%s: got %d edits", test.name, len(edits))
+		}
+		for i, change := range changeText {
+			if i >= len(test.changeText) || !equalLines(change.Text, test.changeText[i]) {
+				t.Errorf("%s: change %d has text %q", test.name, i, change.Text)
+			}
+		}
+	}
+}
+
+// Random edits of all sizes, many of them spanning chunks, give the same text
+// as the slice implementation
+func TestApplyTextChangesRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		lines := generatedLines(1 + rnd.Intn(300))
+		slice := make([]string, len(lines))
+		copy(slice, lines)
+		rope := NewRope(lines)
+		for step := 0; step < 100; step++ {
+			startLine := rnd.Intn(len(slice))
+			endLine := startLine + rnd.Intn(clampInt(len(slice)-startLine, 1, 150))
+			startChar := rnd.Intn(len(slice[startLine]) + 1)
+			endChar := rnd.Intn(len(slice[endLine]) + 1)
+			if startLine == endLine && endChar < startChar {
+				startChar, endChar = endChar, startChar
+			}
+			var text string
+			switch rnd.Intn(4) {
+			case 1:
+				text = "x"
+			case 2:
+				text = "\n"
+			case 3:
+				text = strings.Repeat("new line\n", rnd.Intn(150)) + "end"
+			}
+			change := changeAt(startLine, startChar, endLine, endChar, text)
+			slice = applyTextChangesSlice(slice, change, PositionEncodingUTF8)
+			rope, _, _ = applyTextChanges(rope, change, PositionEncodingUTF8)
+			checkRope(t, rope.root)
+			if !equalLines(rope.Strings(), slice) {
+				t.Fatalf("Trial %d, step %d: rope and slice differ after %v", trial, step, change[0])
+			}
+			for _, i := range []int{0, startLine, len(slice) - 1, rnd.Intn(len(slice))} {
+				if rope.Line(i) != slice[i] {
+					t.Fatalf("Trial %d, step %d: line %d is %q, want %q", trial, step, i, rope.Line(i), slice[i])
+				}
+			}
+		}
+	}
+}
+
+func generatedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("\tvar generated%d = []byte{0x%02x, 0x%02x, 0x%02x} // line %d", i, i%256, (i/256)%256, (i/65536)%256, i)
+	}
+	return lines
+}
+
+func changeAt(line int, startChar int, endLine int, endChar int, text string) []lsp.TextDocumentContentChangeEvent {
+	return []lsp.TextDocumentContentChangeEvent{{
+		Range: &lsp.Range{
+			Start: lsp.Position{Line: line, Character: startChar},
+			End:   lsp.Position{Line: endLine, Character: endChar},
+		},
+		Text: text,
+	}}
+}
+
+var benchmarkSizes = []int{10000, 100000, 1000000}
+
+type editBenchmark struct {
+	name string
+	// Returns the change for iteration i of a file with n lines. Changes keep
+	// the number of lines the same over every two iterations.
+	change func(i int, n int) []lsp.TextDocumentContentChangeEvent
+}
+
+var editBenchmarks = []editBenchmark{
+	{"Typing", func(i int, n int) []lsp.TextDocumentContentChangeEvent {
+		line := (i * 7919) % n
+		return changeAt(line, 4, line, 4, "x")
+	}},
+	{"InsertDeleteLines", func(i int, n int) []lsp.TextDocumentContentChangeEvent {
+		line := ((i / 2) * 7919) % (n - 10)
+		if i%2 == 0 {
+			return changeAt(line, 0, line, 0, strings.Repeat("inserted\n", 10))
+		}
+		return changeAt(line, 0, line+10, 0, "")
+	}},
+	{"AppendAtEnd", func(i int, n int) []lsp.TextDocumentContentChangeEvent {
+		if i%2 == 0 {
+			return changeAt(n-1, 0, n-1, 0, "appended\n")
+		}
+		return changeAt(n-1, 0, n, 0, "")
+	}},
+}
+
+// Benchmarks of the rope against the []string storage it replaced, on large
+// generated files. Run with:
+//
+//	go test -run '^$' -bench . ./state
+func BenchmarkApplyTextChanges(b *testing.B) {
+	for _, bench := range editBenchmarks {
+		for _, n := range benchmarkSizes {
+			lines := generatedLines(n)
+			b.Run(fmt.Sprintf("%s/Rope/%d", bench.name, n), func(b *testing.B) {
+				text := NewRope(lines)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					text, _, _ = applyTextChanges(text, bench.change(i, n), PositionEncodingUTF16)
+				}
+			})
+			b.Run(fmt.Sprintf("%s/Slice/%d", bench.name, n), func(b *testing.B) {
+				text := make([]string, n)
+				copy(text, lines)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					text = applyTextChangesSlice(text, bench.change(i, n), PositionEncodingUTF16)
+				}
+			})
+		}
+	}
+}
+
+// Copying the text of a file, like GetFile does for every getText
+func BenchmarkCopyText(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		lines := generatedLines(n)
+		b.Run(fmt.Sprintf("Rope/%d", n), func(b *testing.B) {
+			text := NewRope(lines)
+			for i := 0; i < b.N; i++ {
+				text.Strings()
+			}
+		})
+		b.Run(fmt.Sprintf("Slice/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copied := make([]string, len(lines))
+				copy(copied, lines)
+			}
+		})
+	}
+}
+
+func BenchmarkRopeLine(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		text := NewRope(generatedLines(n))
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				text.Line((i * 7919) % n)
+			}
+		})
+	}
+}
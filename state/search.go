@@ -0,0 +1,187 @@
+package state
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+
+	"github.com/sourcegraph/go-lsp"
+)
+
+const (
+	// Default and maximum number of matches returned by a search
+	defaultSearchResults = 1000
+	maxSearchResults     = 10000
+	// Maximum lines of context around each match
+	maxSearchContext = 10
+	// How many matches are sent at a time when streaming results
+	searchBatchSize = 100
+)
+
+// SearchRequest searches the text of every open file. Matches don't span
+// lines.
+type SearchRequest struct {
+	Query string `json:"query"`
+	// Treat the query as a regular expression (RE2 syntax)
+	Regex      bool `json:"regex,omitempty"`
+	IgnoreCase bool `json:"ignoreCase,omitempty"`
+	// Lines of context to include before and after each match
+	Context    int `json:"context,omitempty"`
+	MaxResults int `json:"maxResults,omitempty"`
+	// If set, matches are sent in searchResults notifications with this token
+	// as they are found, and the response only has the totals
+	Token string `json:"token,omitempty"`
+}
+
+type SearchMatch struct {
+	Filename string `json:"filename"`
+	FileID   int32  `json:"file_id"`
+	// In UTF-16 code units, like the rest of LSP
+	Range  lsp.Range `json:"range"`
+	Line   string    `json:"line"`
+	Before []string  `json:"before,omitempty"`
+	After  []string  `json:"after,omitempty"`
+}
+
+type SearchResult struct {
+	Matches []SearchMatch `json:"matches,omitempty"`
+	Count   int           `json:"count"`
+	// Set if there were more than MaxResults matches
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// SearchResultsNotification is a batch of matches for a streamed search
+type SearchResultsNotification struct {
+	Token   string        `json:"token"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+type searcher struct {
+	re      *regexp.Regexp
+	context int
+}
+
+func newSearcher(req SearchRequest) (*searcher, error) {
+	if req.Query == "" {
+		return nil, errors.New("Search query is empty")
+	}
+	expr := req.Query
+	if !req.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if req.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &searcher{
+		re:      re,
+		context: clampInt(req.Context, 0, maxSearchContext),
+	}, nil
+}
+
+// search returns the matches in a file, stopping after limit matches
+func (s *searcher) search(file File, limit int) []SearchMatch {
+	var matches []SearchMatch
+	for lnum, line := range file.Lines {
+		for _, loc := range s.re.FindAllStringIndex(line, -1) {
+			// Empty matches (e.g. "^") aren't useful to show
+			if loc[0] == loc[1] {
+				continue
+			}
+			if len(matches) >= limit {
+				return matches
+			}
+			start := StateEncoding.Character(line, loc[0])
+			matches = append(matches, SearchMatch{
+				Filename: file.Filename,
+				FileID:   file.ID,
+				Range: lsp.Range{
+					Start: lsp.Position{Line: lnum, Character: start},
+					End:   lsp.Position{Line: lnum, Character: StateEncoding.Character(line, loc[1])},
+				},
+				Line:   line,
+				Before: file.Lines[clampInt(lnum-s.context, 0, lnum):lnum],
+				After:  file.Lines[lnum+1 : clampInt(lnum+1+s.context, lnum+1, len(file.Lines))],
+			})
+		}
+	}
+	return matches
+}
+
+// Search finds matches in all open files, in order of filename. If emit is
+// non-nil, matches are passed to it in batches instead of being returned.
+func (s *WorkspaceState) Search(req SearchRequest, emit func([]SearchMatch)) (SearchResult, error) {
+	// Search a snapshot so that the editor isn't blocked by a big workspace
+	s.mu.Lock()
+	files := make([]File, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, File{
+			Filename: f.Filename,
+			ID:       f.ID,
+			text:     f.text,
+		})
+	}
+	s.mu.Unlock()
+	for i := range files {
+		files[i].Lines = files[i].text.Strings()
+	}
+	return SearchFiles(req, files, emit)
+}
+
+// SearchFiles is Search for files that aren't in a WorkspaceState (e.g. a
+// viewer's copy of an end-to-end encrypted share). It sorts files.
+func SearchFiles(req SearchRequest, files []File, emit func([]SearchMatch)) (SearchResult, error) {
+	searcher, err := newSearcher(req)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	limit := req.MaxResults
+	if limit <= 0 {
+		limit = defaultSearchResults
+	}
+	limit = clampInt(limit, 1, maxSearchResults)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+
+	var result SearchResult
+	var batch []SearchMatch
+	for _, file := range files {
+		// One extra match tells us whether the results are truncated
+		matches := searcher.search(file, limit-result.Count+1)
+		if result.Count+len(matches) > limit {
+			matches = matches[:limit-result.Count]
+			result.Truncated = true
+		}
+		result.Count += len(matches)
+		if emit == nil {
+			result.Matches = append(result.Matches, matches...)
+		} else {
+			batch = append(batch, matches...)
+			if len(batch) >= searchBatchSize {
+				emit(batch)
+				batch = nil
+			}
+		}
+		if result.Truncated {
+			break
+		}
+	}
+	if len(batch) > 0 {
+		emit(batch)
+	}
+	return result, nil
+}
+
+func clampInt(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
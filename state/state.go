@@ -1,20 +1,41 @@
 package state
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"pair-ls/outline"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/asaskevich/EventBus"
 	"github.com/sourcegraph/go-lsp"
 )
 
 type WorkspaceState struct {
-	files  map[string]*File
-	view   *View
-	mu     sync.Mutex
-	events EventBus.Bus
-	logger *log.Logger
-	nextID int32
+	files        map[string]*File
+	view         *View
+	mu           sync.Mutex
+	events       EventBus.Bus
+	logger       *log.Logger
+	nextID       int32
+	editHandler  EditHandler
+	focusHandler FocusHandler
+
+	languageHandler LanguageHandler
+	// The text that files are diffed against, by filename
+	baselines map[string][]string
+	repo      *Repo
+	// Names of the workspace folders, if there's more than one root
+	folders []string
+	// The encoding of the editor's positions
+	encoding PositionEncoding
+
+	proposals       map[int32]*Proposal
+	nextProposalID  int32
+	proposalHandler ProposalHandler
+	// Closed and replaced whenever the text of a file changes
+	textChanged chan struct{}
 }
 
 type File struct {
@@ -22,6 +43,18 @@ type File struct {
 	ID       int32    `json:"id"`
 	Lines    []string `json:"lines,omitempty"`
 	Language string   `json:"language"`
+	// The LSP document version
+	Version int `json:"version"`
+	// The text of an open file. Lines is only filled in on copies returned to
+	// callers.
+	text Rope
+	// Recent changes, used to transform edits from viewers
+	history      []versionEdits
+	historyStart int
+	outline      *outline.Outline
+	// Answers from the sharer's language servers for languageCacheVersion
+	languageCache        map[languageKey]json.RawMessage
+	languageCacheVersion int
 }
 
 type View struct {
@@ -38,6 +71,10 @@ type OpenFileEvent struct {
 	Filename string `json:"filename"`
 	ID       int32  `json:"id"`
 	Language string `json:"language"`
+	Version  int    `json:"version"`
+	// Not sent to viewers (they request the text when needed), but available
+	// to subscribers that need to mirror the full workspace
+	Lines []string `json:"-"`
 }
 
 type CloseFileEvent struct {
@@ -45,8 +82,9 @@ type CloseFileEvent struct {
 }
 
 type ReplaceTextEvent struct {
-	FileID int32    `json:"file_id"`
-	Text   []string `json:"text"`
+	FileID  int32    `json:"file_id"`
+	Text    []string `json:"text"`
+	Version int      `json:"version"`
 }
 
 type ChangeTextRange struct {
@@ -58,6 +96,8 @@ type ChangeTextRange struct {
 type UpdateTextEvent struct {
 	FileID  int32             `json:"file_id"`
 	Changes []ChangeTextRange `json:"changes"`
+	// The version of the file after the changes
+	Version int `json:"version"`
 }
 
 type ChangeViewEvent struct {
@@ -68,9 +108,13 @@ const TOPIC = "all"
 
 func NewState(logger *log.Logger) *WorkspaceState {
 	return &WorkspaceState{
-		files:  make(map[string]*File),
-		events: EventBus.New(),
-		logger: logger,
+		files:     make(map[string]*File),
+		events:    EventBus.New(),
+		logger:    logger,
+		proposals: make(map[int32]*Proposal),
+		baselines: make(map[string][]string),
+
+		textChanged: make(chan struct{}),
 	}
 }
 
@@ -92,33 +136,35 @@ func (s *WorkspaceState) Clear() {
 	for k := range s.files {
 		delete(s.files, k)
 	}
+	for k := range s.proposals {
+		delete(s.proposals, k)
+	}
+	for k := range s.baselines {
+		delete(s.baselines, k)
+	}
+	s.repo = nil
+	s.folders = nil
 	s.view = nil
 }
 
+// CursorMove moves the sharer's cursors, which are in the editor's encoding
 func (s *WorkspaceState) CursorMove(filename string, cursors []CursorPosition) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	file := s.files[filename]
+	if file == nil {
+		return
+	}
+	encoding := s.editorEncoding()
 	s.view.FileID = file.ID
 	newCursors := make([]CursorPosition, 0, len(cursors))
 	for _, cursor := range cursors {
 		newPos := CursorPosition{
-			Position: lsp.Position{
-				Line:      cursor.Position.Line,
-				Character: CharIndexToRune(file.Lines[cursor.Position.Line], cursor.Position.Character),
-			},
+			Position: convertPosition(file.text, cursor.Position, encoding, ViewEncoding),
 		}
 		if cursor.Range != nil {
-			newPos.Range = &lsp.Range{
-				Start: lsp.Position{
-					Line:      cursor.Range.Start.Line,
-					Character: CharIndexToRune(file.Lines[cursor.Range.Start.Line], cursor.Range.Start.Character),
-				},
-				End: lsp.Position{
-					Line:      cursor.Range.End.Line,
-					Character: CharIndexToRune(file.Lines[cursor.Range.End.Line], cursor.Range.End.Character),
-				},
-			}
+			rng := convertRange(file.text, *cursor.Range, encoding, ViewEncoding)
+			newPos.Range = &rng
 		}
 		newCursors = append(newCursors, newPos)
 	}
@@ -128,19 +174,28 @@ func (s *WorkspaceState) CursorMove(filename string, cursors []CursorPosition) {
 	})
 }
 
-func (s *WorkspaceState) OpenFile(filename string, text string, language string, updateCursor bool) {
+func (s *WorkspaceState) OpenFile(filename string, text string, language string, version int, updateCursor bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// The rope never changes its lines, so they can be shared
+	lines := SplitLines(text)
 	s.files[filename] = &File{
 		Filename: filename,
 		ID:       s.nextID,
-		Lines:    SplitLines(text),
 		Language: language,
+		text:     NewRope(lines),
+	}
+	s.files[filename].resetHistory(version)
+	s.files[filename].outline = outline.New(language, lines)
+	if _, ok := s.baselines[filename]; !ok {
+		s.baselines[filename] = lines
 	}
 	s.publish(OpenFileEvent{
 		Filename: filename,
 		ID:       s.nextID,
 		Language: language,
+		Version:  version,
+		Lines:    lines,
 	})
 
 	if updateCursor || s.view == nil {
@@ -165,75 +220,95 @@ func (s *WorkspaceState) CloseFile(filename string) {
 	defer s.mu.Unlock()
 	file := s.files[filename]
 	delete(s.files, filename)
+	s.resolveFileProposals(filename, ProposalClosed)
 	s.publish(CloseFileEvent{
 		FileID: file.ID,
 	})
+	s.notifyTextChanged()
 }
 
-func (s *WorkspaceState) ReplaceTextRanges(filename string, changes []lsp.TextDocumentContentChangeEvent, updateCursor bool) {
+func (s *WorkspaceState) ReplaceTextRanges(filename string, changes []lsp.TextDocumentContentChangeEvent, version int, updateCursor bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	file := s.files[filename]
-	var changeText []ChangeTextRange
-	newLines, changeText := applyTextChanges(file.Lines, changes)
+	newText, changeText, edits := applyTextChanges(file.text, changes, s.editorEncoding())
+	file.text = newText
+	file.recordEdits(version, edits)
+	for _, change := range changeText {
+		file.outline.Splice(change.StartLine, change.EndLine, len(change.Text))
+	}
 	s.publish(UpdateTextEvent{
 		FileID:  file.ID,
 		Changes: changeText,
+		Version: version,
 	})
+	s.notifyTextChanged()
+	s.transformProposals(file, edits)
 
-	if updateCursor {
-		lastChange := changeText[len(changeText)-1]
-		col := 0
-		changeLine := lastChange.Text[len(lastChange.Text)-1]
-		if lastChange.EndLine < len(file.Lines) {
-			col = longestCommonPrefix(file.Lines[lastChange.EndLine], changeLine)
-		} else {
-			col = len(changeLine)
-		}
+	if updateCursor && len(edits) > 0 {
+		// Put the cursor after the text of the last change
+		lastEdit := edits[len(edits)-1]
+		pos := transformPosition(lastEdit.Range.End, lastEdit)
 		s.view = &View{
 			FileID: file.ID,
 			Cursors: []CursorPosition{{
-				Position: lsp.Position{
-					Line:      lastChange.EndLine + len(lastChange.Text) - 1,
-					Character: col,
-				},
+				Position: convertPosition(file.text, pos, StateEncoding, ViewEncoding),
 			}},
 		}
 		s.publish(ChangeViewEvent{
 			View: *s.view,
 		})
 	}
-
-	file.Lines = newLines
 }
 
-func (s *WorkspaceState) ReplaceText(filename string, text string, updateCursor bool) {
+func (s *WorkspaceState) ReplaceText(filename string, text string, version int, updateCursor bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	newLines := SplitLines(text)
 	prev := s.files[filename]
+	if prev.text.Equal(newLines) {
+		// Nothing changed, e.g. the editor sent the whole file after changes
+		// we already have. Keep the history so that edits from viewers based
+		// on earlier versions can still be rebased.
+		prev.recordEdits(version, nil)
+		s.publish(ReplaceTextEvent{
+			FileID:  prev.ID,
+			Text:    newLines,
+			Version: version,
+		})
+		s.notifyTextChanged()
+		return
+	}
 	s.files[filename] = &File{
 		Filename: prev.Filename,
 		ID:       prev.ID,
 		Language: prev.Language,
-		Lines:    newLines,
+		text:     NewRope(newLines),
+		outline:  prev.outline,
 	}
+	s.files[filename].resetHistory(version)
+	prev.outline.Replace(len(newLines))
 	s.publish(ReplaceTextEvent{
-		FileID: prev.ID,
-		Text:   newLines,
+		FileID:  prev.ID,
+		Text:    newLines,
+		Version: version,
 	})
+	s.notifyTextChanged()
+	// There's no way to tell what changed, so assume the worst
+	s.resolveFileProposals(filename, ProposalConflict)
 
 	if updateCursor {
 		lnum := 0
 		col := 0
 		lnum = -1
-		for i, line := range prev.Lines {
+		for i := 0; i < prev.text.Len(); i++ {
+			line := prev.text.Line(i)
 			if i >= len(newLines) {
 				lnum = i
 				break
 			} else if line != newLines[i] {
 				lnum = i
-				col = longestCommonPrefix(line, newLines[i])
+				col = ViewEncoding.Character(newLines[i], longestCommonPrefix(line, newLines[i]))
 				break
 			}
 		}
@@ -265,6 +340,7 @@ func (s *WorkspaceState) GetFiles() []File {
 			Filename: value.Filename,
 			ID:       value.ID,
 			Language: value.Language,
+			Version:  value.Version,
 		})
 	}
 	return ret
@@ -272,28 +348,45 @@ func (s *WorkspaceState) GetFiles() []File {
 
 func (s *WorkspaceState) GetFile(filename string) File {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	f := s.files[filename]
-	lines := make([]string, len(f.Lines))
-	copy(lines, f.Lines)
 	file := File{
 		ID:       f.ID,
 		Filename: f.Filename,
 		Language: f.Language,
-		Lines:    lines,
+		Version:  f.Version,
 	}
+	text := f.text
+	s.mu.Unlock()
+	// The rope is a snapshot, so copying the lines doesn't need the lock
+	file.Lines = text.Strings()
 	return file
 }
 
+// GetOutline returns the symbols in a file
+func (s *WorkspaceState) GetOutline(filename string) ([]outline.Symbol, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[filename]
+	if f == nil {
+		return nil, fmt.Errorf("File is not open: %s", filename)
+	}
+	return f.outline.Symbols(f.text.Strings()), nil
+}
+
 func (s *WorkspaceState) GetView() *View {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return s.view
 }
 
+// longestCommonPrefix is the length in bytes of the characters that two strings
+// start with
 func longestCommonPrefix(s1 string, s2 string) int {
 	for i := 0; i < len(s1) && i < len(s2); i++ {
 		if s1[i] != s2[i] {
+			for i > 0 && !utf8.RuneStart(s1[i]) {
+				i--
+			}
 			return i
 		}
 	}
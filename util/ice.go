@@ -0,0 +1,50 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// How long to wait for ICE candidates that have to be included in a session
+// description
+const ICEGatherTimeout = 10 * time.Second
+
+// GatherCandidates is for when there is no way to trickle ICE candidates, so
+// they have to be included in the session description. It must be called
+// before SetLocalDescription. The returned channel closes once gathering is
+// done. If a TURN server is configured, it closes as soon as there's a relay
+// candidate, since that works from any network. Otherwise it closes at the
+// first server reflexive candidate.
+func GatherCandidates(peerConnection *webrtc.PeerConnection) <-chan struct{} {
+	enough := webrtc.ICECandidateTypeSrflx
+	if hasTURNServer(peerConnection.GetConfiguration().ICEServers) {
+		enough = webrtc.ICECandidateTypeRelay
+	}
+	ready := make(chan struct{})
+	var once sync.Once
+	done := func() {
+		once.Do(func() { close(ready) })
+	}
+	peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
+		// A nil candidate means gathering is complete
+		if c == nil || c.Typ == enough {
+			done()
+		}
+	})
+	time.AfterFunc(ICEGatherTimeout, done)
+	return ready
+}
+
+func hasTURNServer(servers []webrtc.ICEServer) bool {
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package util
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Compact tokens start with this so they can't be confused with the
+// base64-encoded JSON tokens used by the static WebRTC site
+const compactTokenPrefix = "p1."
+
+// PeerToken is exchanged out-of-band to set up a WebRTC connection without a
+// signal server
+type PeerToken struct {
+	Desc     *webrtc.SessionDescription `json:"desc"`
+	ClientID string                     `json:"client_id,omitempty"`
+	// What the viewer calls themselves. It is chosen by the viewer and not
+	// verified, so it is only good for telling viewers apart.
+	Name string `json:"name,omitempty"`
+	// Proves that the viewer knows the edit password. See EditProof.
+	EditProof string `json:"edit_proof,omitempty"`
+}
+
+// EncodePeerToken encodes a token either as base64 JSON (understood by the
+// static WebRTC site) or, if compact, in a much shorter form that only fits
+// the data channel sessions pair-ls creates. Compact tokens keep just the
+// parts of the SDP that differ between sessions (ICE credentials, DTLS
+// fingerprint and role, and candidates), and the rest is filled back in when
+// decoding.
+func EncodePeerToken(token PeerToken, compact bool) (string, error) {
+	if token.Desc == nil {
+		return "", errors.New("Peer token has no session description")
+	}
+	if !compact {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	var kind string
+	switch token.Desc.Type {
+	case webrtc.SDPTypeOffer:
+		kind = "o"
+	case webrtc.SDPTypeAnswer:
+		kind = "a"
+	default:
+		return "", errors.New("Peer token must be an offer or an answer")
+	}
+	fields, err := parseCompactSDP(token.Desc.SDP)
+	if err != nil {
+		return "", err
+	}
+	// The first line is the kind, client ID, name, and edit proof, separated
+	// by tabs
+	header := kind + token.ClientID
+	if token.Name != "" || token.EditProof != "" {
+		header += "\t" + strings.Map(dropControl, token.Name)
+	}
+	if token.EditProof != "" {
+		header += "\t" + token.EditProof
+	}
+	payload := header + "\n" + strings.Join(fields, "\n")
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write([]byte(payload)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return compactTokenPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodePeerToken decodes a token in either format, and reports whether it
+// was compact
+func DecodePeerToken(token string) (PeerToken, bool, error) {
+	token = strings.TrimSpace(token)
+	if strings.HasPrefix(token, compactTokenPrefix) {
+		ret, err := decodeCompactToken(strings.TrimPrefix(token, compactTokenPrefix))
+		return ret, true, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return PeerToken{}, false, err
+	}
+	var ret PeerToken
+	if err := json.Unmarshal(decoded, &ret); err != nil {
+		return ret, false, err
+	}
+	if ret.Desc == nil {
+		// Answers from the LSP server are a bare session description
+		var desc webrtc.SessionDescription
+		if err := json.Unmarshal(decoded, &desc); err != nil {
+			return ret, false, err
+		}
+		ret.Desc = &desc
+	}
+	if ret.Desc.SDP == "" {
+		return ret, false, errors.New("Peer token has no session description")
+	}
+	return ret, false, nil
+}
+
+func decodeCompactToken(token string) (PeerToken, error) {
+	var ret PeerToken
+	compressed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ret, err
+	}
+	payload, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return ret, err
+	}
+	lines := strings.Split(string(payload), "\n")
+	if len(lines) < 1+len(compactSDPAttributes) || len(lines[0]) == 0 {
+		return ret, errors.New("Invalid peer token")
+	}
+	desc := webrtc.SessionDescription{}
+	switch lines[0][0] {
+	case 'o':
+		desc.Type = webrtc.SDPTypeOffer
+	case 'a':
+		desc.Type = webrtc.SDPTypeAnswer
+	default:
+		return ret, errors.New("Invalid peer token")
+	}
+	if desc.SDP, err = buildCompactSDP(lines[1:]); err != nil {
+		return ret, err
+	}
+	ret.Desc = &desc
+	header := strings.SplitN(lines[0][1:], "\t", 3)
+	ret.ClientID = header[0]
+	if len(header) > 1 {
+		ret.Name = header[1]
+	}
+	if len(header) > 2 {
+		ret.EditProof = header[2]
+	}
+	return ret, nil
+}
+
+// The fields a compact token keeps, in order, followed by the candidates
+var compactSDPAttributes = []string{"ice-ufrag", "ice-pwd", "fingerprint", "setup", "mid"}
+
+// parseCompactSDP pulls the fields a compact token needs out of an SDP with a
+// single data channel section
+func parseCompactSDP(sdp string) ([]string, error) {
+	values := make(map[string]string)
+	candidates := make([]string, 0)
+	sections := 0
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "m=") {
+			if !strings.HasPrefix(line, "m=application ") {
+				return nil, errors.New("Compact peer tokens only support data channels")
+			}
+			sections++
+			continue
+		}
+		if !strings.HasPrefix(line, "a=") {
+			continue
+		}
+		pieces := strings.SplitN(line[2:], ":", 2)
+		if len(pieces) != 2 {
+			continue
+		}
+		if pieces[0] == "candidate" {
+			candidates = append(candidates, pieces[1])
+		} else {
+			values[pieces[0]] = pieces[1]
+		}
+	}
+	if sections != 1 {
+		return nil, errors.New("Compact peer tokens need exactly one data channel")
+	}
+	fields := make([]string, 0, len(compactSDPAttributes)+len(candidates))
+	for _, attr := range compactSDPAttributes {
+		value := values[attr]
+		if value == "" {
+			return nil, fmt.Errorf("Session description has no %s", attr)
+		}
+		if attr == "fingerprint" {
+			// Store the hex digest as base64, which is about half the size
+			pieces := strings.SplitN(value, " ", 2)
+			if len(pieces) != 2 {
+				return nil, errors.New("Invalid fingerprint in session description")
+			}
+			digest, err := hex.DecodeString(strings.ReplaceAll(pieces[1], ":", ""))
+			if err != nil {
+				return nil, errors.New("Invalid fingerprint in session description")
+			}
+			value = pieces[0] + " " + base64.RawURLEncoding.EncodeToString(digest)
+		}
+		fields = append(fields, value)
+	}
+	return append(fields, candidates...), nil
+}
+
+// buildCompactSDP is the reverse of parseCompactSDP. The rest of the session
+// description is what pion creates for a data channel.
+func buildCompactSDP(fields []string) (string, error) {
+	for _, field := range fields[:len(compactSDPAttributes)] {
+		if field == "" {
+			return "", errors.New("Invalid peer token")
+		}
+	}
+	pieces := strings.SplitN(fields[2], " ", 2)
+	if len(pieces) != 2 {
+		return "", errors.New("Invalid peer token")
+	}
+	digest, err := base64.RawURLEncoding.DecodeString(pieces[1])
+	if err != nil || len(digest) == 0 {
+		return "", errors.New("Invalid peer token")
+	}
+	hexDigest := make([]string, len(digest))
+	for i, b := range digest {
+		hexDigest[i] = fmt.Sprintf("%02X", b)
+	}
+	mid := fields[4]
+
+	lines := []string{
+		"v=0",
+		"o=- 0 0 IN IP4 0.0.0.0",
+		"s=-",
+		"t=0 0",
+		"a=fingerprint:" + pieces[0] + " " + strings.Join(hexDigest, ":"),
+		"a=group:BUNDLE " + mid,
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel",
+		"c=IN IP4 0.0.0.0",
+		"a=setup:" + fields[3],
+		"a=mid:" + mid,
+		"a=sendrecv",
+		"a=sctp-port:5000",
+		"a=ice-ufrag:" + fields[0],
+		"a=ice-pwd:" + fields[1],
+	}
+	for _, candidate := range fields[len(compactSDPAttributes):] {
+		lines = append(lines, "a=candidate:"+candidate)
+	}
+	lines = append(lines, "a=end-of-candidates")
+	return strings.Join(lines, "\r\n") + "\r\n", nil
+}
+
+// dropControl is for strings.Map, to remove the tabs and newlines that would
+// break the compact token header
+func dropControl(r rune) rune {
+	if unicode.IsControl(r) {
+		return -1
+	}
+	return r
+}
+
+// EditProof lets a viewer show that it knows the edit password without
+// sending it. It is an HMAC of the DTLS fingerprint in the viewer's session
+// description, so it can't be reused for any other connection.
+func EditProof(password string, sdp string) string {
+	if password == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(sdpFingerprint(sdp)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckEditProof reports whether proof was made from password and the
+// viewer's session description
+func CheckEditProof(password string, sdp string, proof string) bool {
+	if password == "" || proof == "" || sdpFingerprint(sdp) == "" {
+		return false
+	}
+	return hmac.Equal([]byte(EditProof(password, sdp)), []byte(proof))
+}
+
+func sdpFingerprint(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if strings.HasPrefix(line, "a=fingerprint:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "a=fingerprint:"))
+		}
+	}
+	return ""
+}
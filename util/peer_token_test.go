@@ -0,0 +1,219 @@
+package util
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func newOfferConnection(t *testing.T) *webrtc.PeerConnection {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordered := true
+	if _, err := pc.CreateDataChannel("messaging-channel", &webrtc.DataChannelInit{Ordered: &ordered}); err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func setLocalDescription(t *testing.T, pc *webrtc.PeerConnection, desc webrtc.SessionDescription) {
+	gathered := GatherCandidates(pc)
+	if err := pc.SetLocalDescription(desc); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+}
+
+func waitConnected(pc *webrtc.PeerConnection) <-chan struct{} {
+	connected := make(chan struct{})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+	return connected
+}
+
+func TestPeerTokenConnects(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		offerer := newOfferConnection(t)
+		defer offerer.Close()
+		answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer answerer.Close()
+		offererConnected := waitConnected(offerer)
+		answererConnected := waitConnected(answerer)
+
+		offer, err := offerer.CreateOffer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setLocalDescription(t, offerer, offer)
+		token, err := EncodePeerToken(PeerToken{
+			Desc:      offerer.LocalDescription(),
+			ClientID:  "abcDEF",
+			Name:      "al\nice",
+			EditProof: EditProof("edit", offerer.LocalDescription().SDP),
+		}, compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, isCompact, err := DecodePeerToken(token)
+		if err != nil {
+			t.Fatalf("compact=%v: %v", compact, err)
+		}
+		if isCompact != compact {
+			t.Errorf("compact=%v: decoded as compact=%v", compact, isCompact)
+		}
+		if data.Desc.Type != webrtc.SDPTypeOffer || data.ClientID != "abcDEF" {
+			t.Errorf("compact=%v: got %s with client ID %q", compact, data.Desc.Type, data.ClientID)
+		}
+		if compact && data.Name != "alice" {
+			t.Errorf("compact=%v: got name %q, want %q", compact, data.Name, "alice")
+		}
+		if !CheckEditProof("edit", data.Desc.SDP, data.EditProof) {
+			t.Errorf("compact=%v: edit proof did not survive the token", compact)
+		}
+
+		if err := answerer.SetRemoteDescription(*data.Desc); err != nil {
+			t.Fatalf("compact=%v: %v", compact, err)
+		}
+		answer, err := answerer.CreateAnswer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setLocalDescription(t, answerer, answer)
+		token, err = EncodePeerToken(PeerToken{Desc: answerer.LocalDescription()}, compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err = DecodePeerToken(token)
+		if err != nil {
+			t.Fatalf("compact=%v: %v", compact, err)
+		}
+		if err := offerer.SetRemoteDescription(*data.Desc); err != nil {
+			t.Fatalf("compact=%v: %v", compact, err)
+		}
+
+		for _, connected := range []<-chan struct{}{offererConnected, answererConnected} {
+			select {
+			case <-connected:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("compact=%v: peers did not connect", compact)
+			}
+		}
+	}
+}
+
+func TestCompactPeerTokenSize(t *testing.T) {
+	pc := newOfferConnection(t)
+	defer pc.Close()
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setLocalDescription(t, pc, offer)
+	token := PeerToken{Desc: pc.LocalDescription()}
+	compact, err := EncodePeerToken(token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := EncodePeerToken(token, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compact)*2 > len(full) {
+		t.Errorf("Compact token is %d bytes, full token is %d", len(compact), len(full))
+	}
+}
+
+func compactToken(payload string) string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(payload))
+	w.Close()
+	return compactTokenPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecodeInvalidPeerToken(t *testing.T) {
+	fingerprint := "sha-256 " + base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3})
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"not JSON", base64.StdEncoding.EncodeToString([]byte("hello"))},
+		{"no session description", base64.StdEncoding.EncodeToString([]byte(`{"client_id":"abc"}`))},
+		{"empty SDP", base64.StdEncoding.EncodeToString([]byte(`{"type":"offer","sdp":""}`))},
+		{"compact not base64", compactTokenPrefix + "!!!"},
+		{"compact not deflated", compactTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte("hello"))},
+		{"compact unknown kind", compactToken("x\nufrag\npwd\n" + fingerprint + "\nactpass\n0")},
+		{"compact missing fields", compactToken("o\nufrag\npwd")},
+		{"compact empty field", compactToken("o\nufrag\n\n" + fingerprint + "\nactpass\n0")},
+		{"compact bad fingerprint", compactToken("o\nufrag\npwd\nsha-256\nactpass\n0")},
+		{"compact empty header", compactToken("\nufrag\npwd\n" + fingerprint + "\nactpass\n0")},
+	}
+	for _, test := range tests {
+		if _, _, err := DecodePeerToken(test.token); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestEncodeInvalidPeerToken(t *testing.T) {
+	video := "v=0\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=ice-ufrag:a\r\n"
+	tests := []struct {
+		name  string
+		token PeerToken
+	}{
+		{"no session description", PeerToken{}},
+		{"rollback", PeerToken{Desc: &webrtc.SessionDescription{Type: webrtc.SDPTypeRollback, SDP: "v=0"}}},
+		{"no data channel", PeerToken{Desc: &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0\r\n"}}},
+		{"video", PeerToken{Desc: &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: video}}},
+		{"missing ICE credentials", PeerToken{Desc: &webrtc.SessionDescription{
+			Type: webrtc.SDPTypeOffer,
+			SDP:  "v=0\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\na=setup:actpass\r\n",
+		}}},
+	}
+	for _, test := range tests {
+		if _, err := EncodePeerToken(test.token, true); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestEditProof(t *testing.T) {
+	sdp := "v=0\r\na=fingerprint:sha-256 AB:CD\r\n"
+	other := "v=0\r\na=fingerprint:sha-256 AB:CE\r\n"
+	proof := EditProof("edit", sdp)
+	tests := []struct {
+		name     string
+		password string
+		sdp      string
+		proof    string
+		ok       bool
+	}{
+		{"matching", "edit", sdp, proof, true},
+		{"wrong password", "view", sdp, proof, false},
+		{"other session", "edit", other, proof, false},
+		{"no fingerprint", "edit", "v=0\r\n", EditProof("edit", "v=0\r\n"), false},
+		{"no proof", "edit", sdp, "", false},
+		{"no edit password", "", sdp, EditProof("", sdp), false},
+		{"garbage", "edit", sdp, "abc", false},
+	}
+	for _, test := range tests {
+		if ok := CheckEditProof(test.password, test.sdp, test.proof); ok != test.ok {
+			t.Errorf("%s: got %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
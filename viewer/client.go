@@ -0,0 +1,486 @@
+package viewer
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"pair-ls/outline"
+	"pair-ls/server"
+	"pair-ls/state"
+	"pair-ls/util"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Options configure how a Client connects to a share
+type Options struct {
+	// The web password, if the share has one. The edit password also works,
+	// and lets a viewer connected over WebRTC edit.
+	Password string
+	// Shown to the editor in its list of WebRTC viewers. Anyone can pick any
+	// name, so the editor can't rely on it.
+	Name       string
+	ICEServers []server.ICEServerConfig
+	// Used to exchange tokens by hand when connecting without a URL
+	In  io.Reader
+	Out io.Writer
+	// Called after every change to the workspace, with the notification that
+	// caused it and the ID of the file that changed (or -1). The workspace is
+	// locked, and this runs on the connection's read loop, so it must be quick.
+	OnChange func(w *Workspace, method string, fileID int32)
+}
+
+func (opts Options) peerAuth() PeerAuth {
+	return PeerAuth{
+		Name:     opts.Name,
+		Password: opts.Password,
+	}
+}
+
+// Client follows a shared workspace as a viewer, the same way the web viewer
+// does
+type Client struct {
+	mu        sync.Mutex
+	workspace *Workspace
+	conn      *jsonrpc2.Conn
+	closer    io.Closer
+	aead      cipher.AEAD
+	// Encrypted blobs must be for the workspace in the share URL, and arrive
+	// exactly once and in order
+	e2eWorkspace string
+	e2eSequence  server.E2ESequence
+	onChange     func(*Workspace, string, int32)
+	err          error
+	// Callbacks for streamed search results, by token
+	searches   map[string]func([]state.SearchMatch)
+	nextSearch int
+}
+
+// Dial connects to a share. The target is the share URL printed by the
+// editor (a pair-ls server, relay, or signal server), or a WebRTC token from
+// pair-ls lsp -manual. If the target is empty, a token is printed to
+// opts.Out for the editor to answer.
+func Dial(ctx context.Context, target string, opts Options) (*Client, error) {
+	c := &Client{
+		workspace: NewWorkspace(),
+		onChange:  opts.OnChange,
+		searches:  make(map[string]func([]state.SearchMatch)),
+	}
+	handler := jsonrpc2.HandlerWithError(c.handle)
+	onRecv := jsonrpc2.OnRecv(c.onRecv)
+
+	if !strings.Contains(target, "://") {
+		session, conn, err := Join(ctx, opts.ICEServers, opts.peerAuth(), target, handler, opts.In, opts.Out, onRecv)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.closer = session
+		return c, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	// The key for end-to-end encrypted workspaces is in the fragment (#k=...)
+	if values, err := url.ParseQuery(u.Fragment); err == nil && values.Get("k") != "" {
+		key, err := base64.RawURLEncoding.DecodeString(values.Get("k"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid encryption key in URL: %v", err)
+		}
+		if c.aead, err = server.NewE2ECipher(key); err != nil {
+			return nil, err
+		}
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("Unsupported URL scheme %s", u.Scheme)
+	}
+	path := strings.Trim(u.Path, "/")
+	c.e2eWorkspace = path
+	base := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+
+	signal, err := isSignalServer(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	if signal {
+		if path == "" {
+			return nil, errors.New("Signal server URL is missing the editor's token")
+		}
+		iceServers := opts.ICEServers
+		if len(iceServers) == 0 {
+			if iceServers, err = fetchICEServers(ctx, base, path); err != nil {
+				return nil, err
+			}
+		}
+		session, err := NewSession(iceServers, opts.peerAuth(), handler, onRecv)
+		if err != nil {
+			return nil, err
+		}
+		if err := session.Call(ctx, base, path); err != nil {
+			session.Close()
+			return nil, err
+		}
+		conn, err := session.Wait(ctx)
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		c.conn = conn
+		c.closer = session
+		return c, nil
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	err = postJSON(ctx, base+"/login", struct {
+		Password string `json:"password"`
+	}{
+		Password: opts.Password,
+	}, &login)
+	if err != nil {
+		return nil, err
+	}
+	wsURL := *u
+	wsURL.Scheme = "ws"
+	if u.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	}
+	wsURL.Path = "/client_ws"
+	wsURL.Fragment = ""
+	wsURL.RawQuery = ""
+	if path != "" {
+		wsURL.RawQuery = url.Values{"workspace": []string{path}}.Encode()
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = jsonrpc2.NewConn(
+		context.Background(),
+		jsonrpc2.NewBufferedStream(util.WrapWebsocket(ws), jsonrpc2.PlainObjectCodec{}),
+		handler,
+		onRecv,
+	)
+	c.closer = ws
+	err = c.conn.Call(ctx, "auth", struct {
+		Token string `json:"token"`
+	}{
+		Token: login.Token,
+	}, nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// The index page tells the web viewer whether to use WebRTC
+func isSignalServer(ctx context.Context, base string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/", nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(body), `rtc="true"`), nil
+}
+
+// Read calls fn with the workspace locked. fn must not keep references to the
+// workspace.
+func (c *Client) Read(fn func(*Workspace)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c.workspace)
+}
+
+// DisconnectNotify is closed when the connection to the share is lost
+func (c *Client) DisconnectNotify() <-chan struct{} {
+	return c.conn.DisconnectNotify()
+}
+
+// Err is the reason the client closed the connection, if any
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// SubmitEdit asks the sharer's editor to apply an edit. The share must be in
+// collaborative mode, and the viewer must have logged in with the edit
+// password.
+func (c *Client) SubmitEdit(ctx context.Context, req state.EditRequest) (state.EditResult, error) {
+	var result state.EditResult
+	if c.aead != nil {
+		return result, errors.New("Cannot edit an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "submitEdit", req, &result)
+	return result, err
+}
+
+// ProposeEdit suggests an edit to the sharer. The sharer's editor offers it as
+// a code action, and the result is sent to viewers as a proposalResolved
+// notification.
+func (c *Client) ProposeEdit(ctx context.Context, req state.ProposeEditRequest) (state.Proposal, error) {
+	var proposal state.Proposal
+	if c.aead != nil {
+		return proposal, errors.New("Cannot edit an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "proposeEdit", req, &proposal)
+	return proposal, err
+}
+
+// RequestFocus asks the sharer to look at part of a file
+func (c *Client) RequestFocus(ctx context.Context, req state.FocusRequest) (state.FocusResult, error) {
+	var result state.FocusResult
+	if c.aead != nil {
+		return result, errors.New("Cannot send requests to an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "requestFocus", req, &result)
+	return result, err
+}
+
+// GetOutline returns the symbols in a file. End-to-end encrypted shares can't
+// be asked for it, so it's computed here instead.
+func (c *Client) GetOutline(ctx context.Context, filename string) ([]outline.Symbol, error) {
+	if c.aead != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, file := range c.workspace.Files {
+			if file.Filename == filename {
+				return outline.New(file.Language, file.Lines).Symbols(file.Lines), nil
+			}
+		}
+		return nil, fmt.Errorf("File is not open: %s", filename)
+	}
+	var symbols []outline.Symbol
+	err := c.conn.Call(ctx, "getOutline", server.GetFileRequest{Filename: filename}, &symbols)
+	return symbols, err
+}
+
+// GetDiff returns how the shared files changed since the sharer opened them
+// (or since their last commit)
+func (c *Client) GetDiff(ctx context.Context, req state.DiffRequest) (state.DiffResult, error) {
+	var result state.DiffResult
+	if c.aead != nil {
+		return result, errors.New("Cannot send requests to an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "getDiff", req, &result)
+	return result, err
+}
+
+// Hover asks the sharer's language servers about the symbol at a position. It
+// returns nil if there is nothing to show.
+func (c *Client) Hover(ctx context.Context, req state.PositionRequest) (*state.Hover, error) {
+	var hover *state.Hover
+	if c.aead != nil {
+		return nil, errors.New("Cannot send requests to an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "hover", req, &hover)
+	return hover, err
+}
+
+// Definition asks the sharer's language servers where the symbol at a position
+// is defined
+func (c *Client) Definition(ctx context.Context, req state.PositionRequest) ([]state.Location, error) {
+	var locations []state.Location
+	if c.aead != nil {
+		return nil, errors.New("Cannot send requests to an end-to-end encrypted share")
+	}
+	err := c.conn.Call(ctx, "definition", req, &locations)
+	return locations, err
+}
+
+// Search finds text in the shared files. If onMatches is non-nil, matches are
+// passed to it as they arrive instead of being returned in the result.
+func (c *Client) Search(ctx context.Context, req state.SearchRequest, onMatches func([]state.SearchMatch)) (state.SearchResult, error) {
+	var result state.SearchResult
+	// End-to-end encrypted shares can't be searched by the server
+	if c.aead != nil {
+		c.mu.Lock()
+		files := make([]state.File, 0, len(c.workspace.Files))
+		for _, file := range c.workspace.Files {
+			files = append(files, *file)
+		}
+		c.mu.Unlock()
+		return state.SearchFiles(req, files, onMatches)
+	}
+	req.Token = ""
+	if onMatches != nil {
+		c.mu.Lock()
+		c.nextSearch++
+		req.Token = strconv.Itoa(c.nextSearch)
+		c.searches[req.Token] = onMatches
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.searches, req.Token)
+			c.mu.Unlock()
+		}()
+	}
+	err := c.conn.Call(ctx, "search", req, &result)
+	return result, err
+}
+
+func (c *Client) Close() error {
+	c.conn.Close()
+	return c.closer.Close()
+}
+
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+	go c.Close()
+}
+
+func (c *Client) handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	if req.Params == nil {
+		return nil, nil
+	}
+	switch req.Method {
+	case "searchResults":
+		var params state.SearchResultsNotification
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		onMatches := c.searches[params.Token]
+		c.mu.Unlock()
+		if onMatches != nil {
+			onMatches(params.Matches)
+		}
+	case "e2e/initialize":
+		var params server.E2EInitialize
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		if err := c.applyEncrypted(conn, server.E2ESnapshot, params.Snapshot); err != nil {
+			c.fail(err)
+			return nil, nil
+		}
+		for _, event := range params.Events {
+			if err := c.applyEncrypted(conn, server.E2EEvent, event); err != nil {
+				c.fail(err)
+				return nil, nil
+			}
+		}
+		if params.Identity != "" {
+			c.Read(func(w *Workspace) {
+				w.Identity = params.Identity
+			})
+		}
+	case "e2e/event":
+		var params server.E2EBlob
+		if err := json.Unmarshal(*req.Params, &params); err != nil {
+			return nil, err
+		}
+		if err := c.applyEncrypted(conn, server.E2EEvent, params.Data); err != nil {
+			c.fail(err)
+		}
+	default:
+		c.apply(conn, req.Method, *req.Params)
+	}
+	return nil, nil
+}
+
+func (c *Client) applyEncrypted(conn *jsonrpc2.Conn, expectedKind byte, data string) error {
+	if c.aead == nil {
+		return errors.New("Workspace is end-to-end encrypted, but the URL has no key")
+	}
+	kind, seq, plaintext, err := server.DecryptE2E(c.aead, c.e2eWorkspace, data)
+	if err != nil {
+		return err
+	}
+	if kind != expectedKind {
+		return fmt.Errorf("Expected encrypted message of kind %q, got %q", expectedKind, kind)
+	}
+	c.mu.Lock()
+	err = c.e2eSequence.Check(kind, seq)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var msg server.E2EMessage
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return err
+	}
+	c.apply(conn, msg.Method, msg.Params)
+	return nil
+}
+
+func (c *Client) apply(conn *jsonrpc2.Conn, method string, params json.RawMessage) {
+	c.mu.Lock()
+	fileID, err := c.workspace.Apply(method, params)
+	// Unencrypted servers don't send the text of opened files. Responses are
+	// applied in onRecv, in order with the notifications.
+	missing := make([]string, 0)
+	if err == nil && c.aead == nil {
+		switch method {
+		case "initialize":
+			for id, file := range c.workspace.Files {
+				if !c.workspace.Loaded(id) {
+					missing = append(missing, file.Filename)
+				}
+			}
+		case "openFile":
+			missing = append(missing, c.workspace.Files[fileID].Filename)
+		}
+	}
+	if err == nil && c.onChange != nil {
+		c.onChange(c.workspace, method, fileID)
+	}
+	c.mu.Unlock()
+	for _, filename := range missing {
+		conn.DispatchCall(context.Background(), "getText", server.GetFileRequest{Filename: filename})
+	}
+}
+
+func (c *Client) onRecv(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+	if req == nil || resp == nil || req.Method != "getText" || resp.Result == nil {
+		return
+	}
+	var file state.File
+	if err := json.Unmarshal(*resp.Result, &file); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current := c.workspace.Files[file.ID]
+	if current == nil || current.Filename != file.Filename || c.workspace.Loaded(file.ID) {
+		return
+	}
+	c.workspace.SetText(file.ID, file.Lines)
+	current.Version = file.Version
+	if c.onChange != nil {
+		c.onChange(c.workspace, "textReplaced", file.ID)
+	}
+}
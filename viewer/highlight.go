@@ -0,0 +1,145 @@
+package viewer
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind is the syntax class of a piece of a line
+type TokenKind int
+
+const (
+	TokenPlain TokenKind = iota
+	TokenKeyword
+	TokenString
+	TokenComment
+	TokenNumber
+)
+
+type syntax struct {
+	keywords map[string]bool
+	comments []string
+	quotes   string
+}
+
+func newSyntax(comments []string, quotes string, keywords string) *syntax {
+	kw := make(map[string]bool)
+	for _, word := range strings.Fields(keywords) {
+		kw[word] = true
+	}
+	return &syntax{keywords: kw, comments: comments, quotes: quotes}
+}
+
+var cLikeKeywords = "if else for while do switch case default break continue return goto " +
+	"struct union enum typedef const static extern void int char float double long short " +
+	"unsigned signed sizeof true false null"
+
+// Keyed by LSP language ID. This only needs to be good enough to make code
+// easier to read in a terminal, so block comments and multi-line strings
+// aren't handled.
+var syntaxes = map[string]*syntax{
+	"go": newSyntax([]string{"//"}, "\"'`",
+		"break case chan const continue default defer else fallthrough for func go goto if import "+
+			"interface map package range return select struct switch type var true false nil iota"),
+	"python": newSyntax([]string{"#"}, "\"'",
+		"and as assert async await break class continue def del elif else except finally for from "+
+			"global if import in is lambda nonlocal not or pass raise return try while with yield "+
+			"True False None self"),
+	"lua": newSyntax([]string{"--"}, "\"'",
+		"and break do else elseif end false for function goto if in local nil not or repeat return "+
+			"then true until while"),
+	"javascript": newSyntax([]string{"//"}, "\"'`",
+		"async await break case catch class const continue default delete do else export extends "+
+			"finally for function if import in instanceof let new of return static super switch this "+
+			"throw try typeof var void while yield true false null undefined"),
+	"rust": newSyntax([]string{"//"}, "\"",
+		"as async await break const continue crate else enum extern false fn for if impl in let loop "+
+			"match mod move mut pub ref return self Self static struct super trait true type unsafe use "+
+			"where while"),
+	"c":   newSyntax([]string{"//"}, "\"'", cLikeKeywords+" include define ifdef ifndef endif"),
+	"cpp": newSyntax([]string{"//"}, "\"'", cLikeKeywords+" class namespace template typename public private protected virtual new delete this nullptr auto using include define"),
+	"java": newSyntax([]string{"//"}, "\"'", cLikeKeywords+
+		" abstract boolean byte catch class extends final finally implements import instanceof interface new package private protected public super this throw throws try"),
+	"sh": newSyntax([]string{"#"}, "\"'",
+		"if then else elif fi for while until do done case esac function in return local export"),
+	"ruby": newSyntax([]string{"#"}, "\"'",
+		"alias and begin break case class def do else elsif end ensure false for if in module next nil "+
+			"not or redo rescue retry return self super then true undef unless until when while yield"),
+	"toml": newSyntax([]string{"#"}, "\"'", "true false"),
+	"yaml": newSyntax([]string{"#"}, "\"'", "true false null"),
+	"json": newSyntax(nil, "\"", "true false null"),
+}
+
+func init() {
+	syntaxes["typescript"] = syntaxes["javascript"]
+	syntaxes["typescriptreact"] = syntaxes["javascript"]
+	syntaxes["javascriptreact"] = syntaxes["javascript"]
+	syntaxes["shellscript"] = syntaxes["sh"]
+	syntaxes["bash"] = syntaxes["sh"]
+	syntaxes["zsh"] = syntaxes["sh"]
+}
+
+// Highlight returns the syntax class of each rune in the line
+func Highlight(language string, line string) []TokenKind {
+	runes := []rune(line)
+	kinds := make([]TokenKind, len(runes))
+	syn := syntaxes[language]
+	if syn == nil {
+		return kinds
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if isComment(syn, runes[i:]) {
+			for ; i < len(runes); i++ {
+				kinds[i] = TokenComment
+			}
+			break
+		}
+		if strings.ContainsRune(syn.quotes, r) {
+			kinds[i] = TokenString
+			i++
+			for ; i < len(runes); i++ {
+				kinds[i] = TokenString
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					kinds[i] = TokenString
+				} else if runes[i] == r {
+					i++
+					break
+				}
+			}
+			continue
+		}
+		if isWordRune(r) {
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			kind := TokenPlain
+			if unicode.IsDigit(r) {
+				kind = TokenNumber
+			} else if syn.keywords[string(runes[start:i])] {
+				kind = TokenKeyword
+			}
+			for j := start; j < i; j++ {
+				kinds[j] = kind
+			}
+			continue
+		}
+		i++
+	}
+	return kinds
+}
+
+func isComment(syn *syntax, runes []rune) bool {
+	for _, prefix := range syn.comments {
+		if strings.HasPrefix(string(runes), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,151 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"pair-ls/state"
+	"path/filepath"
+	"strings"
+)
+
+// Mirror keeps a copy of the shared files on disk, so that viewers can use
+// their own editor and tools on the code
+type Mirror struct {
+	dir        string
+	statusFile string
+	// Where each shared file was written
+	paths map[int32]string
+}
+
+// MirrorStatus is written to the status file whenever the sharer's view changes
+type MirrorStatus struct {
+	// The file the sharer is looking at, as shared and as mirrored
+	Filename string                 `json:"filename"`
+	Path     string                 `json:"path"`
+	Cursors  []state.CursorPosition `json:"cursors"`
+}
+
+// NewMirror writes files under dir. If statusFile is not empty, the sharer's
+// view is written there as JSON.
+func NewMirror(dir string, statusFile string) *Mirror {
+	return &Mirror{
+		dir:        dir,
+		statusFile: statusFile,
+		paths:      make(map[int32]string),
+	}
+}
+
+// Path is where a shared file is written. Shared filenames may be absolute or
+// contain "..", but the result is always inside the mirror directory.
+func (m *Mirror) Path(filename string) string {
+	rel := filepath.FromSlash(filename)
+	rel = strings.TrimPrefix(rel, filepath.VolumeName(rel))
+	return filepath.Join(m.dir, filepath.Clean(string(filepath.Separator)+rel))
+}
+
+// Sync updates the files on disk after a change to the workspace. It is meant
+// to be used as the OnChange callback of a Client.
+func (m *Mirror) Sync(w *Workspace, method string, fileID int32) error {
+	switch method {
+	case "initialize":
+		for id, path := range m.paths {
+			if w.Files[id] == nil || m.Path(w.Files[id].Filename) != path {
+				if err := m.remove(id); err != nil {
+					return err
+				}
+			}
+		}
+		for id := range w.Files {
+			if err := m.write(w, id); err != nil {
+				return err
+			}
+		}
+		return m.writeStatus(w)
+	case "openFile", "textReplaced", "updateText":
+		return m.write(w, fileID)
+	case "closeFile":
+		return m.remove(fileID)
+	case "updateView":
+		return m.writeStatus(w)
+	}
+	return nil
+}
+
+func (m *Mirror) write(w *Workspace, fileID int32) error {
+	file := w.Files[fileID]
+	if file == nil {
+		return nil
+	}
+	path := m.Path(file.Filename)
+	m.paths[fileID] = path
+	// Don't clobber the file with nothing while we wait for the text
+	if !w.Loaded(fileID) {
+		return nil
+	}
+	// Read-only, so that edits aren't made to the copy by mistake and then
+	// overwritten by the sharer's next change
+	return writeFileAtomic(path, []byte(strings.Join(file.Lines, "\n")), 0444)
+}
+
+func (m *Mirror) remove(fileID int32) error {
+	path, ok := m.paths[fileID]
+	if !ok {
+		return nil
+	}
+	delete(m.paths, fileID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Clean up directories that are now empty
+	for dir := filepath.Dir(path); dir != filepath.Clean(m.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) writeStatus(w *Workspace) error {
+	if m.statusFile == "" || w.View == nil {
+		return nil
+	}
+	status := MirrorStatus{
+		Cursors: w.View.Cursors,
+	}
+	if file := w.Files[w.View.FileID]; file != nil {
+		status.Filename = file.Filename
+		status.Path = m.Path(file.Filename)
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.statusFile, data, 0640)
+}
+
+// Write to a temporary file and rename it, so that editors and file watchers
+// never see a partially written file. Renaming also replaces files that are
+// read-only.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
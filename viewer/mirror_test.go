@@ -0,0 +1,99 @@
+package viewer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"pair-ls/server"
+	"pair-ls/state"
+	"path/filepath"
+	"testing"
+)
+
+func applyEvent(t *testing.T, w *Workspace, m *Mirror, method string, event interface{}) {
+	params, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID, err := w.Apply(method, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Sync(w, method, fileID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pair-ls-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := NewWorkspace()
+	m := NewMirror(dir, "")
+	path := filepath.Join(dir, "src", "main.go")
+
+	applyEvent(t, w, m, "initialize", server.InitializeClient{
+		Files: []state.File{{Filename: "src/main.go", ID: 1, Lines: []string{"package main"}}},
+	})
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package main" {
+		t.Errorf("Got %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0222 != 0 {
+		t.Errorf("Mirrored file is writable: %v", perm)
+	}
+	if os.Geteuid() != 0 {
+		if _, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			t.Error("Opened a mirrored file for writing")
+		}
+	}
+
+	// Read-only files can still be updated
+	applyEvent(t, w, m, "textReplaced", state.ReplaceTextEvent{FileID: 1, Text: []string{"package foo"}, Version: 2})
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package foo" {
+		t.Errorf("Got %q after an update", data)
+	}
+
+	applyEvent(t, w, m, "closeFile", state.CloseFileEvent{FileID: 1})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Closed file still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Errorf("Empty directory still exists: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Mirror directory was removed: %v", err)
+	}
+}
+
+func TestMirrorPath(t *testing.T) {
+	m := NewMirror("/tmp/mirror", "")
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"main.go", "/tmp/mirror/main.go"},
+		{"src/main.go", "/tmp/mirror/src/main.go"},
+		{"/etc/passwd", "/tmp/mirror/etc/passwd"},
+		{"../../etc/passwd", "/tmp/mirror/etc/passwd"},
+		{"a/../../b", "/tmp/mirror/b"},
+	}
+	for _, test := range tests {
+		if got := m.Path(test.filename); got != filepath.FromSlash(test.want) {
+			t.Errorf("%s: got %s, want %s", test.filename, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package viewer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"pair-ls/server"
+	"pair-ls/util"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Session is a WebRTC connection to a pair-ls LSP server, made by exchanging
+// tokens by hand
+type Session struct {
+	peerConnection *webrtc.PeerConnection
+	handler        jsonrpc2.Handler
+	ready          chan struct{}
+	failed         chan struct{}
+	conn           *jsonrpc2.Conn
+	clientID       string
+	auth           PeerAuth
+	closeOnce      sync.Once
+}
+
+// PeerAuth is what a viewer tells the editor about itself when connecting
+// over WebRTC
+type PeerAuth struct {
+	// Shown in the editor's list of viewers. It isn't verified.
+	Name string
+	// If this is the editor's edit password, the viewer may edit. Only a proof
+	// that the viewer knows it is sent (see util.EditProof).
+	Password string
+}
+
+// token creates the token for our side of the call
+func (s *Session) token() util.PeerToken {
+	desc := s.peerConnection.LocalDescription()
+	return util.PeerToken{
+		Desc:      desc,
+		ClientID:  s.clientID,
+		Name:      s.auth.Name,
+		EditProof: util.EditProof(s.auth.Password, desc.SDP),
+	}
+}
+
+// NewSession creates a connection that will send all messages from the
+// editor to handler
+func NewSession(iceServers []server.ICEServerConfig, auth PeerAuth, handler jsonrpc2.Handler, opts ...jsonrpc2.ConnOpt) (*Session, error) {
+	s := webrtc.SettingEngine{}
+	s.DetachDataChannels()
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: server.ICEServers(iceServers),
+	})
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		peerConnection: peerConnection,
+		handler:        handler,
+		auth:           auth,
+		ready:          make(chan struct{}),
+		failed:         make(chan struct{}),
+	}
+	ordered := true
+	dc, err := peerConnection.CreateDataChannel("messaging-channel", &webrtc.DataChannelInit{Ordered: &ordered})
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+	dc.OnOpen(func() {
+		raw, err := dc.Detach()
+		if err != nil {
+			session.fail()
+			return
+		}
+		session.conn = jsonrpc2.NewConn(
+			context.Background(),
+			jsonrpc2.NewBufferedStream(raw, jsonrpc2.PlainObjectCodec{}),
+			handler,
+			opts...,
+		)
+		close(session.ready)
+	})
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			session.fail()
+		}
+	})
+	return session, nil
+}
+
+func (s *Session) fail() {
+	s.closeOnce.Do(func() {
+		close(s.failed)
+	})
+}
+
+// Offer starts a call. Give the token to the editor (pair-ls lsp -call-token)
+// and pass its response to SetAnswer.
+func (s *Session) Offer() (string, error) {
+	offer, err := s.peerConnection.CreateOffer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err := s.setLocalDescription(offer); err != nil {
+		return "", err
+	}
+	return util.EncodePeerToken(s.token(), true)
+}
+
+// SetAnswer completes a call started with Offer
+func (s *Session) SetAnswer(token string) error {
+	data, _, err := util.DecodePeerToken(token)
+	if err != nil {
+		return err
+	}
+	if data.Desc.Type != webrtc.SDPTypeAnswer {
+		return errors.New("Token is not a WebRTC answer")
+	}
+	return s.peerConnection.SetRemoteDescription(*data.Desc)
+}
+
+// Answer responds to a call from the editor. The returned token must be given
+// back to the editor.
+func (s *Session) Answer(token string) (string, error) {
+	data, compact, err := util.DecodePeerToken(token)
+	if err != nil {
+		return "", err
+	}
+	if data.Desc.Type != webrtc.SDPTypeOffer {
+		return "", errors.New("Token is not a WebRTC offer")
+	}
+	s.clientID = data.ClientID
+	if err := s.peerConnection.SetRemoteDescription(*data.Desc); err != nil {
+		return "", err
+	}
+	answer, err := s.peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err := s.setLocalDescription(answer); err != nil {
+		return "", err
+	}
+	return util.EncodePeerToken(s.token(), compact)
+}
+
+// setLocalDescription waits until there are enough ICE candidates to include
+// in the token
+func (s *Session) setLocalDescription(desc webrtc.SessionDescription) error {
+	gathered := util.GatherCandidates(s.peerConnection)
+	if err := s.peerConnection.SetLocalDescription(desc); err != nil {
+		return err
+	}
+	<-gathered
+	return nil
+}
+
+// Wait blocks until the connection to the editor is established
+func (s *Session) Wait(ctx context.Context) (*jsonrpc2.Conn, error) {
+	select {
+	case <-s.ready:
+		return s.conn, nil
+	case <-s.failed:
+		return nil, errors.New("WebRTC connection failed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Session) Close() error {
+	return s.peerConnection.Close()
+}
+
+// Join connects to an editor, using the terminal to exchange tokens. If token
+// is empty, we start the call and ask for the editor's answer. Otherwise token
+// is the editor's offer, and we print the answer for the editor.
+func Join(ctx context.Context, iceServers []server.ICEServerConfig, auth PeerAuth, token string, handler jsonrpc2.Handler, in io.Reader, out io.Writer, opts ...jsonrpc2.ConnOpt) (*Session, *jsonrpc2.Conn, error) {
+	session, err := NewSession(iceServers, auth, handler, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == "" {
+		offer, err := session.Offer()
+		if err != nil {
+			session.Close()
+			return nil, nil, err
+		}
+		fmt.Fprintf(out, "Give this token to the editor (pair-ls lsp -call-token, or your editor's connect command):\n\n%s\n\nPaste the editor's response: ", offer)
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && answer == "" {
+			session.Close()
+			return nil, nil, err
+		}
+		if err := session.SetAnswer(answer); err != nil {
+			session.Close()
+			return nil, nil, err
+		}
+	} else {
+		answer, err := session.Answer(token)
+		if err != nil {
+			session.Close()
+			return nil, nil, err
+		}
+		fmt.Fprintf(out, "Give this response to the editor:\n\n%s\n\n", answer)
+	}
+	fmt.Fprintln(out, "Waiting for connection...")
+	conn, err := session.Wait(ctx)
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	return session, conn, nil
+}
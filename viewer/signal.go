@@ -0,0 +1,135 @@
+package viewer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"pair-ls/server"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// fetchICEServers asks a signal server which ICE servers to call the editor
+// registered under token with
+func fetchICEServers(ctx context.Context, baseURL string, token string) ([]server.ICEServerConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/ice_servers?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching ICE servers: %s", resp.Status)
+	}
+	var servers []server.BrowserICEServer
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, err
+	}
+	ret := make([]server.ICEServerConfig, 0, len(servers))
+	for _, s := range servers {
+		ret = append(ret, server.ICEServerConfig{
+			URLs:       s.URLs,
+			Username:   s.Username,
+			Credential: s.Credential,
+		})
+	}
+	return ret, nil
+}
+
+func postJSON(ctx context.Context, url string, body interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Call connects to the editor registered with a signal server under token
+func (s *Session) Call(ctx context.Context, baseURL string, token string) error {
+	offer, err := s.peerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := s.setLocalDescription(offer); err != nil {
+		return err
+	}
+	var call server.CallResponse
+	local := s.token()
+	err = postJSON(ctx, baseURL+"/call", struct {
+		Offer     *webrtc.SessionDescription `json:"offer"`
+		Token     string                     `json:"token"`
+		Name      string                     `json:"name,omitempty"`
+		EditProof string                     `json:"edit_proof,omitempty"`
+	}{
+		Offer:     local.Desc,
+		Token:     token,
+		Name:      local.Name,
+		EditProof: local.EditProof,
+	}, &call)
+	if err != nil {
+		return err
+	}
+	if call.Answer.Type != webrtc.SDPTypeAnswer {
+		return errors.New("Signal server did not return a WebRTC answer")
+	}
+	s.clientID = call.ClientID
+	if err := s.peerConnection.SetRemoteDescription(call.Answer); err != nil {
+		return err
+	}
+	go s.pollCandidates(ctx, baseURL, token)
+	return nil
+}
+
+// pollCandidates adds the editor's ICE candidates as they trickle in through
+// the signal server
+func (s *Session) pollCandidates(ctx context.Context, baseURL string, token string) {
+	params := struct {
+		Token    string `json:"token"`
+		ClientID string `json:"client_id"`
+	}{
+		Token:    token,
+		ClientID: s.clientID,
+	}
+	for {
+		select {
+		case <-s.ready:
+			return
+		case <-s.failed:
+			return
+		default:
+		}
+		var resp server.IceCandidatesResponse
+		if err := postJSON(ctx, baseURL+"/ice_candidates", params, &resp); err != nil {
+			return
+		}
+		for _, candidate := range resp.Candidates {
+			s.peerConnection.AddICECandidate(candidate)
+		}
+		if resp.Done {
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package viewer
+
+import (
+	"encoding/json"
+	"pair-ls/server"
+	"pair-ls/state"
+)
+
+// Workspace is a viewer's copy of the files shared by an editor
+type Workspace struct {
+	Files    map[int32]*state.File
+	View     *state.View
+	Identity string
+	// Unresolved edits proposed by viewers
+	Proposals map[int32]state.Proposal
+	// The sharer's git repository, if any
+	Repo *state.Repo
+	// The sharer's workspace folders, if there's more than one
+	Folders []string
+	// Files whose text we have. The editor doesn't always send the text along
+	// with a newly opened file.
+	loaded map[int32]bool
+}
+
+func NewWorkspace() *Workspace {
+	return &Workspace{
+		Files:     make(map[int32]*state.File),
+		Proposals: make(map[int32]state.Proposal),
+		loaded:    make(map[int32]bool),
+	}
+}
+
+// Loaded is true if we have the text of the file
+func (w *Workspace) Loaded(fileID int32) bool {
+	return w.loaded[fileID]
+}
+
+// SetText replaces the text of a file
+func (w *Workspace) SetText(fileID int32, lines []string) {
+	if file := w.Files[fileID]; file != nil {
+		if lines == nil {
+			lines = []string{}
+		}
+		file.Lines = lines
+		w.loaded[fileID] = true
+	}
+}
+
+// Apply updates the workspace with a notification from the editor. It returns
+// the ID of the file that changed, or -1 if no file changed.
+func (w *Workspace) Apply(method string, params json.RawMessage) (int32, error) {
+	switch method {
+	case "initialize":
+		var event server.InitializeClient
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.Files = make(map[int32]*state.File)
+		w.loaded = make(map[int32]bool)
+		for i := range event.Files {
+			file := event.Files[i]
+			w.Files[file.ID] = &file
+			if file.Lines != nil {
+				w.loaded[file.ID] = true
+			} else {
+				file.Lines = []string{}
+			}
+		}
+		w.View = event.View
+		w.Identity = event.Identity
+		w.Repo = event.Repo
+		w.Folders = event.Folders
+		w.Proposals = make(map[int32]state.Proposal)
+		for _, proposal := range event.Proposals {
+			w.Proposals[proposal.ID] = proposal
+		}
+		return -1, nil
+	case "openFile":
+		var event state.OpenFileEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.Files[event.ID] = &state.File{
+			Filename: event.Filename,
+			ID:       event.ID,
+			Language: event.Language,
+			Version:  event.Version,
+			Lines:    []string{},
+		}
+		delete(w.loaded, event.ID)
+		return event.ID, nil
+	case "closeFile":
+		var event state.CloseFileEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		delete(w.Files, event.FileID)
+		delete(w.loaded, event.FileID)
+		return event.FileID, nil
+	case "textReplaced":
+		var event state.ReplaceTextEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.SetText(event.FileID, event.Text)
+		if file := w.Files[event.FileID]; file != nil {
+			file.Version = event.Version
+		}
+		return event.FileID, nil
+	case "updateText":
+		var event state.UpdateTextEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		file := w.Files[event.FileID]
+		// Changes to text we don't have yet are already included when it arrives
+		if file == nil || !w.loaded[event.FileID] {
+			return -1, nil
+		}
+		for _, change := range event.Changes {
+			file.Lines = applyChange(file.Lines, change)
+		}
+		file.Version = event.Version
+		return event.FileID, nil
+	case "updateView":
+		var event state.ChangeViewEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.View = &event.View
+		return -1, nil
+	case "proposal":
+		var event state.ProposalEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.Proposals[event.Proposal.ID] = event.Proposal
+		return -1, nil
+	case "proposalResolved":
+		var event state.ResolveProposalEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		delete(w.Proposals, event.ID)
+		return -1, nil
+	case "updateRepo":
+		var event state.RepoEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.Repo = event.Repo
+		return -1, nil
+	case "updateFolders":
+		var event state.FoldersEvent
+		if err := json.Unmarshal(params, &event); err != nil {
+			return -1, err
+		}
+		w.Folders = event.Folders
+		return -1, nil
+	}
+	return -1, nil
+}
+
+// Snapshot returns the workspace in the format sent to new viewers
+func (w *Workspace) Snapshot() server.InitializeClient {
+	files := make([]state.File, 0, len(w.Files))
+	for _, file := range w.Files {
+		files = append(files, *file)
+	}
+	proposals := make([]state.Proposal, 0, len(w.Proposals))
+	for _, proposal := range w.Proposals {
+		proposals = append(proposals, proposal)
+	}
+	return server.InitializeClient{
+		View:      w.View,
+		Files:     files,
+		Identity:  w.Identity,
+		Proposals: proposals,
+		Repo:      w.Repo,
+		Folders:   w.Folders,
+	}
+}
+
+func applyChange(lines []string, change state.ChangeTextRange) []string {
+	start := clamp(change.StartLine, 0, len(lines))
+	end := clamp(change.EndLine+1, start, len(lines))
+	ret := make([]string, 0, len(lines)-(end-start)+len(change.Text))
+	ret = append(ret, lines[:start]...)
+	ret = append(ret, change.Text...)
+	return append(ret, lines[end:]...)
+}
+
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}